@@ -75,7 +75,7 @@ func print_lookup(sglf cglf.SGLF, allele_path [][]TileInfo) {
       }
 
       if !ok {
-        log.Fatal("could not find prefix (%s) in sglf (allele_idx %d, path_idx %d (%x))\n",
+        log.Fatalf("could not find prefix (%s) in sglf (allele_idx %d, path_idx %d (%x))\n",
           ti.PfxTag, allele_idx, path_idx, path_idx)
       }
 
@@ -94,7 +94,7 @@ func print_lookup(sglf cglf.SGLF, allele_path [][]TileInfo) {
         }
       }
       if var_idx == len(sglf.Lib[path][step]) {
-        log.Fatal("could not find tile element in library: allele_idx %d, path_idx %d (%x)",
+        log.Fatalf("could not find tile element in library: allele_idx %d, path_idx %d (%x)",
           allele_idx, path_idx, path_idx)
       }
 
@@ -159,7 +159,7 @@ func print_zipper(sglf cglf.SGLF, allele_path [][]TileInfo) {
       }
 
       if !ok {
-        log.Fatal("could not find prefix (%s) in sglf (allele_idx %d, step_idx %d (%x))\n",
+        log.Fatalf("could not find prefix (%s) in sglf (allele_idx %d, step_idx %d (%x))\n",
           ti0.PfxTag, 0, step_idx0, step_idx0)
       }
 
@@ -197,7 +197,7 @@ func print_zipper(sglf cglf.SGLF, allele_path [][]TileInfo) {
       }
 
       if !ok {
-        log.Fatal("could not find prefix (%s) in sglf (allele_idx %d, step_idx %d (%x))\n",
+        log.Fatalf("could not find prefix (%s) in sglf (allele_idx %d, step_idx %d (%x))\n",
           ti1.PfxTag, 1, step_idx1, step_idx1)
       }
 
@@ -12,12 +12,23 @@ import "github.com/abeconnelly/autoio"
 import "github.com/codegangsta/cli"
 
 import "strconv"
-import "io/ioutil"
 
 import "crypto/md5"
+import "math/rand"
+import "sort"
+import "io"
+import "bufio"
+import "bytes"
+import "sync"
+import "time"
+import "path/filepath"
+import "net/http"
+import "math"
+import "encoding/json"
 
 import "github.com/abeconnelly/cgf"
 import "github.com/abeconnelly/cglf"
+import "github.com/abeconnelly/cgf/synth"
 
 var VERSION_STR string = "0.2.0"
 var gVerboseFlag bool
@@ -32,11 +43,68 @@ var gShowKnotNocallInfoFlag bool = true
 
 var use_SGLF bool = true
 
+var gTagsetMap *cgf.TagsetMap
+
+// Record of annot-export's JSON output.  Values JSON can't hold (NaN,
+// infinities) are null.
+//
+type annotExportRecord struct {
+  Tilepos string `json:"tilepos"`
+  Path int `json:"path"`
+  Step int `json:"step"`
+  Name string `json:"name"`
+  Value []interface{} `json:"value"`
+}
+
+// Record of genes' JSON output, one per step of a gene that isn't
+// canonical.
+//
+type genesExportRecord struct {
+  Gene string `json:"gene"`
+  Id string `json:"id"`
+  Chrom string `json:"chrom"`
+  Start int `json:"start"`
+  End int `json:"end"`
+  Tilepos string `json:"tilepos"`
+  AnchorStep int `json:"anchor_step"`
+  Flags []string `json:"flags"`
+  VarId []int `json:"varid"`
+}
+
+// Record of freq's JSON output, one per tile variant.
+//
+type freqExportRecord struct {
+  Tile string `json:"tile"`
+  Path int `json:"path"`
+  Step int `json:"step"`
+  VarId int `json:"varid"`
+  Span int `json:"span"`
+  Count int `json:"count"`
+  Loq int `json:"loq"`
+  Masked int `json:"masked"`
+  Total int `json:"total"`
+  Freq json.Number `json:"freq"`
+}
+
+func file_md5sum(fn string) (string, error) {
+  f,e := cgf.StoreOpen(fn)
+  if e!=nil { return "", e }
+  defer f.Close()
+
+  h := md5.New()
+  _,e = io.Copy(h, io.NewSectionReader(f, 0, f.Size()))
+  if e!=nil { return "", e }
+
+  return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
 func _main( c *cli.Context ) {
   gShowKnotNocallInfoFlag = !c.Bool("hide-knot-low-quality")
 
   inp_slice := c.StringSlice("input")
 
+  gVerboseFlag = c.Bool("Verbose")
+
   cglf_lib_location := c.String("cglf")
 
 
@@ -66,11 +134,10 @@ func _main( c *cli.Context ) {
 
     header_bytes := cgf.CGFDefaultHeaderBytes()
 
-    f,err := os.Create(ocgf)
+    f,err := cgf.StoreCreate(ocgf)
     if err!=nil { log.Fatal(err) }
 
     f.Write(header_bytes)
-    f.Sync()
     f.Close()
 
     return
@@ -83,7 +150,7 @@ func _main( c *cli.Context ) {
       os.Exit(1)
     }
 
-    cgf_bytes,e := ioutil.ReadFile(c.String("cgf"))
+    cgf_bytes,e := cgf.StoreReadFile(c.String("cgf"))
     if e!=nil { log.Fatal(e) }
 
     hdri,dn := cgf.HeaderIntermediateFromBytes(cgf_bytes[:])
@@ -93,6 +160,9 @@ func _main( c *cli.Context ) {
     path,ver,step,e := cgf.ParseTilepos(c.String("tilepos"))
     if e!=nil { log.Fatal(e) }
 
+    path,ver,step,e = resolveTileposStep(c, &hdri, path, ver, step)
+    if e!=nil { log.Fatal(e) }
+
     _ = path
     _ = ver
     _ = step
@@ -103,7 +173,8 @@ func _main( c *cli.Context ) {
     if path >= len(hdri.StepPerPath) { log.Fatal("path out of range (max ", len(hdri.StepPerPath), " paths)") }
     if step>= hdri.StepPerPath[path] { log.Fatal("step out of range (max ", hdri.StepPerPath[path], " steps)") }
 
-    pathi,_ := cgf.PathIntermediateFromBytes(hdri.PathBytes[path])
+    pathi,e := cgf.HeaderIntermediateLoadPath(&hdri, path)
+    if e!=nil { log.Fatal(e) }
 
     tme,sf,lqf,xf := cgf.GetSimpleTileMapEntry(hdri.TileMap, pathi, step)
 
@@ -118,69 +189,92 @@ func _main( c *cli.Context ) {
       os.Exit(1)
     }
 
-    cgf_bytes,e := ioutil.ReadFile(c.String("cgf"))
+    cgf_bytes,e := cgf.StoreReadFile(c.String("cgf"))
     if e!=nil { log.Fatal(e) }
 
     hdri,dn := cgf.HeaderIntermediateFromBytes(cgf_bytes[:])
     _ = hdri
     _ = dn
 
-    path,ver,step,e := cgf.ParseTilepos(c.String("tilepos"))
+    // Every step of --region, or the single step of --tilepos.
+    //
+    path,ver,steps,e := tileposStepsFromContext(c, &hdri)
     if e!=nil { log.Fatal(e) }
 
     if path<0 { log.Fatal("path must be positive") }
-    if step<0 { log.Fatal("step must be positive") }
-
     if path >= len(hdri.StepPerPath) { log.Fatal("path out of range (max ", len(hdri.StepPerPath), " paths)") }
-    if step>= hdri.StepPerPath[path] { log.Fatal("step out of range (max ", hdri.StepPerPath[path], " steps)") }
+    for _,step := range steps {
+      if step<0 { log.Fatal("step must be positive") }
+      if step>= hdri.StepPerPath[path] { log.Fatal("step out of range (max ", hdri.StepPerPath[path], " steps)") }
+    }
 
-    pathi,_ := cgf.PathIntermediateFromBytes(hdri.PathBytes[path])
+    pathi,e := cgf.HeaderIntermediateLoadPath(&hdri, path)
+    if e!=nil { log.Fatal(e) }
 
-    knot := cgf.GetKnot(hdri.TileMap, pathi, step)
-    if knot==nil {
-      fmt.Printf("spanning tile?\n")
-    } else {
+    filt,e := knotFilterFromContext(c, func(path, step, varid, span int) int {
+      seq,e := cgf.CGLFGetLibSeq(uint64(path), uint64(step), uint64(varid), uint64(span), cglf_path)
+      if e!=nil || len(seq)==0 { return -1 }
+      return len(seq)
+    })
+    if e!=nil { log.Fatal(e) }
+
+    for _,step := range steps {
+      knot := cgf.GetKnot(hdri.TileMap, pathi, step)
+      keep := true
+      if knot!=nil {
+        knot,keep,e = filt.Apply(pathi, path, step, knot)
+        if e!=nil { log.Fatal(e) }
+      }
+
+      // Steps of a region inside a spanning tile were printed with the knot
+      // the tile is anchored at.
+      //
+      if knot==nil && keep && len(steps)==1 {
+        fmt.Printf("spanning tile?\n")
+      } else if knot!=nil && keep {
+
+        for i:=0; i<len(knot); i++ {
+          phase_str := "A"
+          if i==1 { phase_str = "B" }
 
-      for i:=0; i<len(knot); i++ {
-        phase_str := "A"
-        if i==1 { phase_str = "B" }
-
-        for j:=0; j<len(knot[i]); j++ {
-          fmt.Printf("%s %04x.%02x.%04x.%03x+%x",
-            phase_str,
-            path, ver,
-            knot[i][j].Step,
-            knot[i][j].VarId,
-            knot[i][j].Span)
-
-          seq := cgf.CGLFGetLibSeq(uint64(path),
-                                  uint64(knot[i][j].Step),
-                                  uint64(knot[i][j].VarId),
-                                  uint64(knot[i][j].Span),
-                                  cglf_path)
-
-          if len(knot[i][j].NocallStartLen)>0 {
-            fmt.Printf("*{")
-            for p:=0; p<len(knot[i][j].NocallStartLen); p+=2 {
-              if p>0 { fmt.Printf(";") }
-              fmt.Printf("%d+%d",
-                knot[i][j].NocallStartLen[p],
-                knot[i][j].NocallStartLen[p+1])
+          for j:=0; j<len(knot[i]); j++ {
+            fmt.Printf("%s %s", phase_str, cgf.TileIdString(path, ver, knot[i][j]))
+
+            if knot[i][j].VarId==cgf.KNOT_MASK_VARID {
+              fmt.Printf(" masked\n")
+              continue
+            }
+
+            seq,e := cgf.CGLFGetLibSeq(uint64(path),
+                                    uint64(knot[i][j].Step),
+                                    uint64(knot[i][j].VarId),
+                                    uint64(knot[i][j].Span),
+                                    cglf_path)
+            if e!=nil { log.Fatal(e) }
+
+            if len(knot[i][j].NocallStartLen)>0 {
+              fmt.Printf("*{")
+              for p:=0; p<len(knot[i][j].NocallStartLen); p+=2 {
+                if p>0 { fmt.Printf(";") }
+                fmt.Printf("%d+%d",
+                  knot[i][j].NocallStartLen[p],
+                  knot[i][j].NocallStartLen[p+1])
+              }
+              fmt.Printf("}")
+
+              noc_seq := cgf.FillNocSeq(seq, knot[i][j].NocallStartLen)
+              noc_m5str := cgf.Md5sum2str(md5.Sum([]byte(noc_seq)))
+              fmt.Printf(" %s\n%s\n", noc_m5str, noc_seq)
+            } else {
+              m5str := cgf.Md5sum2str(md5.Sum([]byte(seq)))
+              fmt.Printf(" %s\n%s\n", m5str, seq)
             }
-            fmt.Printf("}")
 
-            noc_seq := cgf.FillNocSeq(seq, knot[i][j].NocallStartLen)
-            noc_m5str := cgf.Md5sum2str(md5.Sum([]byte(noc_seq)))
-            fmt.Printf(" %s\n%s\n", noc_m5str, noc_seq)
-          } else {
-            m5str := cgf.Md5sum2str(md5.Sum([]byte(seq)))
-            fmt.Printf(" %s\n%s\n", m5str, seq)
           }
 
         }
 
       }
-
     }
 
     return
@@ -193,7 +287,7 @@ func _main( c *cli.Context ) {
     if len(tilepos_str)==0 { log.Fatal("missing tilepos") }
 
     if use_SGLF {
-      _sglf,e := cglf.LoadGenomeLibraryCSV(c.String("sglf"))
+      _sglf,e := cgf.LoadSGLF(c.String("sglf"))
       if e!=nil { log.Fatal(fmt.Sprintf("LoadGenomeLibraryCSV error (sglf): %v", e)) }
 
       for i:=0; i<len(inp_slice); i++ {
@@ -215,72 +309,57 @@ func _main( c *cli.Context ) {
     return
   } else if action == "fastj-range" {
 
-    tilepos_str := c.String("tilepos")
-
-    pos_parts := strings.Split(tilepos_str, ".")
-    if (len(pos_parts)!=2) && (len(pos_parts)!=3) {
-      fmt.Fprintf(os.Stderr, "Invalid tilepos\n")
-      cli.ShowAppHelp(c)
-      os.Exit(1)
-    }
-
-    path_range,e := parseIntOption(pos_parts[0], 16)
-    if e!=nil {
-      fmt.Fprintf(os.Stderr, "Invalid path in tilepos: %v\n", e)
-      cli.ShowAppHelp(c)
-      os.Exit(1)
-    }
-
-    pp:=1
-    if len(pos_parts)==3 { pp=2 }
-
-    step_range,e := parseIntOption(pos_parts[pp], 16)
+    tilepos_path,tilepos_ver,step_range,e := tileposFromContext(c)
     if e!=nil {
-      fmt.Fprintf(os.Stderr, "Invalid step in tilepos: %v\n", e)
+      fmt.Fprintf(os.Stderr, "Invalid tilepos: %v\n", e)
       cli.ShowAppHelp(c)
       os.Exit(1)
     }
-
-    if len(tilepos_str)==0 { log.Fatal("missing tilepos") }
+    path_range := [][2]int64{ [2]int64{ int64(tilepos_path), int64(tilepos_path+1) } }
 
     if len(c.String("sglf"))>0 { use_SGLF = true }
 
     if use_SGLF {
-      _sglf,e := cglf.LoadGenomeLibraryCSV(c.String("sglf")) ; _ = _sglf
+      _sglf,e := cgf.LoadSGLF(c.String("sglf")) ; _ = _sglf
       if e!=nil { log.Fatal(e) }
 
       if len(c.String("cgf"))!=0 {
         inp_slice = append(inp_slice, c.String("cgf"))
       }
 
+      filt,e := knotFilterFromContext(c, sglfTileLen(&_sglf))
+      if e!=nil { log.Fatal(e) }
+
       for i:=0; i<len(inp_slice); i++ {
-        cgf_bytes,e := ioutil.ReadFile(inp_slice[i])
+        cgf_bytes,e := cgf.StoreReadFile(inp_slice[i])
         if e!=nil { log.Fatal(e) }
 
-        path := path_range[0][0]
-
-        hdri,_ := cgf.HeaderIntermediateFromBytes(cgf_bytes) ; _ = hdri
-        pathi,_ := cgf.PathIntermediateFromBytes(hdri.PathBytes[path]) ; _ = pathi
-
         hdri,dn := cgf.HeaderIntermediateFromBytes(cgf_bytes)
         if dn<0 { log.Fatal("could not construct header from bytes") }
 
-        patho,dn := cgf.PathIntermediateFromBytes(hdri.PathBytes[path])
-        if dn<0 { log.Fatal("could not construct path") }
+        rpath,ver,ranges,e := resolveTileposRange(c, &hdri, int(path_range[0][0]), tilepos_ver, step_range)
+        if e!=nil { log.Fatal(inp_slice[i], ": ", e) }
+        path := int64(rpath)
+
+        patho,e := cgf.HeaderIntermediateLoadPath(&hdri, int(path))
+        if e!=nil { log.Fatal(e) }
 
         tilemap_bytes,_ := cgf.CGFTilemapBytes(cgf_bytes)
         tilemap := cgf.UnpackTileMap(tilemap_bytes)
 
-        for step_idx:=0; step_idx<len(step_range); step_idx++ {
-          if step_range[step_idx][1] == -1 {
-            step_range[step_idx][1] = int64(hdri.StepPerPath[path])
+        for step_idx:=0; step_idx<len(ranges); step_idx++ {
+          if ranges[step_idx][1] == -1 {
+            ranges[step_idx][1] = int64(hdri.StepPerPath[path])
           }
         }
 
-        for stepr_idx:=0; stepr_idx<len(step_range); stepr_idx++ {
-          for step:=step_range[stepr_idx][0]; step<step_range[stepr_idx][1]; step++ {
+        for stepr_idx:=0; stepr_idx<len(ranges); stepr_idx++ {
+          for step:=ranges[stepr_idx][0]; step<ranges[stepr_idx][1]; step++ {
             knot := cgf.GetKnot(tilemap, patho, int(step))
-            cgf.PrintKnotFastjSGLF(knot, _sglf, uint64(path), 0, hdri)
+            knot,keep,e := filt.Apply(patho, int(path), int(step), knot)
+            if e!=nil { log.Fatal(e) }
+            if !keep { continue }
+            cgf.PrintKnotFastjSGLF(knot, _sglf, uint64(path), uint64(ver), hdri)
           }
         }
 
@@ -292,7 +371,7 @@ func _main( c *cli.Context ) {
       }
 
       for i:=0; i<len(inp_slice); i++ {
-        cgf_bytes,e := ioutil.ReadFile(inp_slice[i])
+        cgf_bytes,e := cgf.StoreReadFile(inp_slice[i])
         if e!=nil { log.Fatal(e) }
 
         path := path_range[0][0]
@@ -303,14 +382,11 @@ func _main( c *cli.Context ) {
 
         os.Exit(0)
 
-        hdri,_ := cgf.HeaderIntermediateFromBytes(cgf_bytes) ; _ = hdri
-        pathi,_ := cgf.PathIntermediateFromBytes(hdri.PathBytes[path]) ; _ = pathi
-
         hdri,dn := cgf.HeaderIntermediateFromBytes(cgf_bytes)
         if dn<0 { log.Fatal("could not construct header from bytes") }
 
-        patho,dn := cgf.PathIntermediateFromBytes(hdri.PathBytes[path])
-        if dn<0 { log.Fatal("could not construct path") }
+        patho,e := cgf.HeaderIntermediateLoadPath(&hdri, int(path))
+        if e!=nil { log.Fatal(e) }
 
         tilemap_bytes,_ := cgf.CGFTilemapBytes(cgf_bytes)
         tilemap := cgf.UnpackTileMap(tilemap_bytes)
@@ -324,7 +400,7 @@ func _main( c *cli.Context ) {
         for stepr_idx:=0; stepr_idx<len(step_range); stepr_idx++ {
           for step:=step_range[stepr_idx][0]; step<step_range[stepr_idx][1]; step++ {
             knot := cgf.GetKnot(tilemap, patho, int(step))
-            cgf.PrintKnotFastjSGLF(knot, _sglf, uint64(path), 0, hdri)
+            cgf.PrintKnotFastjSGLF(knot, _sglf, uint64(path), uint64(tilepos_ver), hdri)
           }
         }
 
@@ -335,7 +411,7 @@ func _main( c *cli.Context ) {
     return
   } else if action == "knot-z" {
 
-    cgf_bytes,e := ioutil.ReadFile(c.String("cgf")) ; _ = cgf_bytes
+    cgf_bytes,e := cgf.StoreReadFile(c.String("cgf")) ; _ = cgf_bytes
     if e!=nil { log.Fatal(e) }
 
     path,ver,step,e := cgf.ParseTilepos(c.String("tilepos"))
@@ -350,54 +426,65 @@ func _main( c *cli.Context ) {
     return
   } else if action == "knot-2" {
 
-    cgf_bytes,e := ioutil.ReadFile(c.String("cgf"))
+    cgf_bytes,e := cgf.StoreReadFile(c.String("cgf"))
     if e!=nil { log.Fatal(e) }
 
     hdri,dn := cgf.HeaderIntermediateFromBytes(cgf_bytes[:])
     _ = hdri
     _ = dn
 
-    path,ver,step,e := cgf.ParseTilepos(c.String("tilepos"))
+    // Every step of --region, or the single step of --tilepos.
+    //
+    path,ver,steps,e := tileposStepsFromContext(c, &hdri)
     if e!=nil { log.Fatal(e) }
 
     if path<0 { log.Fatal("path must be positive") }
-    if step<0 { log.Fatal("step must be positive") }
-
     if path >= len(hdri.StepPerPath) { log.Fatal("path out of range (max ", len(hdri.StepPerPath), " paths)") }
-    if step>= hdri.StepPerPath[path] { log.Fatal("step out of range (max ", hdri.StepPerPath[path], " steps)") }
+    for _,step := range steps {
+      if step<0 { log.Fatal("step must be positive") }
+      if step>= hdri.StepPerPath[path] { log.Fatal("step out of range (max ", hdri.StepPerPath[path], " steps)") }
+    }
 
-    pathi,_ := cgf.PathIntermediateFromBytes(hdri.PathBytes[path])
+    pathi,e := cgf.HeaderIntermediateLoadPath(&hdri, path)
+    if e!=nil { log.Fatal(e) }
 
-    knot := cgf.GetKnot(hdri.TileMap, pathi, step)
-    if knot==nil {
-      fmt.Printf("spanning tile?")
-    } else {
+    var tile_len func(path, step, varid, span int) int
+    if len(c.String("sglf"))>0 {
+      _sglf,e := cgf.LoadSGLF(c.String("sglf"))
+      if e!=nil { log.Fatal(e) }
+      tile_len = sglfTileLen(&_sglf)
+    }
 
-      for i:=0; i<len(knot); i++ {
-        for j:=0; j<len(knot[i]); j++ {
-          if j>0 { fmt.Printf(" ") }
-          fmt.Printf("%04x.%02x.%04x.%03x+%x",
-            path, ver,
-            knot[i][j].Step,
-            knot[i][j].VarId,
-            knot[i][j].Span)
+    filt,e := knotFilterFromContext(c, tile_len)
+    if e!=nil { log.Fatal(e) }
 
-          if gShowKnotNocallInfoFlag {
-            if len(knot[i][j].NocallStartLen)>0 {
-              fmt.Printf("*{")
-              for p:=0; p<len(knot[i][j].NocallStartLen); p+=2 {
-                if p>0 { fmt.Printf(";") }
-                fmt.Printf("%d+%d",
-                  knot[i][j].NocallStartLen[p],
-                  knot[i][j].NocallStartLen[p+1])
-              }
-              fmt.Printf("}")
+    for _,step := range steps {
+      knot := cgf.GetKnot(hdri.TileMap, pathi, step)
+      keep := true
+      if knot!=nil {
+        knot,keep,e = filt.Apply(pathi, path, step, knot)
+        if e!=nil { log.Fatal(e) }
+      }
+
+      // As with knot, spanned steps of a region are skipped.
+      //
+      if knot==nil && keep && len(steps)==1 {
+        fmt.Printf("spanning tile?")
+      } else if knot!=nil && keep {
+
+        for i:=0; i<len(knot); i++ {
+          for j:=0; j<len(knot[i]); j++ {
+            if j>0 { fmt.Printf(" ") }
+            if gShowKnotNocallInfoFlag {
+              fmt.Printf("%s", cgf.TileIdNocallString(path, ver, knot[i][j]))
+            } else {
+              fmt.Printf("%s", cgf.TileIdString(path, ver, knot[i][j]))
             }
           }
+          fmt.Printf("\n")
         }
-        fmt.Printf("\n")
-      }
 
+      }
     }
 
 
@@ -405,7 +492,7 @@ func _main( c *cli.Context ) {
 
   } else if action == "sglfbarf" {
 
-    _sglf,e := cglf.LoadGenomeLibraryCSV(c.String("sglf"))
+    _sglf,e := cgf.LoadSGLF(c.String("sglf"))
     if e!=nil { log.Fatal(e) }
 
     for path := range _sglf.LibInfo {
@@ -423,7 +510,7 @@ func _main( c *cli.Context ) {
     return
   } else if action == "append" {
 
-    _sglf,e := cglf.LoadGenomeLibraryCSV(c.String("sglf"))
+    _sglf,e := cgf.LoadSGLF(c.String("sglf"))
     if e!=nil { log.Fatal(e) }
 
     //DEBUG
@@ -449,7 +536,7 @@ func _main( c *cli.Context ) {
     if e!=nil { log.Fatal(e) }
     path:=int(path_u64)
 
-    cgf_bytes,e := ioutil.ReadFile(c.String("cgf"))
+    cgf_bytes,e := cgf.StoreReadFile(c.String("cgf"))
     if e!=nil { log.Fatal(e) }
 
     hdri,_ := cgf.HeaderIntermediateFromBytes(cgf_bytes[:])
@@ -463,168 +550,1718 @@ func _main( c *cli.Context ) {
     ctx.SGLF = &_sglf
     ctx.ConstructTileMapLookup()
 
-    allele_path,e := cgf.LoadSampleFastj(&ain_slice[0])
+    // Without --unphased or --phase-blocks the phase of the path isn't
+    // recorded (the FastJ allele order is kept, as before).
+    //
+    phase := cgf.PathPhase{}
+    if c.Bool("unphased") {
+      if len(c.String("phase-blocks"))>0 { log.Fatal("--unphased and --phase-blocks are exclusive") }
+      phase.Phase = cgf.CGF_PHASE_UNPHASED
+    } else if len(c.String("phase-blocks"))>0 {
+      phase.Phase = cgf.CGF_PHASE_PHASED
+    }
+    ctx.Phase = map[int]cgf.PathPhase{ path:phase }
+
+    allele_path,tagset,e := cgf.LoadSampleFastjTagset(&ain_slice[0])
     if e!=nil { log.Fatal(e) }
 
+    if len(c.String("canon-table"))>0 {
+      ct_ver := tagset
+      if ct_ver<0 { ct_ver = 0 }
+      ctx.CanonTable,e = cgf.LoadCanonTable(c.String("canon-table"), map[int]int{ path:ct_ver })
+      if e!=nil { log.Fatal(e) }
+      e = ctx.CanonTable.Check(&_sglf)
+      if e!=nil { log.Fatal(e) }
+    }
+
     PathBytes,e := ctx.EmitPathBytes(path, allele_path)
     if e!=nil { log.Fatal(e) }
 
     cgf.HeaderIntermediateAddPath(&hdri, path, PathBytes)
-    cgf.WriteCGFFromIntermediate(c.String("output"), &hdri)
-
-    return
-  } else if action == "peel" {
 
-    path,ver,step,e := cgf.ParseTilepos(c.String("tilepos"))
+    e = cgf.HeaderIntermediateSetPathPloidy(&hdri, path, len(allele_path))
     if e!=nil { log.Fatal(e) }
-    _ = path ; _ = ver ; _ = step
 
+    e = cgf.HeaderIntermediateSetPathTagset(&hdri, path, tagset)
+    if e!=nil { log.Fatal(e) }
 
-    cgf_bytes,e := ioutil.ReadFile(c.String("cgf"))
+    e = cgf.HeaderIntermediateSetPathCanon(&hdri, path, ctx.CanonTable[path])
     if e!=nil { log.Fatal(e) }
 
-    fmt.Printf("path %x, ver %x, step %x\n", path, ver, step)
+    if len(c.String("phase-blocks"))>0 {
+      b,e := cgf.StoreReadFile(c.String("phase-blocks"))
+      if e!=nil { log.Fatal(e) }
+      tmap,e := tagsetMapFromContext(c)
+      if e!=nil { log.Fatal(e) }
+      blocks,e := cgf.PhaseBlocksFromBytes(&hdri, tmap, b)
+      if e!=nil { log.Fatal(c.String("phase-blocks"), ": ", e) }
+      for p := range blocks {
+        if p!=path { log.Fatal(c.String("phase-blocks"), ": phase block on path ", fmt.Sprintf("%04x", p), ", appending path ", fmt.Sprintf("%04x", path)) }
+      }
+      phase.Block = blocks[path]
+    }
+    e = cgf.HeaderIntermediateSetPathPhase(&hdri, path, phase)
+    if e!=nil { log.Fatal(e) }
 
-    cgf.Peel(cgf_bytes, path, step)
+    // Annotation of the path being replaced doesn't describe the new one.
+    //
+    e = cgf.HeaderIntermediateSetPathAnnot(&hdri, path, nil)
+    if e!=nil { log.Fatal(e) }
+    if len(c.String("annotation"))>0 {
+      b,e := cgf.StoreReadFile(c.String("annotation"))
+      if e!=nil { log.Fatal(e) }
+      tmap,e := tagsetMapFromContext(c)
+      if e!=nil { log.Fatal(e) }
+      e = cgf.HeaderIntermediateImportAnnot(&hdri, tmap, b)
+      if e!=nil { log.Fatal(c.String("annotation"), ": ", e) }
+    }
 
-    return
-  }
+    meta,e := cgf.HeaderIntermediateMeta(&hdri)
+    if e!=nil { log.Fatal(e) }
 
-  ain_slice := make([]autoio.AutoioHandle, 0, 8)
-  for i:=0; i<len(inp_slice); i++ {
-    inp_fn := inp_slice[i]
-    ain,err := autoio.OpenReadScanner(inp_fn) ; _ = ain
-    if err!=nil {
-      fmt.Fprintf(os.Stderr, "%v", err)
-      os.Exit(1)
+    if _,ok := meta[cgf.CGF_META_CREATION_TIME] ; !ok {
+      meta[cgf.CGF_META_CREATION_TIME] = time.Now().UTC().Format(time.RFC3339)
     }
-    defer ain.Close()
-    ain_slice = append(ain_slice, ain)
-  }
-
+    if len(c.String("sample-id"))>0 {
+      meta[cgf.CGF_META_SAMPLE_ID] = c.String("sample-id")
+    }
+    meta[cgf.CGF_META_ENCODER_VERSION] = "cgf " + VERSION_STR + " (cgf " + cgf.VERSION_STR + ")"
+    meta[cgf.CGF_META_LIBRARY] = c.String("sglf")
+    lib_m5,e := file_md5sum(c.String("sglf"))
+    if e!=nil { log.Fatal(e) }
+    meta[cgf.CGF_META_LIBRARY_MD5] = lib_m5
 
-  aout,err := autoio.CreateWriter( c.String("output") ) ; _ = aout
-  if err!=nil {
-    fmt.Fprintf(os.Stderr, "%v", err)
-    os.Exit(1)
-  }
-  defer func() { aout.Flush() ; aout.Close() }()
+    fj_m5,e := file_md5sum(inp_slice[0])
+    if e!=nil { log.Fatal(e) }
+    meta[cgf.MetaPathKey(path, "fastj")] = inp_slice[0]
+    meta[cgf.MetaPathKey(path, "fastj-md5")] = fj_m5
+    meta[cgf.MetaPathKey(path, "command-line")] = strings.Join(os.Args, " ")
+    cgf.HeaderIntermediateSetMetaMap(&hdri, meta)
 
-  if c.Bool( "pprof" ) {
-    gProfileFlag = true
-    gProfileFile = c.String("pprof-file")
-  }
+    if c.IsSet("compress") {
+      code,e := cgf.CompressionCode(c.String("compress"))
+      if e!=nil { log.Fatal(e) }
+      for p:=0; p<len(hdri.StepPerPath); p++ {
+        e = cgf.HeaderIntermediateCompressPath(&hdri, p, code)
+        if e!=nil { log.Fatal(e) }
+      }
+    }
 
-  if c.Bool( "mprof" ) {
-    gMemProfileFlag = true
-    gMemProfileFile = c.String("mprof-file")
-  }
+    if c.IsSet("checksum") {
+      algo,e := cgf.ChecksumAlgorithm(c.String("checksum"))
+      if e!=nil { log.Fatal(e) }
+      e = cgf.HeaderIntermediateSetChecksum(&hdri, algo)
+      if e!=nil { log.Fatal(e) }
+    }
 
-  gVerboseFlag = c.Bool("Verbose")
+    cgf.WriteCGFFromIntermediate(c.String("output"), &hdri)
 
-  if c.Int("max-procs") > 0 {
-    runtime.GOMAXPROCS( c.Int("max-procs") )
-  }
+    return
+  } else if action == "verify" {
 
-  if gProfileFlag {
-    prof_f,err := os.Create( gProfileFile )
-    if err != nil {
-      fmt.Fprintf( os.Stderr, "Could not open profile file %s: %v\n", gProfileFile, err )
-      os.Exit(2)
+    if len(c.String("cgf"))!=0 {
+      inp_slice = append(inp_slice, c.String("cgf"))
     }
 
-    pprof.StartCPUProfile( prof_f )
-    defer pprof.StopCPUProfile()
-  }
+    bad_count := 0
+    for i:=0; i<len(inp_slice); i++ {
+      cgf_bytes,e := cgf.StoreReadFile(inp_slice[i])
+      if e!=nil { log.Fatal(e) }
 
+      hdri,dn := cgf.HeaderIntermediateFromBytes(cgf_bytes)
+      if dn<0 {
+        fmt.Printf("%s: header truncated or corrupt\n", inp_slice[i])
+        bad_count++
+        continue
+      }
 
-  _sglf,e := cglf.LoadGenomeLibraryCSV(c.String("sglf"))
-  if e!=nil { log.Fatal(e) }
+      algo := cgf.CGF_CHECKSUM_NONE
+      e = cgf.HeaderIntermediateVerifyHeader(&hdri)
+      if e==nil { algo,e = cgf.HeaderIntermediateChecksumAlgorithm(&hdri) }
+      if e!=nil {
+        fmt.Printf("%s: header: %v\n", inp_slice[i], e)
+        bad_count++
+      } else if algo==cgf.CGF_CHECKSUM_NONE {
+        fmt.Printf("%s: no checksum table, checking path lengths only\n", inp_slice[i])
+      }
 
-  ctx := cgf.CGFContext{}
-  _cgf := cgf.CGF{}
+      for path:=0; path<len(hdri.StepPerPath); path++ {
+        e = cgf.HeaderIntermediateVerifyPath(&hdri, path)
+        if e!=nil {
+          fmt.Printf("%s: %v\n", inp_slice[i], e)
+          bad_count++
+        } else if gVerboseFlag {
+          fmt.Printf("%s: path %04x: ok (%s)\n", inp_slice[i], path, cgf.ChecksumAlgorithmName(algo))
+        }
+      }
+    }
 
-  header_bytes := cgf.CGFDefaultHeaderBytes()
-  cgf.CGFFillHeader(&_cgf, header_bytes)
+    if bad_count>0 { os.Exit(1) }
 
-  ctx.CGF = &_cgf
-  ctx.SGLF = &_sglf
-  ctx.ConstructTileMapLookup()
+    return
+  } else if action == "loq-export" {
+
+    // Low quality (nocall) information for a step range as one of:
+    //
+    //   tile : tile id, allele, start, length (tile relative)
+    //   bed  : chrom, beg, end, tile id:allele (requires --assembly)
+    //   frac : tile id, allele, nocall bases, tile length, fraction called (requires --sglf)
+    //
+    format := c.String("format")
+    if format=="" { format = "tile" }
+    if format!="tile" && format!="bed" && format!="frac" { log.Fatal("invalid format for loq-export (tile|bed|frac): ", format) }
+
+    path,ver,step_range,e := tileposFromContext(c)
+    if e!=nil { log.Fatal(e) }
 
+    var asm *cgf.TileAssembly
+    if format=="bed" {
+      if len(c.String("assembly"))==0 { log.Fatal("bed output requires --assembly") }
+      asm,e = cgf.LoadTileAssembly(c.String("assembly"))
+      if e!=nil { log.Fatal(e) }
+    }
 
-  for i:=0; i<len(ain_slice); i++ {
-    ain := ain_slice[i]
+    _sglf := cglf.SGLF{}
+    var tile_len func(path, step, varid, span int) int
+    if format=="frac" && len(c.String("sglf"))==0 { log.Fatal("frac output requires --sglf") }
+    if len(c.String("sglf"))>0 {
+      _sglf,e = cgf.LoadSGLF(c.String("sglf"))
+      if e!=nil { log.Fatal(e) }
+      tile_len = sglfTileLen(&_sglf)
+    }
 
-    allele_path,e := cgf.LoadSampleFastj(&ain)
+    filt,e := knotFilterFromContext(c, tile_len)
     if e!=nil { log.Fatal(e) }
 
-    p := 0x2c5
-    if i>0 { p = 0x247 }
+    cgf_bytes,e := cgf.StoreReadFile(c.String("cgf"))
+    if e!=nil { log.Fatal(e) }
 
-    e = ctx.UpdateVectorPathSimple(p, allele_path)
-    if len(ctx.CGF.StepPerPath) < len(ain_slice) {
-      ctx.CGF.StepPerPath = append(ctx.CGF.StepPerPath, uint64(len(_sglf.Lib[p])))
-    }
+    hdri,dn := cgf.HeaderIntermediateFromBytes(cgf_bytes)
+    if dn<0 { log.Fatal("could not construct header from bytes") }
 
-  }
+    path,ver,step_range,e = resolveTileposRange(c, &hdri, path, ver, step_range)
+    if e!=nil { log.Fatal(e) }
 
-  ctx.CGF.PathCount = uint64(len(_cgf.Path))
-  ctx.CGF.StepPerPath = make([]uint64, ctx.CGF.PathCount)
-  for i:=uint64(0); i<ctx.CGF.PathCount; i++ {
-    ctx.CGF.StepPerPath[i] = uint64(len(_sglf.Lib[int(i)]))
-  }
+    if path >= len(hdri.StepPerPath) { log.Fatal("path out of range (max ", len(hdri.StepPerPath), " paths)") }
 
-  ctx.WriteCGF("out.cgf")
+    pathi,e := cgf.HeaderIntermediateLoadPath(&hdri, path)
+    if e!=nil { log.Fatal(e) }
 
-}
+    clampStepRange(step_range, hdri.StepPerPath[path])
 
-func main() {
+    allele_str := []string{"A", "B"}
 
-  app := cli.NewApp()
-  app.Name  = "cgf"
-  app.Usage = "CGF"
-  app.Version = VERSION_STR + " (cgf " + cgf.VERSION_STR + ")"
-  app.Author = "Curoverse, Inc."
-  app.Email = "info@curoverse.com"
-  app.Action = func( c *cli.Context ) { _main(c) }
+    for r:=0; r<len(step_range); r++ {
+      for step:=int(step_range[r][0]); step<int(step_range[r][1]); step++ {
+        knot := cgf.GetKnot(hdri.TileMap, pathi, step)
+        if knot==nil { continue }
 
-  app.Flags = []cli.Flag{
+        knot,keep,e := filt.Apply(pathi, path, step, knot)
+        if e!=nil { log.Fatal(e) }
+        if !keep { continue }
 
-    cli.StringFlag{
-      Name: "cgf, c",
-      Usage: "CGF",
-    },
+        for allele:=0; allele<len(knot); allele++ {
+          for j:=0; j<len(knot[allele]); j++ {
+            ti := knot[allele][j]
+            if ti.VarId==cgf.KNOT_MASK_VARID { continue }
 
-    cli.StringSliceFlag{
-      Name: "input,i",
-      Usage: "INPUT",
-    },
+            tileid := cgf.TileIdString(path, ver, ti)
+            start_len := cgf.NocallStartLenAbsolute(ti.NocallStartLen)
 
-    cli.StringFlag{
-      Name: "cglf, L",
-      Usage: "CGLF",
-    },
+            if format=="frac" {
+              n := tile_len(path, ti.Step, ti.VarId, ti.Span)
+              if n<0 { log.Fatal(fmt.Sprintf("tile %s not in %s", tileid, c.String("sglf"))) }
 
-    cli.StringFlag{
-      Name: "sglf, S",
-      Usage: "SGLF",
-    },
+              noc := 0
+              for p:=0; p<len(start_len); p+=2 { noc += start_len[p+1] }
 
-    cli.StringFlag{
-      Name: "tilepos, p",
-      Usage: "Tile Position",
-    },
+              frac := 1.0
+              if n>0 { frac = float64(n-noc)/float64(n) }
+              fmt.Printf("%s\t%s\t%d\t%d\t%.4f\n", tileid, allele_str[allele], noc, n, frac)
+              continue
+            }
 
-    cli.StringFlag{
-      Name: "path, P",
-      Usage: "Path (in hex)",
-    },
+            if len(start_len)==0 { continue }
 
-    cli.StringFlag{
-      Name: "ocgf, output, o",
-      Value: "-",
-      Usage: "OUTPUT",
-    },
+            if format=="tile" {
+              for p:=0; p<len(start_len); p+=2 {
+                fmt.Printf("%s\t%s\t%d\t%d\n", tileid, allele_str[allele], start_len[p], start_len[p+1])
+              }
+              continue
+            }
+
+            // Nocall offsets are taken relative to the reference start of
+            // the tile, which is exact for tiles without indels.
+            //
+            chrom,beg,end,e := asm.TileRange(path, ti.Step, ti.Span)
+            if e!=nil { log.Fatal(e) }
+
+            for p:=0; p<len(start_len); p+=2 {
+              s := beg + start_len[p]
+              t := s + start_len[p+1]
+              if s>end { s = end }
+              if t>end { t = end }
+              fmt.Printf("%s\t%d\t%d\t%s:%s\n", chrom, s, t, tileid, allele_str[allele])
+            }
+          }
+        }
+      }
+    }
+
+    return
+  } else if action == "freq" {
+
+    // Tile variant frequencies over all input CGFs, for every path (or the
+    // steps of --tilepos or --region, resolved against each file's tagset
+    // as knot-2 does), as TSV (default) or JSON (freqExportRecord).
+    //
+    if len(c.String("cgf"))!=0 {
+      inp_slice = append(inp_slice, c.String("cgf"))
+    }
+    if len(inp_slice)==0 { log.Fatal("no CGF files given") }
+
+    format := c.String("format")
+    if format=="" { format = "tsv" }
+    if format!="tsv" && format!="json" { log.Fatal("invalid format for freq (tsv|json): ", format) }
+
+    sample_ranges,e := cohortRangesFromContext(c)
+    if e!=nil { log.Fatal(e) }
+
+    var tile_len func(path, step, varid, span int) int
+    if len(c.String("sglf"))>0 {
+      _sglf,e := cgf.LoadSGLF(c.String("sglf"))
+      if e!=nil { log.Fatal(e) }
+      tile_len = sglfTileLen(&_sglf)
+    }
+
+    filt,e := knotFilterFromContext(c, tile_len)
+    if e!=nil { log.Fatal(e) }
+
+    nproc := runtime.GOMAXPROCS(0)
+    if c.Int("max-procs")>0 { nproc = c.Int("max-procs") }
+
+    _,freq,tagsets,e := cgf.CohortFreq(inp_slice, nproc, sample_ranges, filt)
+    if e!=nil { log.Fatal(e) }
+
+    totals := freq.StepTotals()
+    keys := freq.SortedKeys()
+
+    out := bufio.NewWriter(os.Stdout)
+    defer out.Flush()
+
+    if format=="tsv" {
+      fmt.Fprintf(out, "#tile\tpath\tstep\tvarid\tspan\tcount\tloq\tmasked\ttotal\tfreq\n")
+    } else {
+      fmt.Fprintf(out, "[")
+    }
+
+    for i:=0; i<len(keys); i++ {
+      k := keys[i]
+      fc := freq[k]
+      tot := totals[[2]int{k.Path, k.Step}]
+      tileid := cgf.TileIdString(k.Path, tagsets[k.Path], cgf.TileInfo{ Step:k.Step, VarId:k.VarId, Span:k.Span })
+      f := float64(fc.Count)/float64(tot)
+
+      if format=="tsv" {
+        fmt.Fprintf(out, "%s\t%04x\t%04x\t%d\t%d\t%d\t%d\t%d\t%d\t%.6f\n",
+          tileid, k.Path, k.Step, k.VarId, k.Span, fc.Count, fc.Loq, fc.Masked, tot, f)
+      } else {
+        rec := freqExportRecord{ Tile:tileid, Path:k.Path, Step:k.Step, VarId:k.VarId, Span:k.Span,
+          Count:fc.Count, Loq:fc.Loq, Masked:fc.Masked, Total:tot, Freq:json.Number(fmt.Sprintf("%.6f", f)) }
+        b,e := json.Marshal(rec)
+        if e!=nil { log.Fatal(e) }
+
+        if i>0 { fmt.Fprintf(out, ",") }
+        fmt.Fprintf(out, "\n%s", b)
+      }
+    }
+
+    if format=="json" { fmt.Fprintf(out, "\n]\n") }
+
+    return
+  } else if action == "plink" {
+
+    // PLINK .bed/.bim/.fam (--output is the file prefix) of the tile
+    // variants over all input CGFs (or the steps of --tilepos or --region,
+    // resolved as for freq) with a frequency between --min-freq and 1 - --min-freq,
+    // one biallelic marker per variant (see cgf_plink.go).  The export
+    // filter options make knots missing.  --assembly gives the marker
+    // positions.
+    //
+    if len(c.String("cgf"))!=0 {
+      inp_slice = append(inp_slice, c.String("cgf"))
+    }
+    fns,e := cgfInputFiles(inp_slice)
+    if e!=nil { log.Fatal(e) }
+    if len(fns)==0 { log.Fatal("no CGF files given") }
+
+    prefix := c.String("output")
+    if prefix=="" || prefix=="-" { log.Fatal("provide output prefix (-o)") }
+
+    sample_ranges,e := cohortRangesFromContext(c)
+    if e!=nil { log.Fatal(e) }
+
+    var asm *cgf.TileAssembly
+    if len(c.String("assembly"))>0 {
+      asm,e = cgf.LoadTileAssembly(c.String("assembly"))
+      if e!=nil { log.Fatal(e) }
+    }
+
+    var tile_len func(path, step, varid, span int) int
+    if len(c.String("sglf"))>0 {
+      _sglf,e := cgf.LoadSGLF(c.String("sglf"))
+      if e!=nil { log.Fatal(e) }
+      tile_len = sglfTileLen(&_sglf)
+    }
+
+    filt,e := knotFilterFromContext(c, tile_len)
+    if e!=nil { log.Fatal(e) }
+
+    nproc := runtime.GOMAXPROCS(0)
+    if c.Int("max-procs")>0 { nproc = c.Int("max-procs") }
+
+    n := len(fns)
+
+    // Headers and tile variant frequencies.
+    //
+    hrefs,freq,tagsets,e := cgf.CohortFreq(fns, nproc, sample_ranges, filt)
+    if e!=nil { log.Fatal(e) }
+
+    names := make([]string, n)
+    for i:=0; i<n; i++ { names[i] = cgfSampleName(&hrefs[i].Header, fns[i]) }
+
+    markers := cgf.PlinkMarkersFromFreq(freq, tagsets, c.Float64("min-freq"))
+    pg := cgf.NewPlinkGenotyper(markers, filt)
+
+    // Genotypes, one sample per worker, reading only the paths with
+    // markers.
+    //
+    geno := make([][]byte, n)
+    var wg sync.WaitGroup
+    idx_ch := make(chan int)
+    for w:=0; w<nproc; w++ {
+      wg.Add(1)
+      go func() {
+        defer wg.Done()
+        for i := range idx_ch {
+          f,e := cgf.StoreOpen(fns[i])
+          if e!=nil { log.Fatal(e) }
+          g,e := pg.ReadSampleGenotypes(hrefs[i], f)
+          f.Close()
+          if e!=nil { log.Fatal(fns[i], ": ", e) }
+          geno[i] = g
+        }
+      }()
+    }
+
+    for i:=0; i<n; i++ { idx_ch <- i }
+    close(idx_ch)
+    wg.Wait()
+
+    write := func(ext string, fn func(w io.Writer) error) {
+      f,e := cgf.StoreCreate(prefix + ext)
+      if e!=nil { log.Fatal(e) }
+      e = fn(f)
+      if e!=nil { log.Fatal(prefix, ext, ": ", e) }
+      e = f.Close()
+      if e!=nil { log.Fatal(prefix, ext, ": ", e) }
+    }
+
+    write(".bed", func(w io.Writer) error { return cgf.WritePlinkBed(w, geno, len(markers)) })
+    write(".bim", func(w io.Writer) error { return cgf.WritePlinkBim(w, markers, asm) })
+    write(".fam", func(w io.Writer) error { return cgf.WritePlinkFam(w, names) })
+
+    fmt.Fprintf(os.Stderr, "%d markers, %d samples\n", len(markers), n)
+
+    return
+  } else if action == "index-build" {
+
+    // Add the CGFs given with -i (files or directories of *.cgf) to the
+    // index in --index, creating it if needed.  Files already in the
+    // index are skipped.
+    //
+    if len(c.String("index"))==0 { log.Fatal("provide index directory (--index)") }
+    if len(c.String("cgf"))!=0 {
+      inp_slice = append(inp_slice, c.String("cgf"))
+    }
+
+    fns,e := cgfInputFiles(inp_slice)
+    if e!=nil { log.Fatal(e) }
+    if len(fns)==0 { log.Fatal("no CGF files given") }
+
+    idx,e := cgf.LoadTileIndex(c.String("index"))
+    if e!=nil { log.Fatal(e) }
+
+    n_add := 0
+    for i:=0; i<len(fns); i++ {
+      source := fns[i]
+      if !strings.Contains(source, "://") {
+        source,e = filepath.Abs(fns[i])
+        if e!=nil { log.Fatal(e) }
+      }
+      if idx.HasSource(source) {
+        if gVerboseFlag { fmt.Fprintf(os.Stderr, "%s: already indexed, skipping\n", fns[i]) }
+        continue
+      }
+
+      cgf_bytes,e := cgf.StoreReadFile(fns[i])
+      if e!=nil { log.Fatal(e) }
+
+      hdri,dn := cgf.HeaderIntermediateFromBytes(cgf_bytes)
+      if dn<0 { log.Fatal(fns[i], ": could not construct header from bytes") }
+
+      name := cgfSampleName(&hdri, fns[i])
+
+      e = idx.AddSample(name, source, &hdri)
+      if e!=nil { log.Fatal(fns[i], ": ", e) }
+      n_add++
+
+      if gVerboseFlag { fmt.Fprintf(os.Stderr, "%s: added as sample %d (%s)\n", fns[i], len(idx.Samples)-1, name) }
+    }
+
+    e = idx.Save()
+    if e!=nil { log.Fatal(e) }
+
+    if gVerboseFlag { fmt.Fprintf(os.Stderr, "added %d samples, %d total\n", n_add, len(idx.Samples)) }
+
+    return
+  } else if action == "index-query" {
+
+    // Samples carrying the tile variant --varid (or any variant) at each
+    // of the comma separated tile positions (or position ranges) in -p.
+    // Positions in another tagset than the index's need --tagset-map.
+    //
+    if len(c.String("index"))==0 { log.Fatal("provide index directory (--index)") }
+    if len(c.String("tilepos"))==0 { log.Fatal("provide tile position (-p)") }
+
+    idx,e := cgf.LoadTileIndex(c.String("index"))
+    if e!=nil { log.Fatal(e) }
+    if len(idx.Samples)==0 { log.Fatal("empty index: ", c.String("index")) }
+
+    varid := -1
+    if len(c.String("varid"))>0 {
+      v,e := strconv.ParseInt(c.String("varid"), 16, 64)
+      if e!=nil || v<0 { log.Fatal("invalid variant id: ", c.String("varid")) }
+      varid = int(v)
+    }
+
+    out := bufio.NewWriter(os.Stdout)
+    defer out.Flush()
+
+    fmt.Fprintf(out, "#tile\tsample\tname\tzygosity\tloq\n")
+
+    tileposes := strings.Split(c.String("tilepos"), ",")
+    for t:=0; t<len(tileposes); t++ {
+      path,ver,step_range,e := parseTileposRange(tileposes[t])
+      if e!=nil { log.Fatal(e) }
+
+      path,ver,step_range,e = resolveIndexTileposRange(c, idx, path, ver, step_range)
+      if e!=nil { log.Fatal(tileposes[t], ": ", e) }
+
+      pidx,e := idx.LoadPath(path)
+      if e!=nil { log.Fatal(e) }
+      clampStepRange(step_range, pidx.NStep)
+
+      for r:=0; r<len(step_range); r++ {
+        for step:=int(step_range[r][0]); step<int(step_range[r][1]); step++ {
+          carriers,e := idx.Query(path, step, varid)
+          if e!=nil { log.Fatal(e) }
+
+          for i:=0; i<len(carriers); i++ {
+            ca := carriers[i]
+            zyg := "het"
+            if ca.Hom { zyg = "hom" }
+            loq := "hq"
+            if ca.Loq { loq = "loq" }
+
+            tileid := cgf.TileIdString(path, ver, cgf.TileInfo{ Step:ca.Step, VarId:ca.VarId, Span:ca.Span })
+            fmt.Fprintf(out, "%s\t%d\t%s\t%s\t%s\n", tileid, ca.Sample, idx.Samples[ca.Sample], zyg, loq)
+          }
+        }
+      }
+    }
+
+    return
+  } else if action == "distance" {
+
+    // All pairs genotype distance over the input CGFs (files or
+    // directories): differing tile alleles per comparable step, summed
+    // over the paths both samples have.  Paths are loaded one at a time
+    // for every sample and the pairs split over max-procs workers.
+    //
+    if len(c.String("cgf"))!=0 {
+      inp_slice = append(inp_slice, c.String("cgf"))
+    }
+    fns,e := cgfInputFiles(inp_slice)
+    if e!=nil { log.Fatal(e) }
+    if len(fns)<2 { log.Fatal("need at least two CGF files") }
+
+    nproc := runtime.GOMAXPROCS(0)
+    if c.Int("max-procs")>0 { nproc = c.Int("max-procs") }
+
+    n := len(fns)
+    hdris := make([]cgf.HeaderIntermediate, n)
+    names := make([]string, n)
+    npath := 0
+    for i:=0; i<n; i++ {
+      cgf_bytes,e := cgf.StoreReadFile(fns[i])
+      if e!=nil { log.Fatal(e) }
+
+      var dn int
+      hdris[i],dn = cgf.HeaderIntermediateFromBytes(cgf_bytes)
+      if dn<0 { log.Fatal(fns[i], ": could not construct header from bytes") }
+
+      if !bytes.Equal(hdris[i].TileMapBytes, hdris[0].TileMapBytes) {
+        log.Fatal(fns[i], ": tile map differs from ", fns[0])
+      }
+
+      names[i] = cgfSampleName(&hdris[i], fns[i])
+      if len(hdris[i].StepPerPath)>npath { npath = len(hdris[i].StepPerPath) }
+    }
+
+    diff := make([][]int, n)
+    comparable := make([][]int, n)
+    for i:=0; i<n; i++ {
+      diff[i] = make([]int, n)
+      comparable[i] = make([]int, n)
+    }
+
+    for path:=0; path<npath; path++ {
+      pathis := make([]*cgf.PathIntermediate, n)
+      for i:=0; i<n; i++ {
+        if path>=len(hdris[i].StepPerPath) || hdris[i].StepPerPath[path]==0 { continue }
+        pathi,e := cgf.HeaderIntermediateLoadPath(&hdris[i], path)
+        if e!=nil { log.Fatal(fns[i], ": ", e) }
+        pathis[i] = &pathi
+      }
+
+      var wg sync.WaitGroup
+      pair_ch := make(chan [2]int)
+      for w:=0; w<nproc; w++ {
+        wg.Add(1)
+        go func() {
+          defer wg.Done()
+          for pair := range pair_ch {
+            a,b := pair[0],pair[1]
+            d,m,e := cgf.PathPairDistance(hdris[a].TileMap, *pathis[a], *pathis[b])
+            if e!=nil { log.Fatal(fmt.Sprintf("%s, %s: path %x: %v", fns[a], fns[b], path, e)) }
+            diff[a][b] += d
+            comparable[a][b] += m
+          }
+        }()
+      }
+
+      for a:=0; a<n; a++ {
+        if pathis[a]==nil { continue }
+        for b:=a+1; b<n; b++ {
+          if pathis[b]==nil { continue }
+          pair_ch <- [2]int{a,b}
+        }
+      }
+      close(pair_ch)
+      wg.Wait()
+    }
+
+    out := bufio.NewWriter(os.Stdout)
+    defer out.Flush()
+
+    fmt.Fprintf(out, "#")
+    for i:=0; i<n; i++ { fmt.Fprintf(out, "\t%s", names[i]) }
+    fmt.Fprintf(out, "\n")
+
+    for a:=0; a<n; a++ {
+      fmt.Fprintf(out, "%s", names[a])
+      for b:=0; b<n; b++ {
+        i,j := a,b
+        if j<i { i,j = j,i }
+        if i==j {
+          fmt.Fprintf(out, "\t%.6f", 0.0)
+        } else if comparable[i][j]==0 {
+          fmt.Fprintf(out, "\tNA")
+        } else {
+          fmt.Fprintf(out, "\t%.6f", float64(diff[i][j])/float64(comparable[i][j]))
+        }
+      }
+      fmt.Fprintf(out, "\n")
+    }
+
+    if gVerboseFlag {
+      for a:=0; a<n; a++ {
+        for b:=a+1; b<n; b++ {
+          fmt.Fprintf(os.Stderr, "%s\t%s\t%d\t%d\n", names[a], names[b], diff[a][b], comparable[a][b])
+        }
+      }
+    }
+
+    return
+  } else if action == "fasta" {
+
+    // Haplotype sequences (one record per allele) for the steps given
+    // with --tilepos or --region, for each input CGF.  With --assembly
+    // the header also has the reference interval of the tiles.
+    //
+    if len(c.String("cgf"))!=0 {
+      inp_slice = append(inp_slice, c.String("cgf"))
+    }
+    if len(inp_slice)==0 { log.Fatal("no CGF files given") }
+
+    path,ver,step_range,e := tileposFromContext(c)
+    if e!=nil { log.Fatal(e) }
+
+    _sglf,e := cgf.LoadSGLF(c.String("sglf"))
+    if e!=nil { log.Fatal(e) }
+
+    var asm *cgf.TileAssembly
+    if len(c.String("assembly"))>0 {
+      asm,e = cgf.LoadTileAssembly(c.String("assembly"))
+      if e!=nil { log.Fatal(e) }
+    }
+
+    out := bufio.NewWriter(os.Stdout)
+    defer out.Flush()
+
+    allele_str := []string{ "A", "B" }
+
+    for i:=0; i<len(inp_slice); i++ {
+      cgf_bytes,e := cgf.StoreReadFile(inp_slice[i])
+      if e!=nil { log.Fatal(e) }
+
+      hdri,dn := cgf.HeaderIntermediateFromBytes(cgf_bytes)
+      if dn<0 { log.Fatal(inp_slice[i], ": could not construct header from bytes") }
+
+      path,ver,ranges,e := resolveTileposRange(c, &hdri, path, ver, step_range)
+      if e!=nil { log.Fatal(inp_slice[i], ": ", e) }
+
+      if path>=len(hdri.StepPerPath) { log.Fatal(fmt.Sprintf("%s: path %x out of range", inp_slice[i], path)) }
+
+      pathi,e := cgf.HeaderIntermediateLoadPath(&hdri, path)
+      if e!=nil { log.Fatal(inp_slice[i], ": ", e) }
+
+      name := cgfSampleName(&hdri, inp_slice[i])
+
+      clampStepRange(ranges, hdri.StepPerPath[path])
+
+      for r:=0; r<len(ranges); r++ {
+        beg,end := int(ranges[r][0]), int(ranges[r][1])
+        if beg>=end { continue }
+
+        hap,e := cgf.PathHaplotypeSeq(hdri.TileMap, pathi, path, beg, end, &_sglf)
+        if e!=nil { log.Fatal(inp_slice[i], ": ", e) }
+
+        region := ""
+        if asm!=nil {
+          chrom,ref_beg,ref_end,e := asm.RegionForTilepos(path, beg, end)
+          if e!=nil { log.Fatal(e) }
+          region = fmt.Sprintf(" %s:%d-%d", chrom, ref_beg+1, ref_end)
+        }
+
+        for allele:=0; allele<len(hap); allele++ {
+          fmt.Fprintf(out, ">%s %04x.%02x.%04x-%04x%s %s\n", name, path, ver, beg, end, region, allele_str[allele])
+          for p:=0; p<len(hap[allele]); p+=50 {
+            q := p+50
+            if q>len(hap[allele]) { q = len(hap[allele]) }
+            fmt.Fprintf(out, "%s\n", hap[allele][p:q])
+          }
+        }
+      }
+    }
+
+    return
+  } else if action == "meta-get" {
+
+    cgf_bytes,e := cgf.StoreReadFile(c.String("cgf"))
+    if e!=nil { log.Fatal(e) }
+
+    hdri,dn := cgf.HeaderIntermediateFromBytes(cgf_bytes)
+    if dn<0 { log.Fatal("could not construct header from bytes") }
+
+    meta,e := cgf.HeaderIntermediateMeta(&hdri)
+    if e!=nil { log.Fatal(e) }
+
+    if len(c.String("key"))>0 {
+      v,ok := meta[c.String("key")]
+      if !ok { log.Fatal("key not found: ", c.String("key")) }
+      fmt.Printf("%s\n", v)
+      return
+    }
+
+    keys := make([]string, 0, len(meta))
+    for k := range meta { keys = append(keys, k) }
+    sort.Strings(keys)
+    for i:=0; i<len(keys); i++ {
+      fmt.Printf("%s\t%s\n", keys[i], meta[keys[i]])
+    }
+
+    return
+  } else if action == "meta-set" {
+
+    // An empty --value removes the key.
+    //
+    key := c.String("key")
+    if len(key)==0 { log.Fatal("missing --key") }
+
+    cgf_bytes,e := cgf.StoreReadFile(c.String("cgf"))
+    if e!=nil { log.Fatal(e) }
+
+    hdri,dn := cgf.HeaderIntermediateFromBytes(cgf_bytes)
+    if dn<0 { log.Fatal("could not construct header from bytes") }
+
+    if len(c.String("value"))==0 {
+      e = cgf.HeaderIntermediateDelMeta(&hdri, key)
+    } else {
+      e = cgf.HeaderIntermediateSetMeta(&hdri, key, c.String("value"))
+    }
+    if e!=nil { log.Fatal(e) }
+
+    cgf.WriteCGFFromIntermediate(c.String("output"), &hdri)
+
+    return
+  } else if action == "liftover" {
+
+    // Re-encode --cgf, written against the library --sglf, against
+    // --new-sglf.  Variants of the old library that are gone from the new
+    // one are listed on stderr; if the CGF uses any of them nothing is
+    // written.
+    //
+    if len(c.String("new-sglf"))==0 { log.Fatal("missing --new-sglf") }
+    if len(c.String("library-version"))==0 { log.Fatal("missing --library-version") }
+
+    old_sglf,e := cgf.LoadSGLF(c.String("sglf"))
+    if e!=nil { log.Fatal(e) }
+    new_sglf,e := cgf.LoadSGLF(c.String("new-sglf"))
+    if e!=nil { log.Fatal(e) }
+
+    cgf_bytes,e := cgf.StoreReadFile(c.String("cgf"))
+    if e!=nil { log.Fatal(e) }
+
+    hdri,dn := cgf.HeaderIntermediateFromBytes(cgf_bytes)
+    if dn<0 { log.Fatal("could not construct header from bytes") }
+
+    lm := cgf.NewLiftoverMap(&old_sglf, &new_sglf)
+    gone := lm.Vanished()
+    if len(gone)>0 {
+      fmt.Fprintf(os.Stderr, "%d variants of %s have no match in %s\n", len(gone), c.String("sglf"), c.String("new-sglf"))
+      if gVerboseFlag {
+        for i:=0; i<len(gone); i++ {
+          ver := cgf.HeaderIntermediatePathTagset(&hdri, gone[i].Path)
+          if ver<0 { ver = 0 }
+          fmt.Fprintf(os.Stderr, "  %04x.%02x.%04x.%03x\n", gone[i].Path, ver, gone[i].Step, gone[i].VarId)
+        }
+      }
+    }
+
+    used,e := cgf.HeaderIntermediateLiftover(&hdri, lm, &new_sglf, c.String("library-version"))
+    if e!=nil { log.Fatal(e) }
+    if len(used)>0 {
+      for i:=0; i<len(used); i++ {
+        fmt.Fprintf(os.Stderr, "vanished: %s\n", cgf.TileIdString(used[i].Path, used[i].Ver, cgf.TileInfo{ Step:used[i].Step, VarId:used[i].VarId, Span:used[i].Span }))
+      }
+      log.Fatal(fmt.Sprintf("%d tile variants used in %s are not in %s", len(used), c.String("cgf"), c.String("new-sglf")))
+    }
+
+    meta,e := cgf.HeaderIntermediateMeta(&hdri)
+    if e!=nil { log.Fatal(e) }
+    meta[cgf.CGF_META_LIBRARY] = c.String("new-sglf")
+    lib_m5,e := file_md5sum(c.String("new-sglf"))
+    if e!=nil { log.Fatal(e) }
+    meta[cgf.CGF_META_LIBRARY_MD5] = lib_m5
+    cgf.HeaderIntermediateSetMetaMap(&hdri, meta)
+
+    cgf.WriteCGFFromIntermediate(c.String("output"), &hdri)
+
+    return
+  } else if action == "annot-import" {
+
+    // Add the per-tile annotation in the text files given with -i (tile
+    // position, track name, per allele values) to --cgf.
+    //
+    if len(inp_slice)==0 { log.Fatal("no annotation files given") }
+
+    cgf_bytes,e := cgf.StoreReadFile(c.String("cgf"))
+    if e!=nil { log.Fatal(e) }
+
+    hdri,dn := cgf.HeaderIntermediateFromBytes(cgf_bytes)
+    if dn<0 { log.Fatal("could not construct header from bytes") }
+
+    tmap,e := tagsetMapFromContext(c)
+    if e!=nil { log.Fatal(e) }
+
+    for _,fn := range inp_slice {
+      b,e := cgf.StoreReadFile(fn)
+      if e!=nil { log.Fatal(e) }
+      e = cgf.HeaderIntermediateImportAnnot(&hdri, tmap, b)
+      if e!=nil { log.Fatal(fn, ": ", e) }
+    }
+
+    cgf.WriteCGFFromIntermediate(c.String("output"), &hdri)
+
+    return
+  } else if action == "annot-export" {
+
+    // Per-tile annotation of every path (or the path and steps given with
+    // --tilepos or --region), all tracks or the one named by --annot-track, as
+    // TSV (default, in the annot-import format) or JSON (see
+    // annotExportRecord).
+    //
+    format := c.String("format")
+    if format=="" { format = "tsv" }
+    if format!="tsv" && format!="json" { log.Fatal("invalid format for annot-export (tsv|json): ", format) }
+
+    cgf_bytes,e := cgf.StoreReadFile(c.String("cgf"))
+    if e!=nil { log.Fatal(e) }
+
+    hdri,dn := cgf.HeaderIntermediateFromBytes(cgf_bytes)
+    if dn<0 { log.Fatal("could not construct header from bytes") }
+
+    sel_path := -1
+    var step_range [][2]int64
+    if len(c.String("tilepos"))>0 || len(c.String("region"))>0 {
+      p,v,r,e := tileposFromContext(c)
+      if e!=nil { log.Fatal(e) }
+      sel_path,_,step_range,e = resolveTileposRange(c, &hdri, p, v, r)
+      if e!=nil { log.Fatal(e) }
+    }
+
+    out := bufio.NewWriter(os.Stdout)
+    defer out.Flush()
+
+    if format=="json" { fmt.Fprintf(out, "[") }
+    count := 0
+
+    for path:=0; path<len(hdri.StepPerPath); path++ {
+      if sel_path>=0 && path!=sel_path { continue }
+
+      tracks,e := cgf.HeaderIntermediatePathAnnot(&hdri, path)
+      if e!=nil { log.Fatal(e) }
+
+      ver := cgf.HeaderIntermediatePathTagset(&hdri, path)
+      if ver<0 { ver = 0 }
+
+      ranges := [][2]int64{ [2]int64{0, int64(hdri.StepPerPath[path])} }
+      if step_range!=nil {
+        ranges = make([][2]int64, len(step_range))
+        copy(ranges, step_range)
+        clampStepRange(ranges, hdri.StepPerPath[path])
+      }
+
+      for _,t := range tracks {
+        if len(c.String("annot-track"))>0 && t.Name!=c.String("annot-track") { continue }
+
+        for i:=0; i<len(t.Step); i++ {
+          in_range := false
+          for r:=0; r<len(ranges); r++ {
+            if int64(t.Step[i])>=ranges[r][0] && int64(t.Step[i])<ranges[r][1] { in_range = true ; break }
+          }
+          if !in_range { continue }
+
+          vals := make([]string, len(t.Value[i]))
+          for k:=0; k<len(vals); k++ { vals[k] = t.FormatValue(t.Value[i][k]) }
+
+          if format=="tsv" {
+            fmt.Fprintf(out, "%04x.%02x.%04x\t%s\t%s\n", path, ver, t.Step[i], t.Name, strings.Join(vals, ","))
+          } else {
+            rec := annotExportRecord{ Tilepos:fmt.Sprintf("%04x.%02x.%04x", path, ver, t.Step[i]), Path:path, Step:t.Step[i], Name:t.Name }
+            rec.Value = make([]interface{}, len(vals))
+            for k:=0; k<len(vals); k++ {
+              x := t.Value[i][k]
+              if math.IsNaN(x) || math.IsInf(x, 0) { continue }
+              rec.Value[k] = json.Number(vals[k])
+            }
+            b,e := json.Marshal(rec)
+            if e!=nil { log.Fatal(e) }
+
+            if count>0 { fmt.Fprintf(out, ",") }
+            fmt.Fprintf(out, "\n%s", b)
+          }
+          count++
+        }
+      }
+    }
+
+    if format=="json" { fmt.Fprintf(out, "\n]\n") }
+
+    return
+  } else if action == "genes" {
+
+    // Tiles of the genes in --gff (GFF3 or GTF, optionally only those
+    // named with --gene), placed with --assembly.  With --cgf, every step
+    // of a gene is classified by the sample's knot covering it, and the
+    // steps that aren't canonical are listed (tsv, default, or json) or
+    // counted per gene (summary).  Without --cgf only the summary of the
+    // tile ranges is written.
+    //
+    format := c.String("format")
+    if format=="" { format = "tsv" }
+    if format!="tsv" && format!="json" && format!="summary" { log.Fatal("invalid format for genes (tsv|json|summary): ", format) }
+
+    if len(c.String("gff"))==0 { log.Fatal("provide gene annotation (--gff)") }
+    if len(c.String("assembly"))==0 { log.Fatal("provide tile assembly (--assembly)") }
+
+    genes,e := cgf.LoadGenes(c.String("gff"), c.StringSlice("gene"))
+    if e!=nil { log.Fatal(e) }
+    if len(c.StringSlice("gene"))>0 && len(genes)==0 { log.Fatal("no gene named ", strings.Join(c.StringSlice("gene"), ","), " in ", c.String("gff")) }
+
+    asm,e := cgf.LoadTileAssembly(c.String("assembly"))
+    if e!=nil { log.Fatal(e) }
+
+    rptr := cgf.GeneReporter{ Assembly:asm }
+
+    var hdri cgf.HeaderIntermediate
+    if len(c.String("cgf"))>0 {
+      cgf_bytes,e := cgf.StoreReadFile(c.String("cgf"))
+      if e!=nil { log.Fatal(e) }
+
+      var dn int
+      hdri,dn = cgf.HeaderIntermediateFromBytes(cgf_bytes)
+      if dn<0 { log.Fatal("could not construct header from bytes") }
+
+      rptr.Header = &hdri
+      rptr.TagsetMap,e = tagsetMapFromContext(c)
+      if e!=nil { log.Fatal(e) }
+    } else if format!="summary" {
+      format = "summary"
+    }
+
+    out := bufio.NewWriter(os.Stdout)
+    defer out.Flush()
+
+    if format=="summary" {
+      fmt.Fprintf(out, "#gene\tchrom\tstart\tend\ttiles\tsteps")
+      if rptr.Header!=nil { fmt.Fprintf(out, "\tnoncanonical\tspanning\tcomplex\tloq") }
+      fmt.Fprintf(out, "\n")
+    } else if format=="tsv" {
+      fmt.Fprintf(out, "#gene\tchrom\tstart\tend\ttilepos\tflags\tvarid\n")
+    } else {
+      fmt.Fprintf(out, "[")
+    }
+
+    // Genes that can't be reported are skipped and make the exit status
+    // non-zero.
+    //
+    count,nfail := 0,0
+    for _,gene := range genes {
+      rep,e := rptr.Report(gene)
+      if e!=nil {
+        fmt.Fprintf(os.Stderr, "skipping %v\n", e)
+        nfail++
+        continue
+      }
+
+      if format=="summary" {
+        tiles := make([]string, len(rep.Tiles))
+        nstep := 0
+        for i,r := range rep.Tiles {
+          tiles[i] = fmt.Sprintf("%04x.%02x.%04x-%04x", r.Path, asm.Tagset, r.Beg, r.End-1)
+          nstep += r.End-r.Beg
+        }
+        fmt.Fprintf(out, "%s\t%s\t%d\t%d\t%s\t%d", gene.Name, gene.Chrom, gene.Beg+1, gene.End, strings.Join(tiles, ","), nstep)
+        if rptr.Header!=nil { fmt.Fprintf(out, "\t%d\t%d\t%d\t%d", rep.NonCanonical, rep.Spanning, rep.Complex, rep.Loq) }
+        fmt.Fprintf(out, "\n")
+        continue
+      }
+
+      for i:=0; i<len(rep.Steps); i++ {
+        gs := &rep.Steps[i]
+        if gs.Canonical() { continue }
+
+        ver := cgf.HeaderIntermediatePathTagset(&hdri, gs.Path)
+        if ver<0 { ver = 0 }
+
+        // variant of each allele's tile covering the step
+        //
+        varids := []string{}
+        jvarids := []int{}
+        for allele:=0; allele<len(gs.Knot); allele++ {
+          for _,ti := range gs.Knot[allele] {
+            if ti.Step>gs.Step || ti.Step+ti.Span<=gs.Step { continue }
+            varids = append(varids, fmt.Sprintf("%x", ti.VarId))
+            jvarids = append(jvarids, ti.VarId)
+          }
+        }
+
+        if format=="tsv" {
+          fmt.Fprintf(out, "%s\t%s\t%d\t%d\t%04x.%02x.%04x\t%s\t%s\n",
+            gene.Name, gene.Chrom, gene.Beg+1, gene.End, gs.Path, ver, gs.Step, gs.Flags(), strings.Join(varids, ","))
+        } else {
+          rec := genesExportRecord{ Gene:gene.Name, Id:gene.Id, Chrom:gene.Chrom, Start:gene.Beg+1, End:gene.End,
+            Tilepos:fmt.Sprintf("%04x.%02x.%04x", gs.Path, ver, gs.Step), AnchorStep:gs.AnchorStep,
+            Flags:strings.Split(gs.Flags(), ","), VarId:jvarids }
+          b,e := json.Marshal(rec)
+          if e!=nil { log.Fatal(e) }
+
+          if count>0 { fmt.Fprintf(out, ",") }
+          fmt.Fprintf(out, "\n%s", b)
+        }
+        count++
+      }
+    }
+
+    if format=="json" { fmt.Fprintf(out, "\n]\n") }
+
+    out.Flush()
+
+    if nfail>0 {
+      fmt.Fprintf(os.Stderr, "%d of %d genes skipped\n", nfail, len(genes))
+      os.Exit(1)
+    }
+    return
+  } else if action == "canon-table" {
+
+    // Cohort canonical variants: for every step where a single step
+    // variant is more common than variant 0 over the input CGFs (and
+    // carried by at least --canon-min-freq of the alleles), the most
+    // common one.  The table goes to --output, for append and
+    // canon-apply.
+    //
+    _sglf,e := cgf.LoadSGLF(c.String("sglf"))
+    if e!=nil { log.Fatal(e) }
+
+    if len(c.String("cgf"))!=0 {
+      inp_slice = append(inp_slice, c.String("cgf"))
+    }
+    fns,e := cgfInputFiles(inp_slice)
+    if e!=nil { log.Fatal(e) }
+    if len(fns)==0 { log.Fatal("no input CGF files") }
+
+    freq := cgf.TileFreq{}
+    tagsets := make(map[int]int)
+    for _,fn := range fns {
+      cgf_bytes,e := cgf.StoreReadFile(fn)
+      if e!=nil { log.Fatal(e) }
+
+      hdri,dn := cgf.HeaderIntermediateFromBytes(cgf_bytes)
+      if dn<0 { log.Fatal(fn, ": could not construct header from bytes") }
+      e = cgf.MergePathTagsets(tagsets, &hdri)
+      if e!=nil { log.Fatal(fn, ": ", e) }
+
+      for path:=0; path<len(hdri.StepPerPath); path++ {
+        if hdri.StepPerPath[path]==0 { continue }
+
+        pathi,e := cgf.HeaderIntermediateLoadPath(&hdri, path)
+        if e!=nil { log.Fatal(fn, ": ", e) }
+        e = freq.AddPath(hdri.TileMap, pathi, path, 0, hdri.StepPerPath[path], nil)
+        if e!=nil { log.Fatal(fn, ": ", e) }
+      }
+    }
+
+    ct := cgf.CanonTableFromFreq(freq, &_sglf, c.Float64("canon-min-freq"))
+    b := cgf.BytesFromCanonTable(ct, tagsets)
+    if c.String("output")=="-" {
+      os.Stdout.Write(b)
+    } else {
+      e = cgf.StoreWriteFile(c.String("output"), b)
+      if e!=nil { log.Fatal(e) }
+    }
+
+    return
+  } else if action == "canon-apply" {
+
+    // Re-encode --cgf with the paths' canon tables taken from
+    // --canon-table (no table puts every path back on the library's
+    // variant 0).
+    //
+    _sglf,e := cgf.LoadSGLF(c.String("sglf"))
+    if e!=nil { log.Fatal(e) }
+
+    cgf_bytes,e := cgf.StoreReadFile(c.String("cgf"))
+    if e!=nil { log.Fatal(e) }
+
+    hdri,dn := cgf.HeaderIntermediateFromBytes(cgf_bytes)
+    if dn<0 { log.Fatal("could not construct header from bytes") }
+
+    ct := cgf.CanonTable{}
+    if len(c.String("canon-table"))>0 {
+      tagsets := make(map[int]int)
+      e = cgf.MergePathTagsets(tagsets, &hdri)
+      if e!=nil { log.Fatal(e) }
+      ct,e = cgf.LoadCanonTable(c.String("canon-table"), tagsets)
+      if e!=nil { log.Fatal(e) }
+    }
+
+    e = cgf.HeaderIntermediateApplyCanon(&hdri, &_sglf, ct)
+    if e!=nil { log.Fatal(e) }
+
+    cgf.WriteCGFFromIntermediate(c.String("output"), &hdri)
+
+    return
+  } else if action == "bundle-create" || action == "bundle-add" {
+
+    // Samples are named by their sample-id metadata (or file name).
+    // bundle-add rewrites --bundle, or writes to --output if given.
+    //
+    bundle_fn := c.String("bundle")
+    if len(bundle_fn)==0 { log.Fatal("missing --bundle") }
+
+    if len(c.String("cgf"))!=0 {
+      inp_slice = append(inp_slice, c.String("cgf"))
+    }
+    fns,e := cgfInputFiles(inp_slice)
+    if e!=nil { log.Fatal(e) }
+    if len(fns)==0 { log.Fatal("no input CGF files") }
+
+    bi := cgf.BundleIntermediate{}
+    if action == "bundle-add" {
+      b,e := cgf.StoreReadFile(bundle_fn)
+      if e!=nil { log.Fatal(e) }
+      bi,e = cgf.BundleIntermediateFromBytes(b)
+      if e!=nil { log.Fatal(bundle_fn, ": ", e) }
+    }
+
+    for i:=0; i<len(fns); i++ {
+      cgf_bytes,e := cgf.StoreReadFile(fns[i])
+      if e!=nil { log.Fatal(e) }
+
+      hdri,dn := cgf.HeaderIntermediateFromBytes(cgf_bytes)
+      if dn<0 { log.Fatal(fns[i], ": could not construct header from bytes") }
+
+      e = cgf.BundleIntermediateAddCGF(&bi, cgfSampleName(&hdri, fns[i]), cgf_bytes)
+      if e!=nil { log.Fatal(fns[i], ": ", e) }
+    }
+
+    ofn := bundle_fn
+    if action == "bundle-add" && c.String("output")!="-" { ofn = c.String("output") }
+    e = cgf.StoreWriteFile(ofn, cgf.BytesFromBundleIntermediate(bi))
+    if e!=nil { log.Fatal(e) }
+
+    return
+  } else if action == "bundle-extract" {
+
+    // One sample (--sample-id) to --output, or every sample to
+    // <output>/<sample>.cgf.
+    //
+    b,e := cgf.StoreReadFile(c.String("bundle"))
+    if e!=nil { log.Fatal(e) }
+    bi,e := cgf.BundleIntermediateFromBytes(b)
+    if e!=nil { log.Fatal(c.String("bundle"), ": ", e) }
+
+    ofn := c.String("output")
+    if ofn=="-" { log.Fatal("missing --output") }
+
+    if len(c.String("sample-id"))>0 {
+      idx := cgf.BundleIntermediateSampleIndex(&bi, c.String("sample-id"))
+      if idx<0 { log.Fatal("sample not in bundle: ", c.String("sample-id")) }
+
+      cgf_bytes,e := cgf.BundleIntermediateCGFBytes(&bi, idx)
+      if e!=nil { log.Fatal(e) }
+      e = cgf.StoreWriteFile(ofn, cgf_bytes)
+      if e!=nil { log.Fatal(e) }
+      return
+    }
+
+    for i:=0; i<len(bi.Samples); i++ {
+      cgf_bytes,e := cgf.BundleIntermediateCGFBytes(&bi, i)
+      if e!=nil { log.Fatal(e) }
+      e = cgf.StoreWriteFile(cgf.StoreJoin(ofn, bi.Samples[i].Name + ".cgf"), cgf_bytes)
+      if e!=nil { log.Fatal(e) }
+    }
+
+    return
+  } else if action == "bundle-query" {
+
+    // Tiles of every sample of --bundle over the --tilepos (or --region)
+    // step ranges, reading only the bundle's header and the path's blocks.
+    // One line per sample, range and allele: sample name, allele and the
+    // tiles in the knot-2 notation, tab separated.
+    //
+    path,ver,step_range,e := tileposFromContext(c)
+    if e!=nil { log.Fatal(e) }
+
+    f,e := cgf.StoreOpen(c.String("bundle"))
+    if e!=nil { log.Fatal(e) }
+    defer f.Close()
+
+    bref,e := cgf.ReadBundleRef(f, f.Size())
+    if e!=nil { log.Fatal(c.String("bundle"), ": ", e) }
+
+    // Every sample's tile positions have to land on the same path, which
+    // is then read once.
+    //
+    nsample := len(bref.Bundle.Samples)
+    ranges := make([][][2]int64, nsample)
+    rpath,rver := -1,-1
+    for s:=0; s<nsample; s++ {
+      hdri,e := cgf.BundleIntermediateSampleHeader(&bref.Bundle, s)
+      if e!=nil { log.Fatal(e) }
+
+      p,v,r,e := resolveTileposRange(c, &hdri, path, ver, append([][2]int64{}, step_range...))
+      if e!=nil { log.Fatal(bref.Bundle.Samples[s].Name, ": ", e) }
+      if s>0 && p!=rpath { log.Fatal(bref.Bundle.Samples[s].Name, fmt.Sprintf(": tile positions map to path %04x, not %04x", p, rpath)) }
+      rpath,rver,ranges[s] = p,v,r
+    }
+    if nsample==0 { return }
+
+    hdris,e := bref.ReadPathHeaders(f, rpath)
+    if e!=nil { log.Fatal(c.String("bundle"), ": ", e) }
+
+    out := bufio.NewWriter(os.Stdout)
+    defer out.Flush()
+
+    for s:=0; s<nsample; s++ {
+      name := bref.Bundle.Samples[s].Name
+      if rpath>=len(hdris[s].StepPerPath) || hdris[s].StepPerPath[rpath]==0 { continue }
+
+      pathi,e := cgf.HeaderIntermediateLoadPath(&hdris[s], rpath)
+      if e!=nil { log.Fatal(name, ": ", e) }
+
+      clampStepRange(ranges[s], hdris[s].StepPerPath[rpath])
+
+      for r:=0; r<len(ranges[s]); r++ {
+        beg,end := int(ranges[s][r][0]), int(ranges[s][r][1])
+        if beg>=end { continue }
+
+        tiles := [][]string{}
+        e = cgf.PathKnotScan(hdris[s].TileMap, pathi, beg, end, func(anchor_step int, knot [][]cgf.TileInfo) error {
+          for allele:=0; allele<len(knot); allele++ {
+            for len(tiles)<=allele { tiles = append(tiles, []string{}) }
+            for _,ti := range knot[allele] {
+              if gShowKnotNocallInfoFlag {
+                tiles[allele] = append(tiles[allele], cgf.TileIdNocallString(rpath, rver, ti))
+              } else {
+                tiles[allele] = append(tiles[allele], cgf.TileIdString(rpath, rver, ti))
+              }
+            }
+          }
+          return nil
+        })
+        if e!=nil { log.Fatal(name, ": ", e) }
+
+        for allele:=0; allele<len(tiles); allele++ {
+          fmt.Fprintf(out, "%s\t%d\t%s\n", name, allele, strings.Join(tiles[allele], " "))
+        }
+      }
+    }
+
+    return
+  } else if action == "compress-bench" {
+
+    // Size and random single-knot access time for each path stored raw
+    // and gzip compressed.  Each query loads the path from its stored
+    // bytes, as a reader without a path cache would.
+    //
+    nquery := 1000
+
+    cgf_bytes,e := cgf.StoreReadFile(c.String("cgf"))
+    if e!=nil { log.Fatal(e) }
+
+    hdri_raw,dn := cgf.HeaderIntermediateFromBytes(cgf_bytes)
+    if dn<0 { log.Fatal("could not construct header from bytes") }
+    hdri_gz,_ := cgf.HeaderIntermediateFromBytes(cgf_bytes)
+
+    fmt.Printf("#path\tsteps\traw_bytes\tgzip_bytes\tratio\traw_us_per_query\tgzip_us_per_query\n")
+
+    for path:=0; path<len(hdri_raw.StepPerPath); path++ {
+      if hdri_raw.StepPerPath[path]==0 { continue }
+
+      e = cgf.HeaderIntermediateCompressPath(&hdri_raw, path, cgf.CGF_COMPRESS_NONE)
+      if e!=nil { log.Fatal(e) }
+      e = cgf.HeaderIntermediateCompressPath(&hdri_gz, path, cgf.CGF_COMPRESS_GZIP)
+      if e!=nil { log.Fatal(e) }
+
+      cgf.HeaderIntermediateUpdateChecksum(&hdri_raw)
+      cgf.HeaderIntermediateUpdateChecksum(&hdri_gz)
+
+      steps := make([]int, nquery)
+      for i:=0; i<nquery; i++ { steps[i] = rand.Intn(hdri_raw.StepPerPath[path]) }
+
+      bench := func(hdri *cgf.HeaderIntermediate) float64 {
+        t := time.Now()
+        for i:=0; i<nquery; i++ {
+          pathi,e := cgf.HeaderIntermediateLoadPath(hdri, path)
+          if e!=nil { log.Fatal(e) }
+          cgf.GetKnot(hdri.TileMap, pathi, steps[i])
+        }
+        return float64(time.Since(t).Nanoseconds()) / float64(nquery) / 1000.0
+      }
+
+      raw_n := len(hdri_raw.PathBytes[path])
+      gz_n := len(hdri_gz.PathBytes[path])
+
+      fmt.Printf("%04x\t%d\t%d\t%d\t%.3f\t%.1f\t%.1f\n",
+        path, hdri_raw.StepPerPath[path],
+        raw_n, gz_n, float64(gz_n)/float64(raw_n),
+        bench(&hdri_raw), bench(&hdri_gz))
+    }
+
+    return
+  } else if action == "lookup-bench" {
+
+    // Random single-knot lookup time for each path, against the time the
+    // linear overflow count from step 0 (what every overflow lookup used
+    // to do) takes for the same steps.
+    //
+    nquery := 100000
+
+    cgf_bytes,e := cgf.StoreReadFile(c.String("cgf"))
+    if e!=nil { log.Fatal(e) }
+
+    hdri,dn := cgf.HeaderIntermediateFromBytes(cgf_bytes)
+    if dn<0 { log.Fatal("could not construct header from bytes") }
+
+    fmt.Printf("#path\tsteps\tns_per_knot\tns_per_linear_count\n")
+
+    for path:=0; path<len(hdri.StepPerPath); path++ {
+      if hdri.StepPerPath[path]==0 { continue }
+
+      pathi,e := cgf.HeaderIntermediateLoadPath(&hdri, path)
+      if e!=nil { log.Fatal(e) }
+
+      steps := make([]int, nquery)
+      for i:=0; i<nquery; i++ { steps[i] = rand.Intn(hdri.StepPerPath[path]) }
+
+      t := time.Now()
+      for i:=0; i<nquery; i++ { cgf.GetKnot(hdri.TileMap, pathi, steps[i]) }
+      knot_ns := float64(time.Since(t).Nanoseconds()) / float64(nquery)
+
+      t = time.Now()
+      for i:=0; i<nquery; i++ { cgf.CountOverflowVectorUint64(pathi.VecUint64, 0, steps[i]) }
+      count_ns := float64(time.Since(t).Nanoseconds()) / float64(nquery)
+
+      fmt.Printf("%04x\t%d\t%.1f\t%.1f\n", path, hdri.StepPerPath[path], knot_ns, count_ns)
+    }
+
+    return
+  } else if action == "diff" {
+
+    // Differences between two CGFs (--cgf and -i, or two -i): the header
+    // fields that differ ("!" lines), then, over every path both have (or
+    // the path and steps given with --tilepos or --region), each block of
+    // contiguous differing steps with the tiles of both samples in the
+    // knot-2 notation, one line per allele ("<" first file, ">" second).
+    // --ignore-nocall leaves out steps with nocalls.  Exits non-zero if
+    // the samples differ, as diff does.
+    //
+    if len(c.String("cgf"))!=0 {
+      inp_slice = append([]string{c.String("cgf")}, inp_slice...)
+    }
+    if len(inp_slice)!=2 { log.Fatal("provide two CGF files (--cgf and -i, or -i twice)") }
+
+    hdris := make([]cgf.HeaderIntermediate, 2)
+    for i:=0; i<2; i++ {
+      cgf_bytes,e := cgf.StoreReadFile(inp_slice[i])
+      if e!=nil { log.Fatal(e) }
+
+      var dn int
+      hdris[i],dn = cgf.HeaderIntermediateFromBytes(cgf_bytes)
+      if dn<0 { log.Fatal(inp_slice[i], ": could not construct header from bytes") }
+    }
+
+    sel_path := -1
+    var step_range [][2]int64
+    if len(c.String("tilepos"))>0 || len(c.String("region"))>0 {
+      p,v,r,e := tileposFromContext(c)
+      if e!=nil { log.Fatal(e) }
+      sel_path,_,step_range,e = resolveTileposRange(c, &hdris[0], p, v, r)
+      if e!=nil { log.Fatal(e) }
+    }
+
+    out := bufio.NewWriter(os.Stdout)
+
+    fmt.Fprintf(out, "--- %s\n+++ %s\n", inp_slice[0], inp_slice[1])
+
+    hdr_diff := cgf.HeaderIntermediateDiff(&hdris[0], &hdris[1])
+    for _,d := range hdr_diff { fmt.Fprintf(out, "! %s\n", d) }
+
+    nblock,nstep := 0,0
+    npath := len(hdris[0].StepPerPath)
+    if len(hdris[1].StepPerPath)<npath { npath = len(hdris[1].StepPerPath) }
+
+    for path:=0; path<npath; path++ {
+      if sel_path>=0 && path!=sel_path { continue }
+      if hdris[0].StepPerPath[path]==0 || hdris[1].StepPerPath[path]==0 { continue }
+
+      // Header differences already cover paths that can't be compared.
+      //
+      if hdris[0].StepPerPath[path]!=hdris[1].StepPerPath[path] { continue }
+      if cgf.HeaderIntermediatePathTagset(&hdris[0], path)!=cgf.HeaderIntermediatePathTagset(&hdris[1], path) { continue }
+
+      pathis := make([]cgf.PathIntermediate, 2)
+      for i:=0; i<2; i++ {
+        var e error
+        pathis[i],e = cgf.HeaderIntermediateLoadPath(&hdris[i], path)
+        if e!=nil { log.Fatal(inp_slice[i], ": ", e) }
+      }
+
+      ver := cgf.HeaderIntermediatePathTagset(&hdris[0], path)
+      if ver<0 { ver = 0 }
+
+      ranges := [][2]int64{ [2]int64{0, int64(hdris[0].StepPerPath[path])} }
+      if step_range!=nil {
+        ranges = make([][2]int64, len(step_range))
+        copy(ranges, step_range)
+        clampStepRange(ranges, hdris[0].StepPerPath[path])
+      }
+
+      for r:=0; r<len(ranges); r++ {
+        blocks,e := cgf.PathDiff(hdris[0].TileMap, pathis[0], hdris[1].TileMap, pathis[1], path, int(ranges[r][0]), int(ranges[r][1]), c.Bool("ignore-nocall"))
+        if e!=nil { log.Fatal(e) }
+
+        for _,blk := range blocks {
+          fmt.Fprintf(out, "@@ %04x.%02x.%04x-%04x (%d step", path, ver, blk.Beg, blk.End-1, blk.End-blk.Beg)
+          if blk.End-blk.Beg>1 { fmt.Fprintf(out, "s") }
+          fmt.Fprintf(out, ")\n")
+
+          for k,side := range [][][]cgf.TileInfo{ blk.A, blk.B } {
+            mark := "<"
+            if k==1 { mark = ">" }
+            for allele:=0; allele<len(side); allele++ {
+              ids := make([]string, len(side[allele]))
+              for j:=0; j<len(ids); j++ { ids[j] = cgf.TileIdNocallString(path, ver, side[allele][j]) }
+              fmt.Fprintf(out, "%s %s\n", mark, strings.Join(ids, " "))
+            }
+          }
+
+          nblock++
+          nstep += blk.End-blk.Beg
+        }
+      }
+    }
+
+    out.Flush()
+
+    if len(hdr_diff)>0 || nblock>0 {
+      fmt.Fprintf(os.Stderr, "%d header differences, %d steps differ in %d blocks\n", len(hdr_diff), nstep, nblock)
+      os.Exit(1)
+    }
+    return
+
+  } else if action == "roundtrip-check" {
+
+    // Decode path --path of the CGF and compare it, tile by tile, with
+    // the FastJ it was encoded from (-i).  Exits non-zero on the first
+    // mismatch.
+    //
+    if len(inp_slice)!=1 { log.Fatal("provide the original FastJ (-i)") }
+
+    path_u64,e := strconv.ParseInt(c.String("path"), 16, 64)
+    if e!=nil { log.Fatal(fmt.Sprintf("invalid path: %v", e)) }
+    path := int(path_u64)
+
+    _sglf,e := cgf.LoadSGLF(c.String("sglf"))
+    if e!=nil { log.Fatal(e) }
+
+    ain,e := autoio.OpenReadScanner(inp_slice[0])
+    if e!=nil { log.Fatal(e) }
+    orig,e := cgf.LoadSampleFastj(&ain)
+    ain.Close()
+    if e!=nil { log.Fatal(fmt.Sprintf("%s: %v", inp_slice[0], e)) }
+
+    cgf_bytes,e := cgf.StoreReadFile(c.String("cgf"))
+    if e!=nil { log.Fatal(e) }
+
+    hdri,dn := cgf.HeaderIntermediateFromBytes(cgf_bytes)
+    if dn<0 { log.Fatal("could not construct header from bytes") }
+
+    pathi,e := cgf.HeaderIntermediateLoadPath(&hdri, path)
+    if e!=nil { log.Fatal(e) }
+
+    ver := cgf.HeaderIntermediatePathTagset(&hdri, path)
+    if ver<0 { ver = 0 }
+
+    mm,ntile,e := cgf.PathRoundtripCheck(hdri.TileMap, pathi, path, ver, orig, &_sglf)
+    if e!=nil { log.Fatal(e) }
+
+    if mm!=nil {
+      fmt.Printf("%s: FAIL after %d tiles\n%s", c.String("cgf"), ntile, mm.String())
+      os.Exit(1)
+    }
+
+    fmt.Printf("%s: ok, path %04x, %d tiles\n", c.String("cgf"), path, ntile)
+    return
+
+  } else if action == "synth" {
+
+    // Random library, FastJ samples and CGFs in the output directory
+    // (see the synth package).  Unset --synth-* options keep their
+    // defaults.
+    //
+    if c.String("output")=="-" || len(c.String("output"))==0 { log.Fatal("provide output directory (-o)") }
+
+    p := synth.DefaultParams()
+    if c.IsSet("seed") { p.Seed = int64(c.Int("seed")) }
+    if c.IsSet("synth-paths") { p.Paths = c.Int("synth-paths") }
+    if c.IsSet("synth-steps") { p.Steps = c.Int("synth-steps") }
+    if c.IsSet("synth-samples") { p.Samples = c.Int("synth-samples") }
+    if c.IsSet("synth-het") { p.Het = c.Float64("synth-het") }
+    if c.IsSet("synth-span") { p.SpanRate = c.Float64("synth-span") }
+    if c.IsSet("synth-nocall") { p.NocallRate = c.Float64("synth-nocall") }
+
+    res,e := synth.Generate(c.String("output"), p)
+    if e!=nil { log.Fatal(e) }
+
+    fmt.Printf("%s\n", res.LibraryFile)
+    for i:=0; i<len(res.Samples); i++ {
+      fmt.Printf("%s\t%s\n", res.Samples[i], res.CGFFiles[i])
+    }
+    return
+
+  } else if action == "serve" {
+
+    // Serve queries over HTTP/JSON on the CGFs in the directory given
+    // with -i (see cgf.CGFServer).
+    //
+    if len(inp_slice)!=1 { log.Fatal("provide one CGF directory to serve (-i)") }
+
+    srv,e := cgf.NewCGFServer(inp_slice[0], c.Int("cache-paths"))
+    if e!=nil { log.Fatal(e) }
+
+    srv.TagsetMap,e = tagsetMapFromContext(c)
+    if e!=nil { log.Fatal(e) }
+
+    if gVerboseFlag { fmt.Fprintf(os.Stderr, "serving %d samples from %s on %s\n", len(srv.Samples), inp_slice[0], c.String("listen")) }
+    log.Fatal(http.ListenAndServe(c.String("listen"), srv))
+
+  } else if action == "peel" {
+
+    path,ver,step,e := cgf.ParseTilepos(c.String("tilepos"))
+    if e!=nil { log.Fatal(e) }
+    _ = path ; _ = ver ; _ = step
+
+
+    cgf_bytes,e := cgf.StoreReadFile(c.String("cgf"))
+    if e!=nil { log.Fatal(e) }
+
+    fmt.Printf("path %x, ver %x, step %x\n", path, ver, step)
+
+    cgf.Peel(cgf_bytes, path, step)
+
+    return
+  }
+
+  ain_slice := make([]autoio.AutoioHandle, 0, 8)
+  for i:=0; i<len(inp_slice); i++ {
+    inp_fn := inp_slice[i]
+    ain,err := autoio.OpenReadScanner(inp_fn) ; _ = ain
+    if err!=nil {
+      fmt.Fprintf(os.Stderr, "%v", err)
+      os.Exit(1)
+    }
+    defer ain.Close()
+    ain_slice = append(ain_slice, ain)
+  }
+
+
+  aout,err := autoio.CreateWriter( c.String("output") ) ; _ = aout
+  if err!=nil {
+    fmt.Fprintf(os.Stderr, "%v", err)
+    os.Exit(1)
+  }
+  defer func() { aout.Flush() ; aout.Close() }()
+
+  if c.Bool( "pprof" ) {
+    gProfileFlag = true
+    gProfileFile = c.String("pprof-file")
+  }
+
+  if c.Bool( "mprof" ) {
+    gMemProfileFlag = true
+    gMemProfileFile = c.String("mprof-file")
+  }
+
+  gVerboseFlag = c.Bool("Verbose")
+
+  if c.Int("max-procs") > 0 {
+    runtime.GOMAXPROCS( c.Int("max-procs") )
+  }
+
+  if gProfileFlag {
+    prof_f,err := os.Create( gProfileFile )
+    if err != nil {
+      fmt.Fprintf( os.Stderr, "Could not open profile file %s: %v\n", gProfileFile, err )
+      os.Exit(2)
+    }
+
+    pprof.StartCPUProfile( prof_f )
+    defer pprof.StopCPUProfile()
+  }
+
+
+  _sglf,e := cgf.LoadSGLF(c.String("sglf"))
+  if e!=nil { log.Fatal(e) }
+
+  ctx := cgf.CGFContext{}
+  _cgf := cgf.CGF{}
+
+  header_bytes := cgf.CGFDefaultHeaderBytes()
+  cgf.CGFFillHeader(&_cgf, header_bytes)
+
+  ctx.CGF = &_cgf
+  ctx.SGLF = &_sglf
+  ctx.ConstructTileMapLookup()
+
+
+  for i:=0; i<len(ain_slice); i++ {
+    ain := ain_slice[i]
+
+    allele_path,e := cgf.LoadSampleFastj(&ain)
+    if e!=nil { log.Fatal(e) }
+
+    p := 0x2c5
+    if i>0 { p = 0x247 }
+
+    e = ctx.UpdateVectorPathSimple(p, allele_path)
+    if len(ctx.CGF.StepPerPath) < len(ain_slice) {
+      ctx.CGF.StepPerPath = append(ctx.CGF.StepPerPath, uint64(len(_sglf.Lib[p])))
+    }
+
+  }
+
+  ctx.CGF.PathCount = uint64(len(_cgf.Path))
+  ctx.CGF.StepPerPath = make([]uint64, ctx.CGF.PathCount)
+  for i:=uint64(0); i<ctx.CGF.PathCount; i++ {
+    ctx.CGF.StepPerPath[i] = uint64(len(_sglf.Lib[int(i)]))
+  }
+
+  ctx.WriteCGF("out.cgf")
+
+}
+
+func main() {
+
+  app := cli.NewApp()
+  app.Name  = "cgf"
+  app.Usage = "CGF"
+  app.Version = VERSION_STR + " (cgf " + cgf.VERSION_STR + ")"
+  app.Author = "Curoverse, Inc."
+  app.Email = "info@curoverse.com"
+  app.Action = func( c *cli.Context ) { _main(c) }
+
+  app.Flags = []cli.Flag{
+
+    cli.StringFlag{
+      Name: "cgf, c",
+      Usage: "CGF",
+    },
+
+    cli.StringSliceFlag{
+      Name: "input,i",
+      Usage: "INPUT",
+    },
+
+    cli.StringFlag{
+      Name: "cglf, L",
+      Usage: "CGLF",
+    },
+
+    cli.StringFlag{
+      Name: "sglf, S",
+      Usage: "SGLF",
+    },
+
+    cli.StringFlag{
+      Name: "tilepos, p",
+      Usage: "Tile Position",
+    },
+
+    cli.StringFlag{
+      Name: "path, P",
+      Usage: "Path (in hex)",
+    },
+
+    cli.StringFlag{
+      Name: "ocgf, output, o",
+      Value: "-",
+      Usage: "OUTPUT",
+    },
 
     cli.StringFlag{
       Name: "append, a",
@@ -632,6 +2269,204 @@ func main() {
       Usage: "OUTPUT",
     },
 
+    cli.StringFlag{
+      Name: "region",
+      Usage: "Reference region chrom:start-end (1 based, inclusive) to use instead of --tilepos, looked up in --assembly",
+    },
+
+    cli.StringFlag{
+      Name: "assembly",
+      Usage: "Tile assembly (tile position to reference end coordinate)",
+    },
+
+    cli.StringFlag{
+      Name: "format",
+      Usage: "Output format (loq-export: tile|bed|frac, freq: tsv|json)",
+    },
+
+    cli.Float64Flag{
+      Name: "max-nocall-frac",
+      Value: -1,
+      Usage: "Export filter: drop (or --mask) knots where either allele has more than this fraction of nocall bases",
+    },
+
+    cli.BoolFlag{
+      Name: "drop-final-overflow",
+      Usage: "Export filter: drop (or --mask) knots stored in final overflow",
+    },
+
+    cli.IntFlag{
+      Name: "max-span",
+      Value: 0,
+      Usage: "Export filter: drop (or --mask) knots with a tile spanning more than this many steps",
+    },
+
+    cli.BoolFlag{
+      Name: "mask",
+      Usage: "Export filter: mask filtered knots instead of dropping them",
+    },
+
+    cli.StringFlag{
+      Name: "index",
+      Usage: "Tile variant index directory (index-build, index-query)",
+    },
+
+    cli.StringFlag{
+      Name: "listen",
+      Value: ":8080",
+      Usage: "Address to listen on (serve)",
+    },
+
+    cli.IntFlag{
+      Name: "cache-paths",
+      Value: 256,
+      Usage: "Number of decoded paths to keep cached (serve)",
+    },
+
+    cli.IntFlag{
+      Name: "seed",
+      Value: 1,
+      Usage: "Random seed (synth)",
+    },
+
+    cli.IntFlag{
+      Name: "synth-paths",
+      Value: 1,
+      Usage: "Number of paths (synth)",
+    },
+
+    cli.IntFlag{
+      Name: "synth-steps",
+      Value: 300,
+      Usage: "Steps per path (synth)",
+    },
+
+    cli.IntFlag{
+      Name: "synth-samples",
+      Value: 3,
+      Usage: "Number of samples (synth)",
+    },
+
+    cli.Float64Flag{
+      Name: "synth-het",
+      Value: 0.3,
+      Usage: "Chance of a non-canonical tile per allele and step (synth)",
+    },
+
+    cli.Float64Flag{
+      Name: "synth-span",
+      Value: 0.1,
+      Usage: "Chance a library step has a spanning tile variant (synth)",
+    },
+
+    cli.Float64Flag{
+      Name: "synth-nocall",
+      Value: 0.05,
+      Usage: "Chance of a nocall run per tile (synth)",
+    },
+
+    cli.StringFlag{
+      Name: "varid",
+      Usage: "Tile variant id in hex (index-query)",
+    },
+
+    cli.StringFlag{
+      Name: "sample-id",
+      Usage: "Sample id to record in the metadata with append, or to take out of the bundle with bundle-extract",
+    },
+
+    cli.StringFlag{
+      Name: "new-sglf",
+      Usage: "New release of the SGLF library to lift the CGF over to (liftover)",
+    },
+
+    cli.StringFlag{
+      Name: "library-version",
+      Usage: "Library version to record in the lifted over CGF (liftover)",
+    },
+
+    cli.StringFlag{
+      Name: "tagset-map",
+      Usage: "Tagset mapping, to give tile positions in a tagset other than the one the CGF (or tile index) was encoded against",
+    },
+
+    cli.BoolFlag{
+      Name: "unphased",
+      Usage: "Alleles of the FastJ aren't phased, store knots in canonical allele order (append)",
+    },
+
+    cli.StringFlag{
+      Name: "phase-blocks",
+      Usage: "Phase block starts of the appended path, one tile position per line (append)",
+    },
+
+    cli.StringFlag{
+      Name: "annotation",
+      Usage: "Per-tile annotation to add to the appended path (append)",
+    },
+
+    cli.StringFlag{
+      Name: "annot-track",
+      Usage: "Annotation track to export (annot-export)",
+    },
+
+    cli.BoolFlag{
+      Name: "ignore-nocall",
+      Usage: "Leave out steps where either sample has nocalls (diff)",
+    },
+
+    cli.Float64Flag{
+      Name: "min-freq",
+      Value: 0.01,
+      Usage: "Minimum frequency (and at most 1 minus it) of a tile variant to export as a marker (plink)",
+    },
+
+    cli.StringFlag{
+      Name: "gff",
+      Usage: "Gene annotation, GFF3 or GTF (genes)",
+    },
+
+    cli.StringSliceFlag{
+      Name: "gene",
+      Usage: "Gene to report, by name or id, can be repeated (genes)",
+    },
+
+    cli.StringFlag{
+      Name: "canon-table",
+      Usage: "Cohort canonical variant table to encode with (append, canon-apply)",
+    },
+
+    cli.Float64Flag{
+      Name: "canon-min-freq",
+      Value: 0,
+      Usage: "Minimum allele frequency of a variant to make it canonical (canon-table)",
+    },
+
+    cli.StringFlag{
+      Name: "bundle",
+      Usage: "Multi-sample CGF bundle (bundle-create, bundle-add, bundle-extract, bundle-query)",
+    },
+
+    cli.StringFlag{
+      Name: "key",
+      Usage: "Metadata key (meta-get, meta-set)",
+    },
+
+    cli.StringFlag{
+      Name: "value",
+      Usage: "Metadata value (meta-set, empty to remove the key)",
+    },
+
+    cli.StringFlag{
+      Name: "checksum",
+      Usage: "Checksum table to write with append (none|crc32c|sha256), default keeps the input's",
+    },
+
+    cli.StringFlag{
+      Name: "compress",
+      Usage: "Path block compression to write with append (none|gzip), default keeps the input's",
+    },
+
     cli.BoolFlag{
       Name: "hide-knot-low-quality",
       Usage: "Don't show low quality information for knot",
@@ -640,7 +2475,7 @@ func main() {
     cli.StringFlag{
       Name: "action, A",
       Value: "",
-      Usage: "(help|debug|headercheck|header|tilemapentry|knot|knot-2|knot-z|fastj|fastj-range|fastj2cgf|sglfbarf|append|peel)",
+      Usage: "(help|debug|headercheck|header|tilemapentry|knot|knot-2|knot-z|fastj|fastj-range|fastj2cgf|sglfbarf|append|verify|liftover|annot-import|annot-export|genes|canon-table|canon-apply|bundle-create|bundle-add|bundle-extract|bundle-query|compress-bench|lookup-bench|meta-get|meta-set|loq-export|freq|plink|index-build|index-query|distance|diff|fasta|serve|roundtrip-check|synth|peel)",
     },
 
     cli.IntFlag{
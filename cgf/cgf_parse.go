@@ -2,8 +2,14 @@ package main
 
 
 import "fmt"
+import "os"
 import "strings"
 import "strconv"
+import "path/filepath"
+
+import "github.com/codegangsta/cli"
+import "github.com/abeconnelly/cgf"
+import "github.com/abeconnelly/cglf"
 
 // Parses an 'int option' of the form:
 //
@@ -93,3 +99,228 @@ func parseIntOption( istr string, base int ) ([][2]int64, error) {
 
   return r,nil
 }
+
+// Parses a tile position with a step range, of the form 'path.step'
+// or 'path.ver.step', where path and ver are single hex values and
+// step is an int option (see above), e.g. '2c5.00.100-1ff'.
+//
+// An open ended step range is closed at `nstep` by clampStepRange.
+//
+func parseTileposRange( s string ) (int, int, [][2]int64, error) {
+  parts := strings.Split( s, "." )
+  if len(parts)!=2 && len(parts)!=3 { return -1, -1, nil, fmt.Errorf("invalid tilepos %s (must be 2 or 3 fields '.' separated)", s) }
+
+  path,e := strconv.ParseInt( parts[0], 16, 64 )
+  if e!=nil { return -1, -1, nil, fmt.Errorf("invalid path in tilepos: %v", e) }
+
+  ver := int64(0)
+  if len(parts)==3 {
+    ver,e = strconv.ParseInt( parts[1], 16, 64 )
+    if e!=nil { return -1, -1, nil, fmt.Errorf("invalid version in tilepos: %v", e) }
+  }
+
+  step_range,e := parseIntOption( parts[len(parts)-1], 16 )
+  if e!=nil { return -1, -1, nil, fmt.Errorf("invalid step in tilepos: %v", e) }
+
+  return int(path), int(ver), step_range, nil
+}
+
+func clampStepRange( step_range [][2]int64, nstep int ) {
+  for i:=0; i<len(step_range); i++ {
+    if step_range[i][1] == -1 || step_range[i][1] > int64(nstep) {
+      step_range[i][1] = int64(nstep)
+    }
+  }
+}
+
+// Builds the export quality filter from the command line options.
+// tile_len supplies tile lengths for the nocall fraction and is nil if
+// no library was given.
+//
+func knotFilterFromContext( c *cli.Context, tile_len func(path, step, varid, span int) int ) (*cgf.KnotFilter, error) {
+  f := cgf.NewKnotFilter()
+
+  if c.IsSet("max-nocall-frac") {
+    f.MaxNocallFrac = c.Float64("max-nocall-frac")
+    if f.MaxNocallFrac<0 || f.MaxNocallFrac>1 { return nil, fmt.Errorf("--max-nocall-frac must be in [0,1]") }
+    if tile_len==nil { return nil, fmt.Errorf("--max-nocall-frac needs a tile library (--sglf or --cglf)") }
+  }
+
+  f.DropFinalOverflow = c.Bool("drop-final-overflow")
+  f.MaxSpan = c.Int("max-span")
+  f.Mask = c.Bool("mask")
+  f.TileLen = tile_len
+
+  return f, nil
+}
+
+// Tile length from the library, -1 if the library doesn't have the tile.
+//
+func sglfTileLen( sglf *cglf.SGLF ) func(path, step, varid, span int) int {
+  return func(path, step, varid, span int) int {
+    lib,ok := sglf.Lib[path]
+    if !ok || step<0 || step>=len(lib) || varid<0 || varid>=len(lib[step]) { return -1 }
+    return len(lib[step][varid])
+  }
+}
+
+// Expands the input list, replacing each directory (local or in a
+// store) by the *.cgf files in it (sorted by name).
+//
+func cgfInputFiles( inp []string ) ([]string, error) {
+  fns := make([]string, 0, len(inp))
+  for i:=0; i<len(inp); i++ {
+    if !cgf.StoreIsDir(inp[i]) {
+      fns = append(fns, inp[i])
+      continue
+    }
+    m,e := cgf.StoreList(inp[i], ".cgf")
+    if e!=nil { return nil, e }
+    fns = append(fns, m...)
+  }
+  return fns, nil
+}
+
+// Sample name from the header metadata, or the file name without its
+// .cgf suffix if there is none.
+//
+func cgfSampleName( hdri *cgf.HeaderIntermediate, fn string ) string {
+  name,ok := cgf.HeaderIntermediateGetMeta(hdri, cgf.CGF_META_SAMPLE_ID)
+  if ok && len(name)>0 { return name }
+  return strings.TrimSuffix(filepath.Base(fn), ".cgf")
+}
+
+// Path, version and step ranges from --region (looked up in --assembly)
+// if given, otherwise from --tilepos.  A region has to fall in a single
+// path.
+//
+func tileposFromContext( c *cli.Context ) (int, int, [][2]int64, error) {
+  if len(c.String("region"))==0 {
+    if len(c.String("tilepos"))==0 { return -1, -1, nil, fmt.Errorf("provide tile position (-p) or region (--region)") }
+    return parseTileposRange(c.String("tilepos"))
+  }
+
+  if len(c.String("assembly"))==0 { return -1, -1, nil, fmt.Errorf("--region requires --assembly") }
+  asm,e := cgf.LoadTileAssembly(c.String("assembly"))
+  if e!=nil { return -1, -1, nil, e }
+
+  chrom,start,end,e := cgf.ParseRegion(c.String("region"))
+  if e!=nil { return -1, -1, nil, e }
+
+  r,e := asm.TileposForRegion(chrom, start, end)
+  if e!=nil { return -1, -1, nil, e }
+  if len(r)>1 {
+    return -1, -1, nil, fmt.Errorf("region %s spans paths %04x to %04x, give one path at a time", c.String("region"), r[0].Path, r[len(r)-1].Path)
+  }
+
+  if gVerboseFlag {
+    fmt.Fprintf(os.Stderr, "region %s: %04x.%02x.%04x-%04x\n", c.String("region"), r[0].Path, asm.Tagset, r[0].Beg, r[0].End)
+  }
+
+  return r[0].Path, asm.Tagset, [][2]int64{ [2]int64{ int64(r[0].Beg), int64(r[0].End) } }, nil
+}
+
+// Tagset mapping from --tagset-map (loaded once), nil if none was given.
+//
+func tagsetMapFromContext( c *cli.Context ) (*cgf.TagsetMap, error) {
+  if gTagsetMap!=nil || len(c.String("tagset-map"))==0 { return gTagsetMap, nil }
+
+  tmap,e := cgf.LoadTagsetMap(c.String("tagset-map"))
+  if e!=nil { return nil, e }
+  gTagsetMap = tmap
+  return tmap, nil
+}
+
+// Tile position converted to the tagset the path of hdri was encoded
+// against (see cgf.HeaderIntermediateResolveTilepos).
+//
+func resolveTileposStep( c *cli.Context, hdri *cgf.HeaderIntermediate, path, ver, step int ) (int, int, int, error) {
+  tmap,e := tagsetMapFromContext(c)
+  if e!=nil { return -1, -1, -1, e }
+  return cgf.HeaderIntermediateResolveTilepos(hdri, tmap, path, ver, step)
+}
+
+// As resolveTileposStep for step ranges.  The ranges are copied, open
+// ends stay open.
+//
+func resolveTileposRange( c *cli.Context, hdri *cgf.HeaderIntermediate, path, ver int, step_range [][2]int64 ) (int, int, [][2]int64, error) {
+  return resolveStepRanges(c, func(tmap *cgf.TagsetMap, path, ver, beg, end int) (int, int, int, int, error) {
+    return cgf.HeaderIntermediateResolveRange(hdri, tmap, path, ver, beg, end)
+  }, path, ver, step_range)
+}
+
+// As resolveTileposRange against the tagsets recorded in a tile index.
+//
+func resolveIndexTileposRange( c *cli.Context, idx *cgf.TileIndex, path, ver int, step_range [][2]int64 ) (int, int, [][2]int64, error) {
+  return resolveStepRanges(c, idx.ResolveRange, path, ver, step_range)
+}
+
+func resolveStepRanges( c *cli.Context,
+                        resolve func(tmap *cgf.TagsetMap, path, ver, beg, end int) (int, int, int, int, error),
+                        path, ver int, step_range [][2]int64 ) (int, int, [][2]int64, error) {
+  tmap,e := tagsetMapFromContext(c)
+  if e!=nil { return -1, -1, nil, e }
+
+  res := make([][2]int64, len(step_range))
+  rpath,rver := path,ver
+  for i:=0; i<len(step_range); i++ {
+    p,v,beg,end,e := resolve(tmap, path, ver, int(step_range[i][0]), int(step_range[i][1]))
+    if e!=nil { return -1, -1, nil, e }
+    if i>0 && p!=rpath { return -1, -1, nil, fmt.Errorf("step ranges map to different paths (%04x, %04x)", rpath, p) }
+    rpath,rver = p,v
+    res[i] = [2]int64{ int64(beg), int64(end) }
+  }
+  return rpath, rver, res, nil
+}
+
+// Step ranges of --tilepos or --region for cgf.CohortFreq, resolved
+// against each sample's header as resolveTileposRange does.  Open ends
+// stay open.  nil if neither option is given (every step of every path).
+//
+func cohortRangesFromContext( c *cli.Context ) (cgf.SampleRangeFunc, error) {
+  if len(c.String("tilepos"))==0 && len(c.String("region"))==0 { return nil, nil }
+
+  path,ver,step_range,e := tileposFromContext(c)
+  if e!=nil { return nil, e }
+
+  // Load the tagset map here, the returned function is called from
+  // several goroutines.
+  //
+  _,e = tagsetMapFromContext(c)
+  if e!=nil { return nil, e }
+
+  return func(hdri *cgf.HeaderIntermediate) (map[int][][2]int, error) {
+    rpath,_,r,e := resolveTileposRange(c, hdri, path, ver, step_range)
+    if e!=nil { return nil, e }
+    res := make([][2]int, len(r))
+    for i:=0; i<len(r); i++ { res[i] = [2]int{ int(r[i][0]), int(r[i][1]) } }
+    return map[int][][2]int{ rpath:res }, nil
+  }, nil
+}
+
+// Steps of the tile position in --tilepos, or of every step of --region,
+// resolved against hdri (see resolveTileposStep).  Region steps past the
+// end of the path are dropped.
+//
+func tileposStepsFromContext( c *cli.Context, hdri *cgf.HeaderIntermediate ) (int, int, []int, error) {
+  if len(c.String("region"))==0 {
+    path,ver,step,e := cgf.ParseTilepos(c.String("tilepos"))
+    if e!=nil { return -1, -1, nil, e }
+    path,ver,step,e = resolveTileposStep(c, hdri, path, ver, step)
+    if e!=nil { return -1, -1, nil, e }
+    return path, ver, []int{step}, nil
+  }
+
+  path,ver,step_range,e := tileposFromContext(c)
+  if e!=nil { return -1, -1, nil, e }
+  path,ver,step_range,e = resolveTileposRange(c, hdri, path, ver, step_range)
+  if e!=nil { return -1, -1, nil, e }
+  if path<0 || path>=len(hdri.StepPerPath) { return -1, -1, nil, fmt.Errorf("path out of range (max %d paths)", len(hdri.StepPerPath)) }
+
+  clampStepRange(step_range, hdri.StepPerPath[path])
+  steps := []int{}
+  for r:=0; r<len(step_range); r++ {
+    for step:=int(step_range[r][0]); step<int(step_range[r][1]); step++ { steps = append(steps, step) }
+  }
+  return path, ver, steps, nil
+}
@@ -36,17 +36,14 @@ func CGFTilemapBytes(cgf_bytes []byte) ([]byte, error) {
   return cgf.TileMap, nil
 }
 
+// Path bytes, inflated if the path is stored compressed.
+//
 func CGFPathBytes(cgf_bytes []byte, path int) ([]byte, error) {
-  cgf := CGF{}
-  CGFFillHeader(&cgf, cgf_bytes)
-
-  if path>=len(cgf.PathOffset) { return nil, fmt.Errorf("path does not exist in CGF") }
-
-  be := cgf.PathOffset[path] + cgf.PathByteOffset
-  en := cgf.PathOffset[path+1] + cgf.PathByteOffset
-
-  return cgf_bytes[be:en], nil
+  hdri,dn := HeaderIntermediateFromBytes(cgf_bytes)
+  if dn<0 { return nil, fmt.Errorf("could not construct header from bytes") }
 
+  if path<0 || path>=len(hdri.PathBytes) { return nil, fmt.Errorf("path does not exist in CGF") }
+  return HeaderIntermediateRawPathBytes(&hdri, path)
 }
 
 type PathOverflowStruct struct {
@@ -392,9 +389,14 @@ func CountOverflow_old(cgf_bytes []byte, path, start_step, step int) (int,error)
 
 }
 
-func LookupTileMap(cgf_bytes []byte, path, ver, step int) (int, error) {
+// Tile map index of the knot anchored at step of a parsed header's path
+// (see HeaderIntermediateFromBytes).
+//
+func LookupTileMap(hdri *HeaderIntermediate, path, ver, step int) (int, error) {
 
-  path_bytes,e := CGFPathBytes(cgf_bytes, path)
+  if _,_,_,e := HeaderIntermediateResolveTilepos(hdri, nil, path, ver, step) ; e!=nil { return -1, e }
+
+  path_bytes,e := HeaderIntermediateRawPathBytes(hdri, path)
   if e!=nil { return -1, e }
 
   st:=0
@@ -424,10 +426,7 @@ func LookupTileMap(cgf_bytes []byte, path, ver, step int) (int, error) {
 
   // See if the value is stored in the hexit
   //
-  hexit_shift := uint(0)
-  for i:=uint8(0); i<m; i++ {
-    if (vecbits & (1<<(32+i))) != 0 { hexit_shift++ }
-  }
+  hexit_shift := uint(_vec_cache_count(vecbits, uint(m)))
 
   //DEBUG
   fmt.Printf(">>>>>> hexitpos %d\n", hexit_shift)
@@ -461,8 +460,8 @@ func LookupTileMap(cgf_bytes []byte, path, ver, step int) (int, error) {
 
   fmt.Printf(">>> start_idx %d, start_step %d\n", start_idx, start_step)
 
-  n_ovf,e := CountOverflow(cgf_bytes, path, int(start_step), step) ; _ = n_ovf
-  if e!=nil { return -1, nil }
+  pathi,_ := PathIntermediateFromBytes(path_bytes)
+  n_ovf := CountOverflowVectorUint64(pathi.VecUint64, int(start_step), step)
 
   n_ovf++
 
@@ -500,20 +499,17 @@ func LookupTileMap(cgf_bytes []byte, path, ver, step int) (int, error) {
 }
 
 
-func LookupTileMapEntry(cgf_bytes []byte, path, ver, step int) (TileMapEntry, error) {
+func LookupTileMapEntry(hdri *HeaderIntermediate, path, ver, step int) (TileMapEntry, error) {
   tme := TileMapEntry{}
 
-  tm,e := LookupTileMap(cgf_bytes, path, ver, step)
+  tm,e := LookupTileMap(hdri, path, ver, step)
   if e!=nil { return tme, e }
 
   tme.TileMap = tm
 
   if tm>=0 {
-    tilemap_bytes,e := CGFTilemapBytes(cgf_bytes)
-    if e!=nil { return tme, e }
-    //tile_map := unpack_tilemap(tilemap_bytes)
-    tile_map := UnpackTileMap(tilemap_bytes)
-    tme := tile_map[tm]
+    if tm>=len(hdri.TileMap) { return tme, fmt.Errorf("tile map entry %d out of range", tm) }
+    tme := hdri.TileMap[tm]
     tme.Variant = tme.Variant
     tme.Span = tme.Span
     return tme,nil
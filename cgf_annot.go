@@ -0,0 +1,350 @@
+package cgf
+
+import "fmt"
+import "math"
+import "sort"
+import "bufio"
+import "bytes"
+import "strings"
+import "strconv"
+
+import "github.com/abeconnelly/dlug"
+
+// Per-tile annotation extension record (CGF_EXT_ANNOT):
+//
+//   PathCount dlug
+//   { PathLen dlug, PathData [PathLen]byte } [PathCount]
+//
+// PathData holds the annotation tracks of the path:
+//
+//   TrackCount dlug
+//   { NameLen dlug, Name [NameLen]byte, Type dlug, Count dlug,
+//     { StepDelta dlug, N dlug, Value [N]dlug } [Count]
+//   } [TrackCount]
+//
+// A track (read depth, genotype quality, ...) is sparse by step like the
+// loq section: only steps with a value are stored, in increasing order,
+// StepDelta from the previous one (from 0 for the first).  Values are per
+// allele, N of them at a step.  CGF_ANNOT_UINT values are stored as is,
+// CGF_ANNOT_FLOAT values as their float32 bits.  Paths past PathCount
+// have no annotation.  Readers that don't know the record skip it like
+// any other extension record.
+//
+// Annotation is imported from text with lines of
+//
+//   <path>.<ver>.<step>  <name>  <value>[,<value>...]
+//
+// one value per allele.  A new track is CGF_ANNOT_FLOAT if any of its
+// values isn't a whole number, CGF_ANNOT_UINT otherwise.
+//
+
+const CGF_EXT_ANNOT int = 7
+
+const CGF_ANNOT_UINT int = 0
+const CGF_ANNOT_FLOAT int = 1
+
+// Conventional track names
+//
+const CGF_ANNOT_DEPTH string = "depth"
+const CGF_ANNOT_GQ string = "gq"
+
+type AnnotTrack struct {
+  Name string
+  Type int
+
+  // Step is sorted, Value[i] holds the per allele values at Step[i].
+  //
+  Step []int
+  Value [][]float64
+}
+
+// Values at step, nil if there are none.
+//
+func (t *AnnotTrack) Get(step int) []float64 {
+  i := sort.SearchInts(t.Step, step)
+  if i<len(t.Step) && t.Step[i]==step { return t.Value[i] }
+  return nil
+}
+
+// Set (or replace) the values at step.
+//
+func (t *AnnotTrack) Set(step int, v []float64) error {
+  for _,x := range v {
+    if t.Type==CGF_ANNOT_UINT && (x<0 || x!=math.Floor(x) || x>=(1<<63)) {
+      return fmt.Errorf("annotation %s: %v is not an unsigned integer", t.Name, x)
+    }
+  }
+
+  i := sort.SearchInts(t.Step, step)
+  if i<len(t.Step) && t.Step[i]==step {
+    t.Value[i] = v
+    return nil
+  }
+
+  t.Step = append(t.Step, 0)
+  t.Value = append(t.Value, nil)
+  copy(t.Step[i+1:], t.Step[i:])
+  copy(t.Value[i+1:], t.Value[i:])
+  t.Step[i],t.Value[i] = step,v
+  return nil
+}
+
+func (t *AnnotTrack) FormatValue(x float64) string {
+  if t.Type==CGF_ANNOT_UINT { return strconv.FormatUint(uint64(x), 10) }
+  return strconv.FormatFloat(x, 'g', -1, 32)
+}
+
+func AnnotTrackFind(tracks []AnnotTrack, name string) *AnnotTrack {
+  for i:=0; i<len(tracks); i++ {
+    if tracks[i].Name==name { return &tracks[i] }
+  }
+  return nil
+}
+
+func _annot_path_blocks(hdri *HeaderIntermediate) ([][]byte, error) {
+  blocks := make([][]byte, hdri.pathcount)
+
+  b,ok := HeaderIntermediateGetExt(hdri, CGF_EXT_ANNOT)
+  if !ok { return blocks, nil }
+
+  n:=0
+  npath,dn := dlug.ConvertUint64(b[n:])
+  if dn<=0 { return nil, fmt.Errorf("bad annotation record") }
+  n+=dn
+
+  for i:=0; i<int(npath); i++ {
+    l,dn := dlug.ConvertUint64(b[n:])
+    if dn<=0 || (n+dn+int(l))>len(b) { return nil, fmt.Errorf("bad annotation record") }
+    n+=dn
+    if i<len(blocks) && l>0 { blocks[i] = b[n:n+int(l)] }
+    n+=int(l)
+  }
+  return blocks, nil
+}
+
+func _annot_tracks_from_bytes(b []byte) ([]AnnotTrack, error) {
+  bad := fmt.Errorf("bad annotation record")
+  n:=0
+
+  next := func() (uint64, bool) {
+    if n>=len(b) { return 0, false }
+    u,dn := dlug.ConvertUint64(b[n:])
+    if dn<=0 { return 0, false }
+    n+=dn
+    return u, true
+  }
+
+  ntrack,ok := next()
+  if !ok { return nil, bad }
+
+  tracks := make([]AnnotTrack, 0, ntrack)
+  for i:=0; i<int(ntrack); i++ {
+    l,ok := next()
+    if !ok || n+int(l)>len(b) { return nil, bad }
+    t := AnnotTrack{ Name:string(b[n:n+int(l)]) }
+    n+=int(l)
+
+    typ,ok := next()
+    if !ok { return nil, bad }
+    t.Type = int(typ)
+    if t.Type!=CGF_ANNOT_UINT && t.Type!=CGF_ANNOT_FLOAT { return nil, fmt.Errorf("annotation %s: unknown type %d", t.Name, t.Type) }
+
+    count,ok := next()
+    if !ok || int(count)>len(b) { return nil, bad }
+    t.Step = make([]int, count)
+    t.Value = make([][]float64, count)
+
+    step := 0
+    for j:=0; j<int(count); j++ {
+      d,ok0 := next()
+      nv,ok1 := next()
+      if !ok0 || !ok1 || int(nv)>len(b) { return nil, bad }
+      step += int(d)
+      t.Step[j] = step
+
+      t.Value[j] = make([]float64, nv)
+      for k:=0; k<int(nv); k++ {
+        u,ok := next()
+        if !ok { return nil, bad }
+        if t.Type==CGF_ANNOT_FLOAT {
+          t.Value[j][k] = float64(math.Float32frombits(uint32(u)))
+        } else {
+          t.Value[j][k] = float64(u)
+        }
+      }
+    }
+
+    tracks = append(tracks, t)
+  }
+
+  return tracks, nil
+}
+
+func _bytes_from_annot_tracks(tracks []AnnotTrack) []byte {
+  b := make([]byte, 0, 1024)
+  b = append(b, dlug.MarshalUint64(uint64(len(tracks)))...)
+  for _,t := range tracks {
+    b = append(b, dlug.MarshalUint64(uint64(len(t.Name)))...)
+    b = append(b, []byte(t.Name)...)
+    b = append(b, dlug.MarshalUint64(uint64(t.Type))...)
+    b = append(b, dlug.MarshalUint64(uint64(len(t.Step)))...)
+
+    prev := 0
+    for j:=0; j<len(t.Step); j++ {
+      b = append(b, dlug.MarshalUint64(uint64(t.Step[j]-prev))...)
+      b = append(b, dlug.MarshalUint64(uint64(len(t.Value[j])))...)
+      for _,x := range t.Value[j] {
+        if t.Type==CGF_ANNOT_FLOAT {
+          b = append(b, dlug.MarshalUint64(uint64(math.Float32bits(float32(x))))...)
+        } else {
+          b = append(b, dlug.MarshalUint64(uint64(x))...)
+        }
+      }
+      prev = t.Step[j]
+    }
+  }
+  return b
+}
+
+// Annotation tracks of a path, sorted by name (nil if it has none).
+//
+func HeaderIntermediatePathAnnot(hdri *HeaderIntermediate, path int) ([]AnnotTrack, error) {
+  blocks,e := _annot_path_blocks(hdri)
+  if e!=nil { return nil, e }
+  if path<0 || path>=len(blocks) || len(blocks[path])==0 { return nil, nil }
+  return _annot_tracks_from_bytes(blocks[path])
+}
+
+// Names of the annotation tracks in the file, sorted.
+//
+func HeaderIntermediateAnnotNames(hdri *HeaderIntermediate) ([]string, error) {
+  seen := make(map[string]bool)
+  names := []string{}
+  for path:=0; path<hdri.pathcount; path++ {
+    tracks,e := HeaderIntermediatePathAnnot(hdri, path)
+    if e!=nil { return nil, e }
+    for _,t := range tracks {
+      if seen[t.Name] { continue }
+      seen[t.Name] = true
+      names = append(names, t.Name)
+    }
+  }
+  sort.Strings(names)
+  return names, nil
+}
+
+// Replace the annotation of a path (nil to remove it).  Steps past the
+// end of the path are an error.
+//
+func HeaderIntermediateSetPathAnnot(hdri *HeaderIntermediate, path int, tracks []AnnotTrack) error {
+  blocks,e := _annot_path_blocks(hdri)
+  if e!=nil { return e }
+  if path<0 || path>=len(blocks) { return fmt.Errorf("path %x out of range", path) }
+
+  keep := make([]AnnotTrack, 0, len(tracks))
+  for _,t := range tracks {
+    if len(t.Step)==0 { continue }
+    if t.Step[len(t.Step)-1]>=hdri.StepPerPath[path] {
+      return fmt.Errorf("annotation %s: step %04x past the end of path %04x", t.Name, t.Step[len(t.Step)-1], path)
+    }
+    keep = append(keep, t)
+  }
+  sort.Slice(keep, func(i, j int) bool { return keep[i].Name < keep[j].Name })
+
+  blocks[path] = nil
+  if len(keep)>0 { blocks[path] = _bytes_from_annot_tracks(keep) }
+
+  npath := 0
+  for i:=0; i<len(blocks); i++ {
+    if len(blocks[i])>0 { npath = i+1 }
+  }
+
+  if npath==0 {
+    HeaderIntermediateSetExt(hdri, CGF_EXT_ANNOT, nil)
+    return nil
+  }
+
+  b := make([]byte, 0, 1024)
+  b = append(b, dlug.MarshalUint64(uint64(npath))...)
+  for i:=0; i<npath; i++ {
+    b = append(b, dlug.MarshalUint64(uint64(len(blocks[i])))...)
+    b = append(b, blocks[i]...)
+  }
+  HeaderIntermediateSetExt(hdri, CGF_EXT_ANNOT, b)
+  return nil
+}
+
+type _annot_key struct {
+  path int
+  name string
+}
+
+type _annot_line struct {
+  _annot_key
+  step int
+  value []float64
+}
+
+// Add the annotation in b (see above) to hdri, replacing values already
+// there at the same steps.  Tile positions in another tagset than the
+// file's are mapped with tmap (see HeaderIntermediateResolveTilepos).
+//
+func HeaderIntermediateImportAnnot(hdri *HeaderIntermediate, tmap *TagsetMap, b []byte) error {
+  lines := []_annot_line{}
+  is_float := make(map[_annot_key]bool)
+
+  scan := bufio.NewScanner(bytes.NewReader(b))
+  line_no := 0
+  for scan.Scan() {
+    l := strings.TrimSpace(scan.Text())
+    line_no++
+    if len(l)==0 || l[0]=='#' { continue }
+
+    fields := strings.Fields(l)
+    if len(fields)!=3 { return fmt.Errorf("invalid annotation line (line %d)", line_no) }
+
+    path,ver,step,e := ParseTilepos(fields[0])
+    if e!=nil { return fmt.Errorf("%v (line %d)", e, line_no) }
+    path,_,step,e = HeaderIntermediateResolveTilepos(hdri, tmap, path, ver, step)
+    if e!=nil { return fmt.Errorf("%v (line %d)", e, line_no) }
+    if path>=len(hdri.StepPerPath) || step>=hdri.StepPerPath[path] {
+      return fmt.Errorf("%s not in the CGF (line %d)", fields[0], line_no)
+    }
+
+    al := _annot_line{ _annot_key:_annot_key{ path:path, name:fields[1] }, step:step }
+    for _,s := range strings.Split(fields[2], ",") {
+      x,e := strconv.ParseFloat(s, 64)
+      if e!=nil { return fmt.Errorf("%v (line %d)", e, line_no) }
+      if x!=math.Floor(x) || x<0 { is_float[al._annot_key] = true }
+      al.value = append(al.value, x)
+    }
+    lines = append(lines, al)
+  }
+  if e:=scan.Err() ; e!=nil { return e }
+
+  tracks := make(map[int][]AnnotTrack)
+  for _,al := range lines {
+    if _,ok := tracks[al.path] ; !ok {
+      t,e := HeaderIntermediatePathAnnot(hdri, al.path)
+      if e!=nil { return e }
+      tracks[al.path] = t
+    }
+
+    t := AnnotTrackFind(tracks[al.path], al.name)
+    if t==nil {
+      typ := CGF_ANNOT_UINT
+      if is_float[al._annot_key] { typ = CGF_ANNOT_FLOAT }
+      tracks[al.path] = append(tracks[al.path], AnnotTrack{ Name:al.name, Type:typ })
+      t = &tracks[al.path][len(tracks[al.path])-1]
+    }
+
+    e := t.Set(al.step, al.value)
+    if e!=nil { return e }
+  }
+
+  for path,t := range tracks {
+    e := HeaderIntermediateSetPathAnnot(hdri, path, t)
+    if e!=nil { return e }
+  }
+  return nil
+}
@@ -0,0 +1,229 @@
+package cgf
+
+import "fmt"
+import "sort"
+import "strings"
+import "strconv"
+import "github.com/abeconnelly/autoio"
+
+// Length of the tags shared by adjacent tiles.
+//
+const TAG_LEN int = 24
+
+// A tile assembly maps tile steps to reference coordinates.  The file
+// is a list of blocks, one per path:
+//
+//   >hg19:chr13:0000
+//   0000.00.0000	     24111
+//   0000.00.0001	     24451
+//   ...
+//
+// where the header is build:chromosome:path (hex) and each line holds a
+// tile position and the (0 reference, exclusive) end of the tile on the
+// reference.  A tile starts TAG_LEN bases before the end of the previous
+// step.  The first step of a path starts at the end of the previous path
+// on the same chromosome, or at 0 if there is none.  All tile positions
+// have to be in the same tagset.
+//
+type TileAssembly struct {
+  Build string
+  Tagset int
+  PathChrom map[int]string
+  PathBeg map[int]int
+  PathEnd map[int][]int
+
+  // PathEnd with the steps missing from the file (-1) holding the end of
+  // the step before them (or the path's start), so it can be searched.
+  //
+  search_end map[int][]int
+}
+
+func LoadTileAssembly(fn string) (*TileAssembly, error) {
+  scan,e := autoio.OpenReadScanner(fn)
+  if e!=nil { return nil, e }
+  defer scan.Close()
+
+  asm := TileAssembly{}
+  asm.PathChrom = make(map[int]string)
+  asm.PathBeg = make(map[int]int)
+  asm.PathEnd = make(map[int][]int)
+
+  chrom_end := make(map[string]int)
+  cur_path := -1
+  tagset := -1
+  line_no := 0
+
+  for scan.ReadScan() {
+    l := strings.TrimSpace(scan.ReadText())
+    line_no++
+    if len(l)==0 { continue }
+
+    if l[0]=='>' {
+      if cur_path>=0 && len(asm.PathEnd[cur_path])>0 {
+        ends := asm.PathEnd[cur_path]
+        chrom_end[asm.PathChrom[cur_path]] = ends[len(ends)-1]
+      }
+
+      hdr := strings.Split(l[1:], ":")
+      if len(hdr)!=3 { return nil, fmt.Errorf("%s: invalid header '%s' (line %d)", fn, l, line_no) }
+
+      p,e := strconv.ParseInt(hdr[2], 16, 64)
+      if e!=nil { return nil, fmt.Errorf("%s: invalid path '%s' (line %d)", fn, hdr[2], line_no) }
+
+      asm.Build = hdr[0]
+      cur_path = int(p)
+      asm.PathChrom[cur_path] = hdr[1]
+      asm.PathBeg[cur_path] = chrom_end[hdr[1]]
+      continue
+    }
+
+    if cur_path<0 { return nil, fmt.Errorf("%s: tile before first header (line %d)", fn, line_no) }
+
+    fields := strings.Fields(l)
+    if len(fields)!=2 { return nil, fmt.Errorf("%s: invalid line (line %d)", fn, line_no) }
+
+    path,ver,step,e := ParseTilepos(fields[0])
+    if e!=nil { return nil, fmt.Errorf("%s: %v (line %d)", fn, e, line_no) }
+    if tagset>=0 && ver!=tagset { return nil, fmt.Errorf("%s: tagset %02x, earlier lines are in tagset %02x (line %d)", fn, ver, tagset, line_no) }
+    tagset = ver
+    if path!=cur_path { return nil, fmt.Errorf("%s: path %x in block for path %x (line %d)", fn, path, cur_path, line_no) }
+
+    end,e := strconv.Atoi(fields[1])
+    if e!=nil { return nil, fmt.Errorf("%s: %v (line %d)", fn, e, line_no) }
+
+    for len(asm.PathEnd[path]) < step { asm.PathEnd[path] = append(asm.PathEnd[path], -1) }
+    if len(asm.PathEnd[path])==step {
+      asm.PathEnd[path] = append(asm.PathEnd[path], end)
+    } else {
+      asm.PathEnd[path][step] = end
+    }
+  }
+
+  if tagset>=0 { asm.Tagset = tagset }
+
+  asm.search_end = make(map[int][]int)
+  for path,ends := range asm.PathEnd {
+    se := make([]int, len(ends))
+    prv := asm.PathBeg[path]
+    for s:=0; s<len(ends); s++ {
+      if ends[s]>=0 { prv = ends[s] }
+      se[s] = prv
+    }
+    asm.search_end[path] = se
+  }
+
+  return &asm, nil
+}
+
+// Reference interval [beg,end) covered by the tile anchored at `step`
+// spanning `span` steps, tags included.
+//
+func (asm *TileAssembly) TileRange(path, step, span int) (string, int, int, error) {
+  ends,ok := asm.PathEnd[path]
+  if !ok { return "", 0, 0, fmt.Errorf("path %x not in assembly", path) }
+  if span<1 { span = 1 }
+  if step<0 || (step+span)>len(ends) { return "", 0, 0, fmt.Errorf("step %x+%x not in assembly for path %x", step, span, path) }
+
+  beg := asm.PathBeg[path]
+  if step>0 {
+    if ends[step-1]<0 { return "", 0, 0, fmt.Errorf("step %x missing from assembly for path %x", step-1, path) }
+    beg = ends[step-1] - TAG_LEN
+  }
+
+  end := ends[step+span-1]
+  if end<0 { return "", 0, 0, fmt.Errorf("step %x missing from assembly for path %x", step+span-1, path) }
+
+  return asm.PathChrom[path], beg, end, nil
+}
+
+// Steps [Beg,End) of a tile path.
+//
+type TileposRange struct {
+  Path int
+  Beg int
+  End int
+}
+
+// Parse a region of the form chrom:start-end, with 1 based, inclusive
+// coordinates as usually written, into a 0 based, half open interval.
+// A bare chrom is the whole chromosome (end -1).
+//
+func ParseRegion(s string) (chrom string, start, end int, err error) {
+  p := strings.LastIndex(s, ":")
+  if p<0 {
+    if len(s)==0 { err = fmt.Errorf("empty region") }
+    return s, 0, -1, err
+  }
+
+  chrom = s[:p]
+  r := strings.Split(strings.Replace(s[p+1:], ",", "", -1), "-")
+  if len(chrom)==0 || len(r)!=2 {
+    err = fmt.Errorf("invalid region '%s' (expected chrom:start-end)", s)
+    return
+  }
+
+  start,err = strconv.Atoi(r[0])
+  if err!=nil { return }
+  end,err = strconv.Atoi(r[1])
+  if err!=nil { return }
+
+  if start<1 || end<start {
+    err = fmt.Errorf("invalid region '%s' (start must be >= 1 and <= end)", s)
+    return
+  }
+
+  start--
+  return
+}
+
+// Tile positions overlapping the reference interval [start,end) (0
+// based, end -1 for the rest of the chromosome), one range per path,
+// in path order.  Steps missing from the assembly are taken to end where
+// the step before them does.
+//
+func (asm *TileAssembly) TileposForRegion(chrom string, start, end int) ([]TileposRange, error) {
+  paths := make([]int, 0, 8)
+  for path,c := range asm.PathChrom {
+    if c==chrom { paths = append(paths, path) }
+  }
+  if len(paths)==0 { return nil, fmt.Errorf("chromosome %s not in assembly", chrom) }
+  sort.Ints(paths)
+
+  res := make([]TileposRange, 0, 2)
+  for i:=0; i<len(paths); i++ {
+    path := paths[i]
+    ends := asm.search_end[path]
+    if len(ends)==0 { continue }
+
+    // first tile ending after start
+    //
+    beg_step := sort.Search(len(ends), func(s int) bool { return ends[s]>start })
+    if beg_step==len(ends) { continue }
+
+    // first tile starting at or after end
+    //
+    end_step := len(ends)
+    if end>=0 {
+      end_step = sort.Search(len(ends), func(s int) bool {
+        tile_beg := asm.PathBeg[path]
+        if s>0 { tile_beg = ends[s-1]-TAG_LEN }
+        return tile_beg>=end
+      })
+    }
+
+    if beg_step<end_step {
+      res = append(res, TileposRange{ Path:path, Beg:beg_step, End:end_step })
+    }
+  }
+
+  if len(res)==0 { return nil, fmt.Errorf("no tiles in %s:%d-%d", chrom, start+1, end) }
+  return res, nil
+}
+
+// Reference interval [start,end) covered by steps [beg,end) of a path,
+// tags included.  The reverse of TileposForRegion.
+//
+func (asm *TileAssembly) RegionForTilepos(path, beg, end int) (string, int, int, error) {
+  if end<=beg { return "", 0, 0, fmt.Errorf("empty step range %x-%x", beg, end) }
+  return asm.TileRange(path, beg, end-beg)
+}
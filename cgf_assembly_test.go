@@ -0,0 +1,42 @@
+package cgf_test
+
+import "testing"
+import "fmt"
+import "strings"
+import "io/ioutil"
+import "path/filepath"
+
+import "github.com/abeconnelly/cgf"
+
+// Steps missing from the assembly don't throw off the search for the
+// steps of a region.
+//
+func TestTileposForRegionHoles(t *testing.T) {
+  lines := []string{ ">hg19:chr1:0000" }
+  for step:=0; step<200; step++ {
+    if step==99 || step==100 { continue }
+    lines = append(lines, fmt.Sprintf("0000.00.%04x\t%d", step, (step+1)*100))
+  }
+  fn := filepath.Join(t.TempDir(), "asm.txt")
+  e := ioutil.WriteFile(fn, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+  if e!=nil { t.Fatal(e) }
+
+  asm,e := cgf.LoadTileAssembly(fn)
+  if e!=nil { t.Fatal(e) }
+
+  tests := []struct {
+    start, end int
+    beg, stop int
+  }{
+    { 250, 420, 2, 5 },
+    { 19950, -1, 199, 200 },
+    { 9850, 10150, 98, 102 },
+  }
+  for _,tc := range tests {
+    r,e := asm.TileposForRegion("chr1", tc.start, tc.end)
+    if e!=nil { t.Fatal(e) }
+    if len(r)!=1 || r[0].Path!=0 || r[0].Beg!=tc.beg || r[0].End!=tc.stop {
+      t.Errorf("[%d,%d): %+v, want steps %04x-%04x", tc.start, tc.end, r, tc.beg, tc.stop)
+    }
+  }
+}
@@ -35,6 +35,23 @@ func FillNocSeq(seq string, delpos_len []int) string {
 
 }
 
+// NocallStartLen as stored (and returned by GetKnot) holds each start
+// relative to the previous start in the tile.  Return the same list with
+// starts relative to the beginning of the tile.
+//
+func NocallStartLenAbsolute(delpos_len []int) []int {
+  start_len := make([]int, len(delpos_len))
+
+  cur_pos:=0
+  for i:=0; i<len(delpos_len); i+=2 {
+    cur_pos += delpos_len[i]
+    start_len[i] = cur_pos
+    start_len[i+1] = delpos_len[i+1]
+  }
+
+  return start_len
+}
+
 //func parse_tilepos(s string) (path, ver, step int, err error) {
 func ParseTilepos(s string) (path, ver, step int, err error) {
   parts := strings.Split(s, ".")
@@ -5,7 +5,6 @@ import "fmt"
 //import "./dlug"
 import "github.com/abeconnelly/dlug"
 
-import "io/ioutil"
 
 import "github.com/abeconnelly/cglf"
 
@@ -98,12 +97,13 @@ func CreateCGFBytes(ctx *CGFContext) []byte {
     PathOffset[i] = uint64(len(path_bytes))
     p := cgf.Path[i]
 
-    // Name of this Path
+    // Name of this Path, its index in hex
     //
-    dn = dlug.FillSliceUint32(buf, uint32(len(p.Name)))
+    name := fmt.Sprintf("%04x", i)
+    dn = dlug.FillSliceUint32(buf, uint32(len(name)))
     path_bytes = append(path_bytes, buf[0:dn]...)
 
-    path_bytes = append(path_bytes, []byte(p.Name)...)
+    path_bytes = append(path_bytes, []byte(name)...)
 
     // The 'cache' Vector structure
     //
@@ -185,7 +185,7 @@ func CreateCGFBytes(ctx *CGFContext) []byte {
 
 //func write_cgf_bytes(cgf_bytes []byte, ofn string) error {
 func WriteCGFBytes(cgf_bytes []byte, ofn string) error {
-  err := ioutil.WriteFile(ofn, cgf_bytes, 0644)
+  err := StoreWriteFile(ofn, cgf_bytes)
   if err!=nil { return err }
   return nil
 }
@@ -210,6 +210,9 @@ func (ctx *CGFContext) UpdateVectorPathSimple(path_idx int, allele_path [][]Tile
   cgf := ctx.CGF
   sglf := ctx.SGLF
 
+  allele_path,e := _diploid_allele_path(allele_path)
+  if e!=nil { return e }
+
   //DEBUG
   //fmt.Printf("INTERMEDIATE\n")
   //emit_intermediate(ctx, path_idx, allele_path)
@@ -1017,7 +1020,6 @@ func (ctx *CGFContext) UpdateVectorPathSimple(path_idx int, allele_path [][]Tile
 
   }
 
-  ctx.CGF.Path[path_idx].Name = fmt.Sprintf("%04x", path_idx)
   ctx.CGF.Path[path_idx].Vector = packed_vec
   ctx.CGF.Path[path_idx].Overflow = overflow
   ctx.CGF.Path[path_idx].FinalOverflow = final_overflow
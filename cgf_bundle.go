@@ -0,0 +1,409 @@
+package cgf
+
+import "fmt"
+import "io"
+import "bytes"
+
+import "github.com/abeconnelly/dlug"
+
+// Multi-sample bundle.
+//
+// A bundle holds the CGFs of a cohort with one shared copy of the tile
+// map.  The path blocks of all samples for a path are stored next to each
+// other, so reading one step across the cohort reads one contiguous
+// region.  The layout is:
+//
+//   Magic        [8]byte             ("cgf.n"{)
+//   Version      dlug len, string
+//   LibVersion   dlug len, string
+//   TileMapLen   u64
+//   TileMap      [TileMapLen]byte
+//   SampleCount  u64
+//   PathCount    u64
+//   Sample       [SampleCount]{
+//                  Name         dlug len, string
+//                  Version      dlug len, string   (of the sample's CGF)
+//                  PathCount    u64                (with the CGF's header flags)
+//                  StepPerPath  [PathCount]u64
+//                  TrailerLen   u64
+//                  Trailer      [TrailerLen]byte   (extension records)
+//                }
+//   BlockOffset  [PathCount*SampleCount+1]u64
+//   Blocks
+//
+// The block of sample s for path p starts at BlockOffset[p*SampleCount+s]
+// from the start of Blocks.  Blocks are the path bytes as stored in the
+// sample's CGF (compressed or not) and the trailer is the sample's
+// extension records verbatim, so a sample's CGF comes back out of the
+// bundle byte for byte.
+//
+
+var CGF_BUNDLE_MAGIC []byte = []byte{ '"', 'c', 'g', 'f', '.', 'n', '"', '{' }
+
+const CGF_BUNDLE_VERSION string = "0.1.0"
+
+type BundleSample struct {
+  Name string
+  ver string
+  flags uint64
+
+  StepPerPath []int
+  PathBytes [][]byte
+
+  trailer []byte
+}
+
+type BundleIntermediate struct {
+  ver string
+  libver string
+  pathcount int
+
+  TileMap []TileMapEntry
+  TileMapBytes []byte
+
+  Samples []BundleSample
+}
+
+func BundleIntermediateSampleIndex(bi *BundleIntermediate, name string) int {
+  for i:=0; i<len(bi.Samples); i++ {
+    if bi.Samples[i].Name==name { return i }
+  }
+  return -1
+}
+
+// Header of the sample's CGF.  Path blocks and the tile map are shared
+// with the bundle, not copied.
+//
+func BundleIntermediateSampleHeader(bi *BundleIntermediate, sample int) (HeaderIntermediate, error) {
+  hdri := HeaderIntermediate{}
+  if sample<0 || sample>=len(bi.Samples) { return hdri, fmt.Errorf("no sample %d in bundle", sample) }
+  smp := &bi.Samples[sample]
+
+  copy(hdri.magic[:], CGF_MAGIC)
+  hdri.ver = smp.ver
+  hdri.flags = smp.flags
+  hdri.libver = bi.libver
+  hdri.pathcount = len(smp.StepPerPath)
+  hdri.TileMap = bi.TileMap
+  hdri.TileMapBytes = bi.TileMapBytes
+  hdri.StepPerPath = smp.StepPerPath
+  hdri.PathBytes = smp.PathBytes
+
+  hdri.path_offset = make([]int, hdri.pathcount+1)
+  for i:=0; i<hdri.pathcount; i++ {
+    hdri.path_offset[i+1] = hdri.path_offset[i] + len(smp.PathBytes[i])
+  }
+
+  if len(smp.trailer)>0 {
+    hdri.ext,hdri.ext_err = HeaderExtFromBytes(smp.trailer)
+  }
+
+  return hdri, nil
+}
+
+// The sample's CGF, as it was added.
+//
+func BundleIntermediateCGFBytes(bi *BundleIntermediate, sample int) ([]byte, error) {
+  hdri,e := BundleIntermediateSampleHeader(bi, sample)
+  if e!=nil { return nil, e }
+
+  b := BytesFromHeaderIntermediate(hdri)
+  for i:=0; i<len(hdri.PathBytes); i++ {
+    b = append(b, hdri.PathBytes[i]...)
+  }
+  b = append(b, bi.Samples[sample].trailer...)
+
+  return b, nil
+}
+
+// Add a sample from the bytes of its CGF.  The first sample sets the
+// bundle's tile map and library version, later ones have to match them.
+//
+func BundleIntermediateAddCGF(bi *BundleIntermediate, name string, cgf_bytes []byte) error {
+  if BundleIntermediateSampleIndex(bi, name)>=0 { return fmt.Errorf("%s: sample already in bundle", name) }
+  if len(cgf_bytes)<8 || !bytes.Equal(cgf_bytes[:8], CGF_MAGIC) { return fmt.Errorf("%s: not a CGF", name) }
+
+  hdri,dn := HeaderIntermediateFromBytes(cgf_bytes)
+  if dn<0 { return fmt.Errorf("%s: could not construct header from bytes", name) }
+  if dn+hdri.path_offset[hdri.pathcount] > len(cgf_bytes) { return fmt.Errorf("%s: truncated path blocks", name) }
+
+  if len(bi.Samples)==0 {
+    bi.ver = CGF_BUNDLE_VERSION
+    bi.libver = hdri.libver
+    bi.TileMap = hdri.TileMap
+    bi.TileMapBytes = hdri.TileMapBytes
+  } else {
+    if hdri.libver!=bi.libver { return fmt.Errorf("%s: library version %s, bundle has %s", name, hdri.libver, bi.libver) }
+    if !bytes.Equal(hdri.TileMapBytes, bi.TileMapBytes) { return fmt.Errorf("%s: tile map differs from the bundle's", name) }
+  }
+
+  smp := BundleSample{ Name:name, ver:hdri.ver, flags:hdri.flags, StepPerPath:hdri.StepPerPath, PathBytes:hdri.PathBytes,
+    trailer:cgf_bytes[dn+hdri.path_offset[hdri.pathcount]:] }
+  bi.Samples = append(bi.Samples, smp)
+
+  // Anything the bundle layout can't represent (gaps between path
+  // blocks, say) would come back out different.
+  //
+  b,e := BundleIntermediateCGFBytes(bi, len(bi.Samples)-1)
+  if e==nil && !bytes.Equal(b, cgf_bytes) { e = fmt.Errorf("%s: CGF layout can't be stored in a bundle", name) }
+  if e!=nil {
+    bi.Samples = bi.Samples[:len(bi.Samples)-1]
+    return e
+  }
+
+  if hdri.pathcount>bi.pathcount { bi.pathcount = hdri.pathcount }
+  return nil
+}
+
+func _bundle_block(smp *BundleSample, path int) []byte {
+  if path>=len(smp.PathBytes) { return nil }
+  return smp.PathBytes[path]
+}
+
+func BytesFromBundleIntermediate(bi BundleIntermediate) []byte {
+  buf := make([]byte, 8)
+  b := make([]byte, 0, 1024)
+
+  put_u64 := func(u int) {
+    tobyte64(buf, uint64(u))
+    b = append(b, buf[0:8]...)
+  }
+  put_str := func(s string) {
+    b = append(b, dlug.MarshalUint64(uint64(len(s)))...)
+    b = append(b, []byte(s)...)
+  }
+
+  b = append(b, CGF_BUNDLE_MAGIC...)
+  put_str(bi.ver)
+  put_str(bi.libver)
+  put_u64(len(bi.TileMapBytes))
+  b = append(b, bi.TileMapBytes...)
+
+  nsample := len(bi.Samples)
+  put_u64(nsample)
+  put_u64(bi.pathcount)
+
+  for s:=0; s<nsample; s++ {
+    smp := &bi.Samples[s]
+    put_str(smp.Name)
+    put_str(smp.ver)
+    put_u64(len(smp.StepPerPath) | int(smp.flags))
+    for i:=0; i<len(smp.StepPerPath); i++ { put_u64(smp.StepPerPath[i]) }
+    put_u64(len(smp.trailer))
+    b = append(b, smp.trailer...)
+  }
+
+  off := 0
+  for path:=0; path<bi.pathcount; path++ {
+    for s:=0; s<nsample; s++ {
+      put_u64(off)
+      off += len(_bundle_block(&bi.Samples[s], path))
+    }
+  }
+  put_u64(off)
+
+  for path:=0; path<bi.pathcount; path++ {
+    for s:=0; s<nsample; s++ {
+      b = append(b, _bundle_block(&bi.Samples[s], path)...)
+    }
+  }
+
+  return b
+}
+
+var _bundle_short error = fmt.Errorf("bundle header runs past the bytes read")
+
+// Bundle header (everything before the blocks) from b, the first len(b)
+// bytes of a bundle of size bytes.  Also returns the block offsets and
+// where the blocks start.  The error is _bundle_short if b ends before
+// the header does.
+//
+func _bundle_header_from_bytes(b []byte, size int) (BundleIntermediate, []int, int, error) {
+  bi := BundleIntermediate{}
+  bad := fmt.Errorf("bad bundle")
+  short := false
+
+  n:=0
+  if len(b)<8 || !bytes.Equal(b[:8], CGF_BUNDLE_MAGIC) { return bi, nil, 0, fmt.Errorf("not a CGF bundle") }
+  n+=8
+
+  get_u64 := func() (int, bool) {
+    if n+8>len(b) { short = true ; return 0, false }
+    u := byte2uint64(b[n:n+8])
+    n+=8
+    return int(u), true
+  }
+  get_str := func() (string, bool) {
+    if n>=len(b) { short = true ; return "", false }
+    l,dn := dlug.ConvertUint64(b[n:])
+    if dn<=0 || n+dn+int(l)>len(b) { short = true ; return "", false }
+    n+=dn
+    s := string(b[n:n+int(l)])
+    n+=int(l)
+    return s, true
+  }
+  get_bytes := func(l int) ([]byte, bool) {
+    if l<0 || l>size { return nil, false }
+    if n+l>len(b) { short = true ; return nil, false }
+    s := b[n:n+l]
+    n+=l
+    return s, true
+  }
+  fail := func() (BundleIntermediate, []int, int, error) {
+    if short && len(b)<size { return bi, nil, 0, _bundle_short }
+    return bi, nil, 0, bad
+  }
+
+  var ok bool
+  var l int
+
+  if bi.ver,ok = get_str() ; !ok { return fail() }
+  if bi.libver,ok = get_str() ; !ok { return fail() }
+  if l,ok = get_u64() ; !ok { return fail() }
+  if bi.TileMapBytes,ok = get_bytes(l) ; !ok { return fail() }
+  bi.TileMap = UnpackTileMap(bi.TileMapBytes)
+
+  nsample,ok := get_u64()
+  if !ok { return fail() }
+  if nsample<0 || nsample>size { return bi, nil, 0, bad }
+  if bi.pathcount,ok = get_u64() ; !ok { return fail() }
+  if bi.pathcount<0 || bi.pathcount>size { return bi, nil, 0, bad }
+
+  bi.Samples = make([]BundleSample, 0)
+  for s:=0; s<nsample; s++ {
+    smp := BundleSample{}
+    if smp.Name,ok = get_str() ; !ok { return fail() }
+    if smp.ver,ok = get_str() ; !ok { return fail() }
+
+    npath,ok := get_u64()
+    if !ok { return fail() }
+    smp.flags = uint64(npath) & CGF_HEADER_FLAG_MASK
+    npath = int(uint64(npath) &^ CGF_HEADER_FLAG_MASK)
+    if npath<0 || npath>bi.pathcount { return bi, nil, 0, bad }
+    smp.StepPerPath = make([]int, npath)
+    for i:=0; i<npath; i++ {
+      if smp.StepPerPath[i],ok = get_u64() ; !ok { return fail() }
+    }
+    smp.PathBytes = make([][]byte, npath)
+
+    if l,ok = get_u64() ; !ok { return fail() }
+    if smp.trailer,ok = get_bytes(l) ; !ok { return fail() }
+    bi.Samples = append(bi.Samples, smp)
+  }
+
+  // One offset per path block and one for the end, all before the end of
+  // the bundle.
+  //
+  if nsample>0 && bi.pathcount > ((size-n)/8-1)/nsample { return bi, nil, 0, bad }
+
+  block_offset := make([]int, bi.pathcount*nsample+1)
+  for i:=0; i<len(block_offset); i++ {
+    if block_offset[i],ok = get_u64() ; !ok { return fail() }
+    if block_offset[i]<0 || (i>0 && block_offset[i]<block_offset[i-1]) { return bi, nil, 0, bad }
+  }
+  if block_offset[len(block_offset)-1] > size-n { return bi, nil, 0, fmt.Errorf("truncated bundle") }
+
+  return bi, block_offset, n, nil
+}
+
+func BundleIntermediateFromBytes(b []byte) (BundleIntermediate, error) {
+  bi,block_offset,n,e := _bundle_header_from_bytes(b, len(b))
+  if e!=nil { return bi, e }
+
+  blocks := b[n:]
+  nsample := len(bi.Samples)
+  for path:=0; path<bi.pathcount; path++ {
+    for s:=0; s<nsample; s++ {
+      k := path*nsample+s
+      st,en := block_offset[k], block_offset[k+1]
+      if path>=len(bi.Samples[s].PathBytes) {
+        if en>st { return bi, fmt.Errorf("bad bundle") }
+        continue
+      }
+      bi.Samples[s].PathBytes[path] = blocks[st:en]
+    }
+  }
+
+  return bi, nil
+}
+
+// Bundle header read without the path blocks (see HeaderRef).  The blocks
+// of a path are contiguous across the cohort, so a path is read for every
+// sample with one read.
+//
+type BundleRef struct {
+  Bundle BundleIntermediate
+  BlockBase int64
+  Size int64
+
+  block_offset []int
+}
+
+func ReadBundleRef(r io.ReaderAt, size int64) (*BundleRef, error) {
+
+  // Read more of the start of the file until the header parses.
+  //
+  for n:=_header_ref_read_size; ; n*=2 {
+    if n>size { n = size }
+    b,e := _read_at(r, 0, n)
+    if e!=nil { return nil, e }
+
+    bi,block_offset,dn,e := _bundle_header_from_bytes(b, int(size))
+    if e==_bundle_short { continue }
+    if e!=nil { return nil, e }
+
+    return &BundleRef{ Bundle:bi, BlockBase:int64(dn), Size:size, block_offset:block_offset }, nil
+  }
+}
+
+// Stored bytes of the path's block for every sample (nil for samples
+// without the path).
+//
+func (bref *BundleRef) ReadPathBlocks(r io.ReaderAt, path int) ([][]byte, error) {
+  bi := &bref.Bundle
+  if path<0 || path>=bi.pathcount { return nil, fmt.Errorf("path %x out of range", path) }
+
+  nsample := len(bi.Samples)
+  blocks := make([][]byte, nsample)
+  if nsample==0 { return blocks, nil }
+
+  beg,end := bref.block_offset[path*nsample], bref.block_offset[(path+1)*nsample]
+  b := []byte{}
+  if end>beg {
+    var e error
+    b,e = _read_at(r, bref.BlockBase+int64(beg), int64(end-beg))
+    if e!=nil { return nil, e }
+  }
+
+  for s:=0; s<nsample; s++ {
+    st,en := bref.block_offset[path*nsample+s]-beg, bref.block_offset[path*nsample+s+1]-beg
+    if path>=len(bi.Samples[s].StepPerPath) {
+      if en>st { return nil, fmt.Errorf("bad bundle") }
+      continue
+    }
+    blocks[s] = b[st:en]
+  }
+
+  return blocks, nil
+}
+
+// Header of every sample (see BundleIntermediateSampleHeader) holding
+// only the path's block, read with ReadPathBlocks.  The BundleRef isn't
+// modified.
+//
+func (bref *BundleRef) ReadPathHeaders(r io.ReaderAt, path int) ([]HeaderIntermediate, error) {
+  blocks,e := bref.ReadPathBlocks(r, path)
+  if e!=nil { return nil, e }
+
+  hdris := make([]HeaderIntermediate, len(blocks))
+  for s:=0; s<len(blocks); s++ {
+    hdris[s],e = BundleIntermediateSampleHeader(&bref.Bundle, s)
+    if e!=nil { return nil, e }
+    if path>=hdris[s].pathcount { continue }
+
+    hdris[s].PathBytes = make([][]byte, hdris[s].pathcount)
+    _header_intermediate_set_path_bytes(&hdris[s], path, blocks[s])
+  }
+
+  return hdris, nil
+}
@@ -0,0 +1,100 @@
+package cgf_test
+
+import "testing"
+import "bytes"
+import "encoding/binary"
+
+import "github.com/abeconnelly/cgf"
+import "github.com/abeconnelly/cgf/synth"
+
+func _bundle_fixture(tb testing.TB, nsample int) (*synth.Result, [][]byte, []byte) {
+  p := synth.DefaultParams()
+  p.Seed = 2
+  p.Paths = 3
+  p.Steps = 200
+  p.Samples = nsample
+  res,cgf_bytes := _synth_fixture(tb, p)
+
+  bi := cgf.BundleIntermediate{}
+  for i,b := range cgf_bytes {
+    e := cgf.BundleIntermediateAddCGF(&bi, res.Samples[i], b)
+    if e!=nil { tb.Fatal(e) }
+  }
+  return res, cgf_bytes, cgf.BytesFromBundleIntermediate(bi)
+}
+
+type _counting_reader struct {
+  r *bytes.Reader
+  reads int
+}
+
+func (cr *_counting_reader) ReadAt(p []byte, off int64) (int, error) {
+  cr.reads++
+  return cr.r.ReadAt(p, off)
+}
+
+// Paths read from a bundle with one read each decode to the knots of the
+// samples' own CGFs.
+//
+func TestBundleRefReadPath(t *testing.T) {
+  res,cgf_bytes,bundle_bytes := _bundle_fixture(t, 3)
+
+  cr := &_counting_reader{ r:bytes.NewReader(bundle_bytes) }
+  bref,e := cgf.ReadBundleRef(cr, int64(len(bundle_bytes)))
+  if e!=nil { t.Fatal(e) }
+  if len(bref.Bundle.Samples)!=len(res.Samples) { t.Fatalf("%d samples, want %d", len(bref.Bundle.Samples), len(res.Samples)) }
+
+  for path:=0; path<3; path++ {
+    n := cr.reads
+    hdris,e := bref.ReadPathHeaders(cr, path)
+    if e!=nil { t.Fatal(e) }
+    if cr.reads-n!=1 { t.Errorf("path %d: %d reads, want 1", path, cr.reads-n) }
+
+    for s:=0; s<len(hdris); s++ {
+      got,e := cgf.HeaderIntermediateLoadPath(&hdris[s], path)
+      if e!=nil { t.Fatalf("%s path %d: %v", res.Samples[s], path, e) }
+
+      hdri := _synth_header(t, cgf_bytes[s])
+      want,e := cgf.HeaderIntermediateLoadPath(&hdri, path)
+      if e!=nil { t.Fatal(e) }
+
+      for step:=0; step<hdri.StepPerPath[path]; step++ {
+        kw := cgf.GetKnot(hdri.TileMap, want, step)
+        kg := cgf.GetKnot(hdris[s].TileMap, got, step)
+        if len(kw)!=len(kg) { t.Fatalf("%s path %d step %04x: %d alleles, want %d", res.Samples[s], path, step, len(kg), len(kw)) }
+        for a:=0; a<len(kw); a++ {
+          if len(kw[a])!=len(kg[a]) { t.Fatalf("%s path %d step %04x: allele %d differs", res.Samples[s], path, step, a) }
+          for i:=0; i<len(kw[a]); i++ {
+            if kw[a][i].Step!=kg[a][i].Step || kw[a][i].VarId!=kg[a][i].VarId || kw[a][i].Span!=kg[a][i].Span {
+              t.Fatalf("%s path %d step %04x: allele %d differs", res.Samples[s], path, step, a)
+            }
+          }
+        }
+      }
+    }
+  }
+
+  if _,e = bref.ReadPathBlocks(cr, 3) ; e==nil { t.Error("no error reading a path past the bundle's") }
+}
+
+// Counts in the header that don't fit in the file are errors, not
+// allocations.
+//
+func TestBundleBadCounts(t *testing.T) {
+  res,_,bundle_bytes := _bundle_fixture(t, 1)
+
+  // Sample and path counts are the two words before the first sample's
+  // name.
+  //
+  k := bytes.Index(bundle_bytes, append([]byte{ byte(len(res.Samples[0])) }, []byte(res.Samples[0])...))
+  if k<16 { t.Fatal("sample record not found") }
+
+  b := append([]byte{}, bundle_bytes...)
+  binary.LittleEndian.PutUint64(b[k-8:], 1<<40)
+  if _,e := cgf.BundleIntermediateFromBytes(b) ; e==nil { t.Error("no error for a path count past the end of the bundle") }
+  if _,e := cgf.ReadBundleRef(bytes.NewReader(b), int64(len(b))) ; e==nil { t.Error("ReadBundleRef: no error for a path count past the end of the bundle") }
+
+  for _,n := range []int{ 8, 40, len(bundle_bytes)/2, len(bundle_bytes)-1 } {
+    if _,e := cgf.BundleIntermediateFromBytes(bundle_bytes[:n]) ; e==nil { t.Errorf("no error for a bundle cut at %d bytes", n) }
+  }
+}
@@ -0,0 +1,282 @@
+package cgf
+
+import "fmt"
+import "sort"
+import "bufio"
+import "bytes"
+import "strings"
+import "strconv"
+
+import "github.com/abeconnelly/dlug"
+import "github.com/abeconnelly/cglf"
+
+// Cohort canonical variants extension record (CGF_EXT_CANON):
+//
+//   PathCount dlug
+//   { Count dlug, { StepDelta dlug, VarId dlug } [Count] } [PathCount]
+//
+// A step is stored as canonical (clear Vector bit, nothing else) when
+// both alleles are variant 0.  Where the library's variant 0 isn't the
+// common allele of a cohort most steps spill into hexits and overflow.
+// A path's canon table names, for some of its steps, the library variant
+// to store as variant 0 instead: stored ids 0 and VarId are swapped at
+// those steps by the encoder, and swapped back by the decoders (GetKnot,
+// PathKnotScan and everything built on them), so callers only ever see
+// library ids.  Only single step variants are swapped.
+//
+// Steps are in increasing order, StepDelta from the previous step of the
+// path (from 0 for the first).
+//
+// The text form of a table (canon-table writes it, append and
+// canon-apply read it) has one step per line, hex fields:
+//
+//   <path>.<ver>.<step>  <varid>
+//
+// with ver the tagset version the path is encoded against (see
+// cgf_tagset.go).
+//
+
+const CGF_EXT_CANON int = 6
+
+// CanonTable[path][step] is the library variant stored as variant 0.
+//
+type CanonTable map[int]map[int]int
+
+func _canon_swap(canon map[int]int, step, varid int) int {
+  v,ok := canon[step]
+  if !ok { return varid }
+  if varid==0 { return v }
+  if varid==v { return 0 }
+  return varid
+}
+
+// Stored to library ids of a decoded knot, in place.
+//
+func _knot_canon(pathi *PathIntermediate, knot [][]TileInfo) [][]TileInfo {
+  if len(pathi.canon)==0 { return knot }
+  for allele:=0; allele<len(knot); allele++ {
+    for i:=0; i<len(knot[allele]); i++ {
+      knot[allele][i].VarId = _canon_swap(pathi.canon, knot[allele][i].Step, knot[allele][i].VarId)
+    }
+  }
+  return knot
+}
+
+// Library to stored ids of a knot about to be encoded.
+//
+func _knot_canon_encode(canon map[int]int, knot *CGFIntermediate) {
+  if len(canon)==0 { return }
+  for allele:=0; allele<len(knot.varid); allele++ {
+    for i:=0; i<len(knot.varid[allele]); i++ {
+      knot.varid[allele][i] = _canon_swap(canon, knot.step[allele][i], knot.varid[allele][i])
+    }
+  }
+}
+
+func _canon_equal(a, b map[int]int) bool {
+  if len(a)!=len(b) { return false }
+  for k,v := range a {
+    if w,ok := b[k] ; !ok || w!=v { return false }
+  }
+  return true
+}
+
+func _header_canon(hdri *HeaderIntermediate) []map[int]int {
+  canon := make([]map[int]int, hdri.pathcount)
+
+  b,ok := HeaderIntermediateGetExt(hdri, CGF_EXT_CANON)
+  if !ok { return canon }
+
+  n:=0
+  npath,dn := dlug.ConvertUint64(b[n:])
+  n+=dn
+  for i:=0; i<int(npath) && n<len(b); i++ {
+    count,dn := dlug.ConvertUint64(b[n:])
+    n+=dn
+
+    m := make(map[int]int)
+    step := 0
+    for j:=0; j<int(count) && n<len(b); j++ {
+      d,dn := dlug.ConvertUint64(b[n:])
+      n+=dn
+      v,dn := dlug.ConvertUint64(b[n:])
+      n+=dn
+      step += int(d)
+      m[step] = int(v)
+    }
+    if i<len(canon) && len(m)>0 { canon[i] = m }
+  }
+  return canon
+}
+
+// Canon table of a path, nil if it has none.
+//
+func HeaderIntermediatePathCanon(hdri *HeaderIntermediate, path int) map[int]int {
+  canon := _header_canon(hdri)
+  if path<0 || path>=len(canon) { return nil }
+  return canon[path]
+}
+
+// Record the canon table of a path (nil to remove it).  The path bytes
+// have to be encoded with the same table.
+//
+func HeaderIntermediateSetPathCanon(hdri *HeaderIntermediate, path int, m map[int]int) error {
+  canon := _header_canon(hdri)
+  if path<0 || path>=len(canon) { return fmt.Errorf("path %x out of range", path) }
+  canon[path] = m
+
+  any := false
+  for i:=0; i<len(canon); i++ {
+    if len(canon[i])>0 { any = true ; break }
+  }
+
+  if !any {
+    HeaderIntermediateSetExt(hdri, CGF_EXT_CANON, nil)
+    return nil
+  }
+
+  b := make([]byte, 0, 1024)
+  b = append(b, dlug.MarshalUint64(uint64(len(canon)))...)
+  for i:=0; i<len(canon); i++ {
+    steps := make([]int, 0, len(canon[i]))
+    for step := range canon[i] { steps = append(steps, step) }
+    sort.Ints(steps)
+
+    b = append(b, dlug.MarshalUint64(uint64(len(steps)))...)
+    prev := 0
+    for _,step := range steps {
+      b = append(b, dlug.MarshalUint64(uint64(step-prev))...)
+      b = append(b, dlug.MarshalUint64(uint64(canon[i][step]))...)
+      prev = step
+    }
+  }
+  HeaderIntermediateSetExt(hdri, CGF_EXT_CANON, b)
+  return nil
+}
+
+// Swaps have to be between variant 0 and another variant of the step,
+// both single step tiles in the library.
+//
+func (ct CanonTable) Check(sglf *cglf.SGLF) error {
+  for path,m := range ct {
+    for step,v := range m {
+      steps,ok := sglf.LibInfo[path]
+      if !ok || step<0 || step>=len(steps) { return fmt.Errorf("canon table: step %04x.%04x not in library", path, step) }
+      if v<=0 || v>=len(steps[step]) { return fmt.Errorf("canon table: variant %04x.%04x.%03x not in library", path, step, v) }
+      if steps[step][0].Span!=1 || steps[step][v].Span!=1 {
+        return fmt.Errorf("canon table: %04x.%04x.%03x, only single step variants can be canonical", path, step, v)
+      }
+    }
+  }
+  return nil
+}
+
+// Canon table of the paths in tagsets from its text form (lines of other
+// paths are skipped).  The tagset field of a line has to be the tagset
+// version of the path the table is applied to, tagsets[path].
+//
+func CanonTableFromBytes(b []byte, tagsets map[int]int) (CanonTable, error) {
+  ct := CanonTable{}
+
+  scan := bufio.NewScanner(bytes.NewReader(b))
+  line_no := 0
+  for scan.Scan() {
+    l := strings.TrimSpace(scan.Text())
+    line_no++
+    if len(l)==0 || l[0]=='#' { continue }
+
+    fields := strings.Fields(l)
+    if len(fields)!=2 { return nil, fmt.Errorf("invalid canon table line (line %d)", line_no) }
+
+    path,ver,step,e := ParseTilepos(fields[0])
+    if e!=nil { return nil, fmt.Errorf("%v (line %d)", e, line_no) }
+    file_ver,ok := tagsets[path]
+    if !ok { continue }
+    if ver!=file_ver {
+      return nil, fmt.Errorf("%s is in tagset %02x, path %04x is in tagset %02x (line %d)", fields[0], ver, path, file_ver, line_no)
+    }
+    v,e := strconv.ParseInt(fields[1], 16, 64)
+    if e!=nil { return nil, fmt.Errorf("%v (line %d)", e, line_no) }
+    if v==0 { continue }
+
+    if ct[path]==nil { ct[path] = make(map[int]int) }
+    ct[path][step] = int(v)
+  }
+  if e:=scan.Err() ; e!=nil { return nil, e }
+
+  return ct, nil
+}
+
+func LoadCanonTable(name string, tagsets map[int]int) (CanonTable, error) {
+  b,e := StoreReadFile(name)
+  if e!=nil { return nil, e }
+  ct,e := CanonTableFromBytes(b, tagsets)
+  if e!=nil { return nil, fmt.Errorf("%s: %v", name, e) }
+  return ct, nil
+}
+
+// Text form of the table, with tile positions in tagsets[path] (0 if the
+// path isn't in tagsets).
+//
+func BytesFromCanonTable(ct CanonTable, tagsets map[int]int) []byte {
+  paths := make([]int, 0, len(ct))
+  for path := range ct { paths = append(paths, path) }
+  sort.Ints(paths)
+
+  var b bytes.Buffer
+  for _,path := range paths {
+    steps := make([]int, 0, len(ct[path]))
+    for step := range ct[path] { steps = append(steps, step) }
+    sort.Ints(steps)
+    for _,step := range steps {
+      fmt.Fprintf(&b, "%04x.%02x.%04x\t%x\n", path, tagsets[path], step, ct[path][step])
+    }
+  }
+  return b.Bytes()
+}
+
+// Steps where a single step variant other than 0 is carried by more
+// alleles than variant 0 (and by at least min_frac of the alleles with a
+// tile anchored there), mapped to the most common such variant.  Loq and
+// masked alleles are left out of the counts, and steps where sglf doesn't
+// allow the swap (see Check) out of the table.
+//
+func CanonTableFromFreq(tf TileFreq, sglf *cglf.SGLF, min_frac float64) CanonTable {
+  type step_best struct { varid, count, count0, total int }
+  best := make(map[[2]int]*step_best)
+
+  for key,c := range tf {
+    k := [2]int{ key.Path, key.Step }
+    sb,ok := best[k]
+    if !ok {
+      sb = &step_best{ varid:-1 }
+      best[k] = sb
+    }
+
+    n := c.Count - c.Loq - c.Masked
+    sb.total += n
+    if key.Span!=1 || key.VarId==KNOT_MASK_VARID { continue }
+    if key.VarId==0 { sb.count0 = n ; continue }
+    if n>sb.count || (n==sb.count && key.VarId<sb.varid) { sb.varid,sb.count = key.VarId,n }
+  }
+
+  ct := CanonTable{}
+  for k,sb := range best {
+    if sb.varid<=0 || sb.count<=sb.count0 || sb.total==0 { continue }
+    if float64(sb.count) < min_frac*float64(sb.total) { continue }
+    if (CanonTable{ k[0]:{ k[1]:sb.varid } }).Check(sglf)!=nil { continue }
+    if ct[k[0]]==nil { ct[k[0]] = make(map[int]int) }
+    ct[k[0]][k[1]] = sb.varid
+  }
+  return ct
+}
+
+// Re-encode every path of hdri with its table from ct (paths without one
+// go back to the library's variant 0).
+//
+func HeaderIntermediateApplyCanon(hdri *HeaderIntermediate, sglf *cglf.SGLF, ct CanonTable) error {
+  e := ct.Check(sglf)
+  if e!=nil { return e }
+  _,e = _header_reencode(hdri, nil, sglf, ct)
+  return e
+}
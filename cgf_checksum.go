@@ -0,0 +1,242 @@
+package cgf
+
+import "fmt"
+import "bytes"
+import "hash/crc32"
+import "crypto/sha256"
+
+import "github.com/abeconnelly/dlug"
+
+// Checksum extension record (CGF_EXT_CHECKSUM):
+//
+//   Algorithm    dlug
+//   PathCount    dlug
+//   HeaderDigest [digest size]byte
+//   PathDigest   [PathCount][digest size]byte
+//
+// Path digests are taken over the stored PathBytes block.  The header
+// digest is taken over the header bytes (everything before the first
+// path block), all other extension records and the path digests.
+//
+// The extension trailer follows the path blocks, so a file cut off at the
+// end of its path data would otherwise look like one written without a
+// checksum table.  Turning the table on sets CGF_HEADER_FLAG_CHECKSUM in
+// the header and the record is required from then on.
+//
+
+const CGF_CHECKSUM_NONE int = 0
+const CGF_CHECKSUM_CRC32C int = 1
+const CGF_CHECKSUM_SHA256 int = 2
+
+var crc32c_table *crc32.Table = crc32.MakeTable(crc32.Castagnoli)
+
+func ChecksumAlgorithm(name string) (int, error) {
+  if name=="" || name=="none" { return CGF_CHECKSUM_NONE, nil }
+  if name=="crc32c" { return CGF_CHECKSUM_CRC32C, nil }
+  if name=="sha256" { return CGF_CHECKSUM_SHA256, nil }
+  return -1, fmt.Errorf("unknown checksum algorithm '%s' (expected none, crc32c or sha256)", name)
+}
+
+func ChecksumAlgorithmName(algo int) string {
+  if algo==CGF_CHECKSUM_CRC32C { return "crc32c" }
+  if algo==CGF_CHECKSUM_SHA256 { return "sha256" }
+  return "none"
+}
+
+func _checksum_size(algo int) int {
+  if algo==CGF_CHECKSUM_CRC32C { return 4 }
+  if algo==CGF_CHECKSUM_SHA256 { return sha256.Size }
+  return 0
+}
+
+func _checksum(algo int, b []byte) []byte {
+  if algo==CGF_CHECKSUM_CRC32C {
+    buf := make([]byte, 4)
+    tobyte32(buf, crc32.Checksum(b, crc32c_table))
+    return buf
+  }
+  if algo==CGF_CHECKSUM_SHA256 {
+    s := sha256.Sum256(b)
+    return s[:]
+  }
+  return nil
+}
+
+type checksum_record struct {
+  algo int
+  header_digest []byte
+  path_digest [][]byte
+}
+
+func checksum_record_from_bytes(b []byte) (checksum_record, error) {
+  rec := checksum_record{}
+  n:=0
+
+  algo,dn := dlug.ConvertUint64(b[n:])
+  if dn<=0 { return rec, fmt.Errorf("bad checksum record") }
+  n+=dn
+  rec.algo = int(algo)
+
+  sz := _checksum_size(rec.algo)
+  if sz==0 { return rec, fmt.Errorf("unknown checksum algorithm %d", rec.algo) }
+
+  npath,dn := dlug.ConvertUint64(b[n:])
+  if dn<=0 { return rec, fmt.Errorf("bad checksum record") }
+  n+=dn
+
+  if len(b) != n + sz*(int(npath)+1) {
+    return rec, fmt.Errorf("checksum record length mismatch")
+  }
+
+  rec.header_digest = b[n:n+sz]
+  n+=sz
+
+  for i:=0; i<int(npath); i++ {
+    rec.path_digest = append(rec.path_digest, b[n:n+sz])
+    n+=sz
+  }
+
+  return rec, nil
+}
+
+func bytes_from_checksum_record(rec checksum_record) []byte {
+  b := make([]byte, 0, 64)
+  b = append(b, dlug.MarshalUint64(uint64(rec.algo))...)
+  b = append(b, dlug.MarshalUint64(uint64(len(rec.path_digest)))...)
+  b = append(b, rec.header_digest...)
+  for i:=0; i<len(rec.path_digest); i++ {
+    b = append(b, rec.path_digest[i]...)
+  }
+  return b
+}
+
+func _header_digest(hdri *HeaderIntermediate, rec checksum_record) []byte {
+  b := BytesFromHeaderIntermediate(*hdri)
+  b = append(b, _header_ext_bytes_except(hdri, CGF_EXT_CHECKSUM)...)
+  for i:=0; i<len(rec.path_digest); i++ {
+    b = append(b, rec.path_digest[i]...)
+  }
+  return _checksum(rec.algo, b)
+}
+
+// Turn on (or off, with CGF_CHECKSUM_NONE) the checksum table.  The
+// digests themselves are filled in by HeaderIntermediateUpdateChecksum
+// when the file is written.
+//
+func HeaderIntermediateSetChecksum(hdri *HeaderIntermediate, algo int) error {
+  if algo==CGF_CHECKSUM_NONE {
+    HeaderIntermediateSetExt(hdri, CGF_EXT_CHECKSUM, nil)
+    hdri.flags &^= CGF_HEADER_FLAG_CHECKSUM
+    return nil
+  }
+  if _checksum_size(algo)==0 { return fmt.Errorf("unknown checksum algorithm %d", algo) }
+
+  hdri.flags |= CGF_HEADER_FLAG_CHECKSUM
+
+  rec := checksum_record{ algo:algo }
+  HeaderIntermediateSetExt(hdri, CGF_EXT_CHECKSUM, bytes_from_checksum_record(rec))
+  return HeaderIntermediateUpdateChecksum(hdri)
+}
+
+// The checksum record, or an error if the header says there should be one
+// and there isn't.  ok is false for a file written without checksums.
+//
+func _header_checksum_record(hdri *HeaderIntermediate) (rec checksum_record, ok bool, e error) {
+  b,ok := HeaderIntermediateGetExt(hdri, CGF_EXT_CHECKSUM)
+  if !ok {
+    if (hdri.flags & CGF_HEADER_FLAG_CHECKSUM)!=0 { return rec, false, fmt.Errorf("checksum table missing (file truncated?)") }
+    return rec, false, nil
+  }
+
+  rec,e = checksum_record_from_bytes(b)
+  return rec, true, e
+}
+
+// CGF_CHECKSUM_NONE for a file without a checksum table, an error if the
+// table is corrupt or missing when the header says there is one.
+//
+func HeaderIntermediateChecksumAlgorithm(hdri *HeaderIntermediate) (int, error) {
+  rec,ok,e := _header_checksum_record(hdri)
+  if e!=nil { return CGF_CHECKSUM_NONE, e }
+  if !ok { return CGF_CHECKSUM_NONE, nil }
+  return rec.algo, nil
+}
+
+// Recompute the path and header digests if the checksum table is present.
+//
+func HeaderIntermediateUpdateChecksum(hdri *HeaderIntermediate) error {
+  b,ok := HeaderIntermediateGetExt(hdri, CGF_EXT_CHECKSUM)
+  if !ok { return nil }
+
+  algo,dn := dlug.ConvertUint64(b)
+  if dn<=0 { return fmt.Errorf("bad checksum record") }
+
+  rec := checksum_record{ algo:int(algo) }
+  if _checksum_size(rec.algo)==0 { return fmt.Errorf("unknown checksum algorithm %d", rec.algo) }
+
+  for i:=0; i<len(hdri.PathBytes); i++ {
+    rec.path_digest = append(rec.path_digest, _checksum(rec.algo, hdri.PathBytes[i]))
+  }
+
+  // Set a placeholder so the record ordering is fixed before the header
+  // digest is taken.
+  //
+  rec.header_digest = make([]byte, _checksum_size(rec.algo))
+  HeaderIntermediateSetExt(hdri, CGF_EXT_CHECKSUM, bytes_from_checksum_record(rec))
+
+  rec.header_digest = _header_digest(hdri, rec)
+  HeaderIntermediateSetExt(hdri, CGF_EXT_CHECKSUM, bytes_from_checksum_record(rec))
+
+  return nil
+}
+
+// Check the extension trailer, that no path data is missing and the
+// header digest.  Without a checksum table (and none expected, see
+// CGF_HEADER_FLAG_CHECKSUM) only the first two are checked.
+//
+func HeaderIntermediateVerifyHeader(hdri *HeaderIntermediate) error {
+  if hdri.ext_err!=nil { return hdri.ext_err }
+
+  nbyte := 0
+  for i:=0; i<len(hdri.PathBytes); i++ { nbyte += len(hdri.PathBytes[i]) }
+  if nbyte < hdri.path_offset[hdri.pathcount] {
+    return fmt.Errorf("file truncated (%d of %d path bytes present)", nbyte, hdri.path_offset[hdri.pathcount])
+  }
+
+  rec,ok,e := _header_checksum_record(hdri)
+  if e!=nil { return e }
+  if !ok { return nil }
+
+  if len(rec.path_digest) != hdri.pathcount {
+    return fmt.Errorf("checksum table has %d paths, header has %d", len(rec.path_digest), hdri.pathcount)
+  }
+
+  if !bytes.Equal(rec.header_digest, _header_digest(hdri, rec)) {
+    return fmt.Errorf("header %s mismatch", ChecksumAlgorithmName(rec.algo))
+  }
+
+  return nil
+}
+
+// Check that the path block is complete and, if there is a checksum table,
+// that it matches the stored digest.  The path is not decoded.
+//
+func HeaderIntermediateVerifyPath(hdri *HeaderIntermediate, path int) error {
+  if path<0 || path>=hdri.pathcount { return fmt.Errorf("path %x out of range", path) }
+
+  sz := hdri.path_offset[path+1] - hdri.path_offset[path]
+  if len(hdri.PathBytes[path]) != sz {
+    return fmt.Errorf("path %x truncated (%d of %d bytes)", path, len(hdri.PathBytes[path]), sz)
+  }
+
+  rec,ok,e := _header_checksum_record(hdri)
+  if e!=nil { return fmt.Errorf("path %x: %v", path, e) }
+  if !ok { return nil }
+  if path>=len(rec.path_digest) { return fmt.Errorf("path %x missing from checksum table", path) }
+
+  if !bytes.Equal(rec.path_digest[path], _checksum(rec.algo, hdri.PathBytes[path])) {
+    return fmt.Errorf("path %x %s mismatch", path, ChecksumAlgorithmName(rec.algo))
+  }
+
+  return nil
+}
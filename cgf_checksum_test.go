@@ -0,0 +1,68 @@
+package cgf_test
+
+import "testing"
+
+import "github.com/abeconnelly/cgf"
+import "github.com/abeconnelly/cgf/synth"
+
+// Length of the header and path blocks, the file up to its extension
+// trailer.
+//
+func _path_data_end(tb testing.TB, b []byte) int {
+  hdri,dn := cgf.HeaderIntermediateFromBytes(b)
+  if dn<0 { tb.Fatal("could not construct header from bytes") }
+  n := dn
+  for i:=0; i<len(hdri.StepPerPath); i++ { n += len(hdri.PathBytes[i]) }
+  return n
+}
+
+// A checksummed file cut off at the end of its path data fails
+// verification instead of reading as a file without checksums.
+//
+func TestChecksumTruncatedTrailer(t *testing.T) {
+  p := synth.DefaultParams()
+  p.Seed = 2
+  p.Paths = 2
+  p.Steps = 200
+  p.Samples = 1
+  _,cgf_bytes := _synth_fixture(t, p)
+
+  hdri := _synth_header(t, cgf_bytes[0])
+  e := cgf.HeaderIntermediateSetChecksum(&hdri, cgf.CGF_CHECKSUM_CRC32C)
+  if e!=nil { t.Fatal(e) }
+  b,e := cgf.CGFBytesFromIntermediate(&hdri)
+  if e!=nil { t.Fatal(e) }
+
+  // The header flag leaves the version and the rest of the header as
+  // they were.
+  //
+  full := _synth_header(t, b)
+  if e = cgf.HeaderIntermediateCmp(_synth_header(t, cgf_bytes[0]), full) ; e!=nil { t.Errorf("header changed: %v", e) }
+  if algo,e := cgf.HeaderIntermediateChecksumAlgorithm(&full) ; e!=nil || algo!=cgf.CGF_CHECKSUM_CRC32C { t.Errorf("checksum algorithm %d (%v), want %d", algo, e, cgf.CGF_CHECKSUM_CRC32C) }
+  if e = cgf.HeaderIntermediateVerifyHeader(&full) ; e!=nil { t.Fatal(e) }
+  if e = cgf.HeaderIntermediateVerifyPath(&full, 0) ; e!=nil { t.Fatal(e) }
+
+  cut := _synth_header(t, b[:_path_data_end(t, b)])
+  if e = cgf.HeaderIntermediateVerifyHeader(&cut) ; e==nil { t.Error("no header error for a checksummed file without its trailer") }
+  if e = cgf.HeaderIntermediateVerifyPath(&cut, 0) ; e==nil { t.Error("no path error for a checksummed file without its trailer") }
+  if _,e = cgf.HeaderIntermediateLoadPath(&cut, 0) ; e==nil { t.Error("path loaded from a checksummed file without its trailer") }
+  if _,e = cgf.HeaderIntermediateChecksumAlgorithm(&cut) ; e==nil { t.Error("no checksum algorithm error for a checksummed file without its trailer") }
+
+  // A corrupt record is an error, not a file without checksums.
+  //
+  bad := _synth_header(t, b)
+  cgf.HeaderIntermediateSetExt(&bad, cgf.CGF_EXT_CHECKSUM, []byte{ 0x7f })
+  if _,e = cgf.HeaderIntermediateChecksumAlgorithm(&bad) ; e==nil { t.Error("no checksum algorithm error for a corrupt checksum record") }
+  if e = cgf.HeaderIntermediateVerifyHeader(&bad) ; e==nil { t.Error("no header error for a corrupt checksum record") }
+
+  // Turning checksums off again drops the expectation.
+  //
+  e = cgf.HeaderIntermediateSetChecksum(&hdri, cgf.CGF_CHECKSUM_NONE)
+  if e!=nil { t.Fatal(e) }
+  b,e = cgf.CGFBytesFromIntermediate(&hdri)
+  if e!=nil { t.Fatal(e) }
+
+  cut = _synth_header(t, b[:_path_data_end(t, b)])
+  if e = cgf.HeaderIntermediateVerifyHeader(&cut) ; e!=nil { t.Errorf("checksums off: %v", e) }
+  if e = cgf.HeaderIntermediateVerifyPath(&cut, 0) ; e!=nil { t.Errorf("checksums off: %v", e) }
+}
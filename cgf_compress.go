@@ -0,0 +1,133 @@
+package cgf
+
+import "fmt"
+import "bytes"
+import "io/ioutil"
+import "compress/gzip"
+
+import "github.com/abeconnelly/dlug"
+
+// Compression extension record (CGF_EXT_COMPRESSION):
+//
+//   PathCount dlug
+//   Code      [PathCount]dlug
+//
+// Code is the compression applied to the stored PathBytes block of each
+// path.  Paths past PathCount are uncompressed.  Checksums are taken over
+// the stored (compressed) bytes so `verify` doesn't need to inflate them.
+//
+
+const CGF_EXT_COMPRESSION int = 2
+
+const CGF_COMPRESS_NONE int = 0
+const CGF_COMPRESS_GZIP int = 1
+
+func CompressionCode(name string) (int, error) {
+  if name=="" || name=="none" { return CGF_COMPRESS_NONE, nil }
+  if name=="gzip" { return CGF_COMPRESS_GZIP, nil }
+  return -1, fmt.Errorf("unknown compression '%s' (expected none or gzip)", name)
+}
+
+func CompressionName(code int) string {
+  if code==CGF_COMPRESS_GZIP { return "gzip" }
+  if code==CGF_COMPRESS_NONE { return "none" }
+  return fmt.Sprintf("unknown(%d)", code)
+}
+
+func _compress_bytes(code int, b []byte) ([]byte, error) {
+  if code==CGF_COMPRESS_NONE { return b, nil }
+  if code!=CGF_COMPRESS_GZIP { return nil, fmt.Errorf("unknown compression code %d", code) }
+
+  var buf bytes.Buffer
+  w,e := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+  if e!=nil { return nil, e }
+  _,e = w.Write(b)
+  if e!=nil { return nil, e }
+  e = w.Close()
+  if e!=nil { return nil, e }
+  return buf.Bytes(), nil
+}
+
+func _decompress_bytes(code int, b []byte) ([]byte, error) {
+  if code==CGF_COMPRESS_NONE { return b, nil }
+  if code!=CGF_COMPRESS_GZIP { return nil, fmt.Errorf("unknown compression code %d", code) }
+
+  r,e := gzip.NewReader(bytes.NewReader(b))
+  if e!=nil { return nil, e }
+  defer r.Close()
+  return ioutil.ReadAll(r)
+}
+
+func _header_compression_codes(hdri *HeaderIntermediate) []int {
+  codes := make([]int, hdri.pathcount)
+
+  b,ok := HeaderIntermediateGetExt(hdri, CGF_EXT_COMPRESSION)
+  if !ok { return codes }
+
+  n:=0
+  npath,dn := dlug.ConvertUint64(b[n:])
+  n+=dn
+  for i:=0; i<int(npath) && n<len(b); i++ {
+    code,dn := dlug.ConvertUint64(b[n:])
+    n+=dn
+    if i<len(codes) { codes[i] = int(code) }
+  }
+  return codes
+}
+
+func _header_set_compression_codes(hdri *HeaderIntermediate, codes []int) {
+  all_none := true
+  for i:=0; i<len(codes); i++ {
+    if codes[i]!=CGF_COMPRESS_NONE { all_none = false ; break }
+  }
+
+  if all_none {
+    HeaderIntermediateSetExt(hdri, CGF_EXT_COMPRESSION, nil)
+    return
+  }
+
+  b := make([]byte, 0, len(codes)+8)
+  b = append(b, dlug.MarshalUint64(uint64(len(codes)))...)
+  for i:=0; i<len(codes); i++ {
+    b = append(b, dlug.MarshalUint64(uint64(codes[i]))...)
+  }
+  HeaderIntermediateSetExt(hdri, CGF_EXT_COMPRESSION, b)
+}
+
+func HeaderIntermediatePathCompression(hdri *HeaderIntermediate, path int) int {
+  codes := _header_compression_codes(hdri)
+  if path<0 || path>=len(codes) { return CGF_COMPRESS_NONE }
+  return codes[path]
+}
+
+// Uncompressed path bytes, as they would be handed to PathIntermediateFromBytes.
+//
+func HeaderIntermediateRawPathBytes(hdri *HeaderIntermediate, path int) ([]byte, error) {
+  if path<0 || path>=len(hdri.PathBytes) { return nil, fmt.Errorf("path %x out of range", path) }
+  b,e := _decompress_bytes(HeaderIntermediatePathCompression(hdri, path), hdri.PathBytes[path])
+  if e!=nil { return nil, fmt.Errorf("path %x: %v", path, e) }
+  return b, nil
+}
+
+// Re-store the path block with compression `code` (CGF_COMPRESS_NONE
+// to store it raw).
+//
+func HeaderIntermediateCompressPath(hdri *HeaderIntermediate, path int, code int) error {
+  raw,e := HeaderIntermediateRawPathBytes(hdri, path)
+  if e!=nil { return e }
+
+  codes := _header_compression_codes(hdri)
+  if len(raw)==0 {
+    codes[path] = CGF_COMPRESS_NONE
+    _header_set_compression_codes(hdri, codes)
+    return nil
+  }
+
+  b,e := _compress_bytes(code, raw)
+  if e!=nil { return e }
+
+  _header_intermediate_set_path_bytes(hdri, path, b)
+  codes[path] = code
+  _header_set_compression_codes(hdri, codes)
+  return nil
+}
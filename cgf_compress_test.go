@@ -0,0 +1,106 @@
+package cgf_test
+
+import "testing"
+import "bytes"
+import "math/rand"
+
+import "github.com/abeconnelly/cgf"
+import "github.com/abeconnelly/cgf/synth"
+
+func _compress_fixture(tb testing.TB) []byte {
+  p := synth.DefaultParams()
+  p.Steps = 5000
+  p.Samples = 1
+  _,cgf_bytes := _synth_fixture(tb, p)
+  return cgf_bytes[0]
+}
+
+func TestCompressPathRoundtrip(t *testing.T) {
+  cgf_bytes := _compress_fixture(t)
+  hdri := _synth_header(t, cgf_bytes)
+
+  raw,e := cgf.HeaderIntermediateRawPathBytes(&hdri, 0)
+  if e!=nil { t.Fatal(e) }
+  want,e := cgf.HeaderIntermediateLoadPath(&hdri, 0)
+  if e!=nil { t.Fatal(e) }
+
+  e = cgf.HeaderIntermediateCompressPath(&hdri, 0, cgf.CGF_COMPRESS_GZIP)
+  if e!=nil { t.Fatal(e) }
+  cgf.HeaderIntermediateUpdateChecksum(&hdri)
+
+  b,e := cgf.CGFBytesFromIntermediate(&hdri)
+  if e!=nil { t.Fatal(e) }
+  hdri_gz := _synth_header(t, b)
+
+  if c := cgf.HeaderIntermediatePathCompression(&hdri_gz, 0) ; c!=cgf.CGF_COMPRESS_GZIP {
+    t.Fatalf("compression code %d, want %d", c, cgf.CGF_COMPRESS_GZIP)
+  }
+  if len(hdri_gz.PathBytes[0])>=len(raw) {
+    t.Errorf("gzip path %d bytes, raw %d bytes", len(hdri_gz.PathBytes[0]), len(raw))
+  }
+
+  raw_gz,e := cgf.HeaderIntermediateRawPathBytes(&hdri_gz, 0)
+  if e!=nil { t.Fatal(e) }
+  if !bytes.Equal(raw, raw_gz) { t.Fatal("inflated path bytes differ from the raw path bytes") }
+
+  // Every path accessor has to see through the compression.
+  //
+  pb,e := cgf.CGFPathBytes(b, 0)
+  if e!=nil { t.Fatal(e) }
+  if !bytes.Equal(raw, pb) { t.Fatal("CGFPathBytes didn't inflate the path") }
+
+  got,e := cgf.HeaderIntermediateLoadPath(&hdri_gz, 0)
+  if e!=nil { t.Fatal(e) }
+  for step:=0; step<hdri.StepPerPath[0]; step++ {
+    kw := cgf.GetKnot(hdri.TileMap, want, step)
+    kg := cgf.GetKnot(hdri_gz.TileMap, got, step)
+    if len(kw)!=len(kg) { t.Fatalf("step %04x: %d alleles, want %d", step, len(kg), len(kw)) }
+    for a:=0; a<len(kw); a++ {
+      if len(kw[a])!=len(kg[a]) { t.Fatalf("step %04x: allele %d differs", step, a) }
+      for i:=0; i<len(kw[a]); i++ {
+        if kw[a][i].Step!=kg[a][i].Step || kw[a][i].VarId!=kg[a][i].VarId || kw[a][i].Span!=kg[a][i].Span {
+          t.Fatalf("step %04x: allele %d differs", step, a)
+        }
+      }
+    }
+  }
+}
+
+func _bench_load_path(b *testing.B, code int) {
+  hdri := _synth_header(b, _compress_fixture(b))
+  e := cgf.HeaderIntermediateCompressPath(&hdri, 0, code)
+  if e!=nil { b.Fatal(e) }
+  cgf.HeaderIntermediateUpdateChecksum(&hdri)
+
+  steps := make([]int, 1024)
+  for i:=0; i<len(steps); i++ { steps[i] = rand.Intn(hdri.StepPerPath[0]) }
+
+  b.SetBytes(int64(len(hdri.PathBytes[0])))
+  b.ResetTimer()
+  for i:=0; i<b.N; i++ {
+    pathi,e := cgf.HeaderIntermediateLoadPath(&hdri, 0)
+    if e!=nil { b.Fatal(e) }
+    cgf.GetKnot(hdri.TileMap, pathi, steps[i%len(steps)])
+  }
+}
+
+// Single knot access loading the path from its stored bytes, as a reader
+// without a path cache does.
+//
+func BenchmarkLoadPathRaw(b *testing.B) { _bench_load_path(b, cgf.CGF_COMPRESS_NONE) }
+func BenchmarkLoadPathGzip(b *testing.B) { _bench_load_path(b, cgf.CGF_COMPRESS_GZIP) }
+
+func BenchmarkCompressPathGzip(b *testing.B) {
+  hdri := _synth_header(b, _compress_fixture(b))
+  raw,e := cgf.HeaderIntermediateRawPathBytes(&hdri, 0)
+  if e!=nil { b.Fatal(e) }
+
+  b.SetBytes(int64(len(raw)))
+  b.ResetTimer()
+  for i:=0; i<b.N; i++ {
+    e = cgf.HeaderIntermediateCompressPath(&hdri, 0, cgf.CGF_COMPRESS_GZIP)
+    if e!=nil { b.Fatal(e) }
+    e = cgf.HeaderIntermediateCompressPath(&hdri, 0, cgf.CGF_COMPRESS_NONE)
+    if e!=nil { b.Fatal(e) }
+  }
+}
@@ -3,7 +3,6 @@ package cgf
 
 
 import "fmt"
-import "io/ioutil"
 //import "./dlug"
 import "github.com/abeconnelly/dlug"
 
@@ -20,11 +19,26 @@ func HeaderIntermediateDebugPrint(hdri HeaderIntermediate) {
 
   fmt.Printf("path_offset[%d]:", len(hdri.path_offset))
   fmt.Printf("%v\n", hdri.path_offset)
+
+  fmt.Printf("tagsets: %v\n", HeaderIntermediateTagsets(&hdri))
+
+  canon := _header_canon(&hdri)
+  n_canon := make([]int, len(canon))
+  for i:=0; i<len(canon); i++ { n_canon[i] = len(canon[i]) }
+  fmt.Printf("canon steps: %v\n", n_canon)
+
+  phase := _header_phase(&hdri)
+  fmt.Printf("phase:")
+  for i:=0; i<len(phase); i++ { fmt.Printf(" %d/%d", phase[i].Phase, len(phase[i].Block)) }
+  fmt.Printf("\n")
+
+  names,e := HeaderIntermediateAnnotNames(&hdri)
+  if e!=nil { fmt.Printf("annotation: %v\n", e) } else { fmt.Printf("annotation: %v\n", names) }
 }
 
 //func debug_read(ifn string) error {
 func DebugRead(ifn string) error {
-  b,e := ioutil.ReadFile(ifn)
+  b,e := StoreReadFile(ifn)
   if e!=nil { return e }
   return debug_unpack_bytes(b)
 }
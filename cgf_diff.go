@@ -0,0 +1,234 @@
+package cgf
+
+import "fmt"
+import "bytes"
+import "strings"
+
+// Differences between two CGF samples.
+//
+// Headers are compared field by field (versions, path count, tile map,
+// steps and tagset of each path).  Paths are compared step by step: a
+// step differs if the tiles covering it, allele by allele, aren't the
+// same (step, variant, span and nocalls).  Alleles of unphased paths
+// (see cgf_phase.go) are compared in both orders.  Knots are decoded
+// with each file's own tile map, so samples encoded with different tile
+// maps can be compared.
+//
+// With ignore_nocall, steps where a tile covering them has nocalls in
+// either sample are left out (as `distance` does), so only differences
+// that can't be explained by nocalls are reported.
+//
+// Differing steps are grouped into blocks of contiguous steps.  Each
+// block holds the tiles of each sample covering it, allele by allele,
+// including tiles of knots anchored before the block.
+//
+
+type DiffBlock struct {
+  Path int
+  Beg int
+  End int
+
+  A [][]TileInfo
+  B [][]TileInfo
+}
+
+func _tile_nocall_eq(a, b *TileInfo) bool {
+  if !_tile_eq(a,b) { return false }
+  if len(a.NocallStartLen)!=len(b.NocallStartLen) { return false }
+  for i:=0; i<len(a.NocallStartLen); i++ {
+    if a.NocallStartLen[i]!=b.NocallStartLen[i] { return false }
+  }
+  return true
+}
+
+// Tile id as printed by `knot-2` with its nocalls: TileIdString followed
+// by *{start+len;...}.
+//
+func TileIdNocallString(path, ver int, ti TileInfo) string {
+  s := TileIdString(path, ver, ti)
+  if len(ti.NocallStartLen)==0 { return s }
+
+  nc := make([]string, 0, len(ti.NocallStartLen)/2)
+  for p:=0; p+1<len(ti.NocallStartLen); p+=2 {
+    nc = append(nc, fmt.Sprintf("%d+%d", ti.NocallStartLen[p], ti.NocallStartLen[p+1]))
+  }
+  return s + "*{" + strings.Join(nc, ";") + "}"
+}
+
+// Whether the covering tiles of the two knots differ at step.
+//
+func _knot_step_differs(ka, kb [][]TileInfo, step int, unphased, ignore_nocall bool) bool {
+  if len(ka)!=len(kb) { return true }
+
+  ca := make([]*TileInfo, len(ka))
+  cb := make([]*TileInfo, len(kb))
+  for allele:=0; allele<len(ka); allele++ {
+    for i:=0; i<len(ka[allele]); i++ {
+      ti := &ka[allele][i]
+      if ti.Step<=step && step<ti.Step+ti.Span { ca[allele] = ti ; break }
+    }
+    for i:=0; i<len(kb[allele]); i++ {
+      ti := &kb[allele][i]
+      if ti.Step<=step && step<ti.Step+ti.Span { cb[allele] = ti ; break }
+    }
+
+    if ignore_nocall {
+      if ca[allele]!=nil && len(ca[allele].NocallStartLen)>0 { return false }
+      if cb[allele]!=nil && len(cb[allele].NocallStartLen)>0 { return false }
+    }
+  }
+
+  eq := func(x, y *TileInfo) bool {
+    if x==nil || y==nil { return x==y }
+    return _tile_nocall_eq(x, y)
+  }
+
+  same := true
+  for allele:=0; allele<len(ca); allele++ {
+    if !eq(ca[allele], cb[allele]) { same = false ; break }
+  }
+  if !same && unphased && len(ca)==2 {
+    same = eq(ca[0], cb[1]) && eq(ca[1], cb[0])
+  }
+
+  return !same
+}
+
+// Tiles of the knots in knots (by anchor step) covering [beg,end), per
+// allele.
+//
+func _block_tiles(knots map[int][][]TileInfo, anchors []int, beg, end int) [][]TileInfo {
+  var res [][]TileInfo
+  for _,anchor := range anchors {
+    knot := knots[anchor]
+    for allele:=0; allele<len(knot); allele++ {
+      for len(res)<=allele { res = append(res, []TileInfo{}) }
+      for _,ti := range knot[allele] {
+        if ti.Step+ti.Span<=beg || ti.Step>=end { continue }
+        res[allele] = append(res[allele], ti)
+      }
+    }
+  }
+  return res
+}
+
+// Blocks of differing steps of path in [beg,end) (end -1 for the whole
+// path).  Both paths must have the same number of steps.
+//
+func PathDiff(tilemap_a []TileMapEntry, a PathIntermediate, tilemap_b []TileMapEntry, b PathIntermediate, path, beg, end int, ignore_nocall bool) ([]DiffBlock, error) {
+  if a.ntile!=b.ntile { return nil, fmt.Errorf("path %04x: step count mismatch (%d != %d)", path, a.ntile, b.ntile) }
+  if end<0 || end>a.ntile { end = a.ntile }
+  if beg<0 { beg = 0 }
+
+  unphased := PathIsUnphased(a) || PathIsUnphased(b)
+  blocks := []DiffBlock{}
+  if beg>=end { return blocks, nil }
+
+  // Start from the knots covering beg.
+  //
+  anchor_a,ka := _covering_knot(tilemap_a, a, beg)
+  anchor_b,kb := _covering_knot(tilemap_b, b, beg)
+  if ka==nil || kb==nil { return nil, fmt.Errorf("path %04x: no knot covering step %04x", path, beg) }
+
+  ca := _new_knot_cursor(tilemap_a, &a, beg)
+  cb := _new_knot_cursor(tilemap_b, &b, beg)
+
+  var cur *DiffBlock
+  var knots_a, knots_b map[int][][]TileInfo
+  var anchors_a, anchors_b []int
+
+  flush := func() {
+    if cur==nil { return }
+    cur.A = _block_tiles(knots_a, anchors_a, cur.Beg, cur.End)
+    cur.B = _block_tiles(knots_b, anchors_b, cur.Beg, cur.End)
+    blocks = append(blocks, *cur)
+    cur = nil
+  }
+
+  for step:=beg; step<end; step++ {
+    if ta := ca.next() ; ta!=nil && step>beg { ka,anchor_a = ta,step }
+    if tb := cb.next() ; tb!=nil && step>beg { kb,anchor_b = tb,step }
+
+    differs := _knot_step_differs(ka, kb, step, unphased, ignore_nocall)
+    if !differs {
+      flush()
+      continue
+    }
+
+    if cur==nil {
+      cur = &DiffBlock{ Path:path, Beg:step, End:step }
+      knots_a = make(map[int][][]TileInfo)
+      knots_b = make(map[int][][]TileInfo)
+      anchors_a,anchors_b = nil,nil
+    }
+    cur.End = step+1
+
+    if _,ok := knots_a[anchor_a] ; !ok {
+      knots_a[anchor_a] = ka
+      anchors_a = append(anchors_a, anchor_a)
+    }
+    if _,ok := knots_b[anchor_b] ; !ok {
+      knots_b[anchor_b] = kb
+      anchors_b = append(anchors_b, anchor_b)
+    }
+  }
+  flush()
+
+  return blocks, nil
+}
+
+func _tilemap_entry_eq(a, b *TileMapEntry) bool {
+  if len(a.Variant)!=len(b.Variant) || len(a.Span)!=len(b.Span) { return false }
+  for j:=0; j<len(a.Variant); j++ {
+    if len(a.Variant[j])!=len(b.Variant[j]) { return false }
+    for k:=0; k<len(a.Variant[j]); k++ {
+      if a.Variant[j][k]!=b.Variant[j][k] { return false }
+    }
+  }
+  for j:=0; j<len(a.Span); j++ {
+    if len(a.Span[j])!=len(b.Span[j]) { return false }
+    for k:=0; k<len(a.Span[j]); k++ {
+      if a.Span[j][k]!=b.Span[j][k] { return false }
+    }
+  }
+  return true
+}
+
+// Header differences, one line each (empty if the headers agree).
+//
+func HeaderIntermediateDiff(a, b *HeaderIntermediate) []string {
+  d := []string{}
+
+  if a.ver!=b.ver { d = append(d, fmt.Sprintf("version: %s != %s", a.ver, b.ver)) }
+  if a.libver!=b.libver { d = append(d, fmt.Sprintf("library version: %s != %s", a.libver, b.libver)) }
+  if a.pathcount!=b.pathcount { d = append(d, fmt.Sprintf("path count: %d != %d", a.pathcount, b.pathcount)) }
+
+  if !bytes.Equal(a.TileMapBytes, b.TileMapBytes) {
+    if len(a.TileMap)!=len(b.TileMap) {
+      d = append(d, fmt.Sprintf("tile map: %d != %d entries", len(a.TileMap), len(b.TileMap)))
+    }
+
+    n,first := 0,-1
+    for i:=0; i<len(a.TileMap) && i<len(b.TileMap); i++ {
+      if _tilemap_entry_eq(&a.TileMap[i], &b.TileMap[i]) { continue }
+      if first<0 { first = i }
+      n++
+    }
+    if n>0 { d = append(d, fmt.Sprintf("tile map: %d entries differ (first at %d)", n, first)) }
+    if n==0 && len(a.TileMap)==len(b.TileMap) { d = append(d, "tile map: encodings differ") }
+  }
+
+  npath := len(a.StepPerPath)
+  if len(b.StepPerPath)>npath { npath = len(b.StepPerPath) }
+  for path:=0; path<npath; path++ {
+    sa,sb := 0,0
+    if path<len(a.StepPerPath) { sa = a.StepPerPath[path] }
+    if path<len(b.StepPerPath) { sb = b.StepPerPath[path] }
+    if sa!=sb { d = append(d, fmt.Sprintf("path %04x: steps %d != %d", path, sa, sb)) }
+
+    va,vb := HeaderIntermediatePathTagset(a, path),HeaderIntermediatePathTagset(b, path)
+    if sa>0 && sb>0 && va!=vb { d = append(d, fmt.Sprintf("path %04x: tagset %d != %d", path, va, vb)) }
+  }
+
+  return d
+}
@@ -0,0 +1,158 @@
+package cgf
+
+import "fmt"
+import "math/bits"
+
+// Whether every knot anchored in the Vector word can be read from the
+// word alone: at most 8 non-canonical steps, so each has a hexit, and no
+// overflow hexits.  Low quality knots are always stored as overflow, so
+// these words have none.
+//
+func _vec_word_simple(vec uint64) bool {
+  nc := bits.OnesCount64(vec>>32)
+  if nc>8 { return false }
+  for i:=0; i<nc; i++ {
+    if ((vec>>(4*uint(i))) & 0xf) >= 0xd { return false }
+  }
+  return true
+}
+
+// Offsets of the first and last steps of a simple word (within the first
+// n steps) that anchor a knot and the tilemap entry of the last one.
+// first is -1 if no knot is anchored in the word.
+//
+func _vec_word_anchors(vec uint64, n int) (first, last, last_tm int) {
+  first,last = -1,-1
+  cache_counter := 0
+  for m:=0; m<n; m++ {
+    tm := 0
+    if (vec & (1<<(32+uint(m)))) != 0 {
+      tm = int((vec >> (4*uint(cache_counter))) & 0xf)
+      cache_counter++
+      if tm==0 { continue }
+    }
+    if first<0 { first = m }
+    last,last_tm = m,tm
+  }
+  return
+}
+
+func _tile_eq(a, b *TileInfo) bool {
+  return a.Step==b.Step && a.VarId==b.VarId && a.Span==b.Span
+}
+
+func _tile_ne(a, b *TileInfo) int {
+  if _tile_eq(a,b) { return 0 }
+  return 1
+}
+
+// Tile covering `step` on each allele of the knot.  ok is false if an
+// allele has no tile there or any covering tile is low quality.
+//
+func _knot_cover(knot [][]TileInfo, step int, cover []*TileInfo) (n int, ok bool) {
+  if len(knot)>len(cover) { return 0, false }
+  for allele:=0; allele<len(knot); allele++ {
+    cover[allele] = nil
+    for i:=0; i<len(knot[allele]); i++ {
+      ti := &knot[allele][i]
+      if ti.Step<=step && step<(ti.Step+ti.Span) {
+        cover[allele] = ti
+        break
+      }
+    }
+    if cover[allele]==nil { return 0, false }
+    if len(cover[allele].NocallStartLen)>0 { return 0, false }
+  }
+  return len(knot), true
+}
+
+// Number of alleles whose covering tiles differ at `step`, taking the
+// better of the two allele orders, and whether the step is comparable.
+//
+func _knot_step_diff(ka, kb [][]TileInfo, step int) (int, bool) {
+  var ca,cb [2]*TileInfo
+
+  na,ok := _knot_cover(ka, step, ca[:])
+  if !ok { return 0, false }
+  nb,ok := _knot_cover(kb, step, cb[:])
+  if !ok { return 0, false }
+
+  if na==2 && nb==2 {
+    d0 := _tile_ne(ca[0],cb[0]) + _tile_ne(ca[1],cb[1])
+    d1 := _tile_ne(ca[0],cb[1]) + _tile_ne(ca[1],cb[0])
+    if d1<d0 { return d1, true }
+    return d0, true
+  }
+
+  if na==1 && nb==1 { return _tile_ne(ca[0],cb[0]), true }
+
+  // Haploid against diploid: the haploid allele against the closer of
+  // the other two.
+  //
+  if na==2 && nb==1 { ca,cb = cb,ca }
+  if na+nb==3 {
+    d := _tile_ne(ca[0],cb[0])
+    if _tile_ne(ca[0],cb[1])<d { d = 0 }
+    return d, true
+  }
+  return 0, false
+}
+
+// Genotype distance between two samples over one path.  diff is the
+// number of differing tile alleles summed over the steps where neither
+// sample has a low quality tile, comparable the number of those steps.
+// Both paths must be encoded with the same tile map.  Vector words that
+// are identical in both and hold only tile map knots are counted without
+// decoding them (when both paths have the same canon table).
+//
+func PathPairDistance(tilemap []TileMapEntry, a, b PathIntermediate) (diff, comparable int, err error) {
+  if a.ntile!=b.ntile { return 0, 0, fmt.Errorf("step count mismatch (%d != %d)", a.ntile, b.ntile) }
+
+  ca := _new_knot_cursor(tilemap, &a, 0)
+  cb := _new_knot_cursor(tilemap, &b, 0)
+
+  var ka, kb [][]TileInfo
+  same_canon := _canon_equal(a.canon, b.canon)
+
+  for step:=0; step<a.ntile; {
+    w := step/32
+    wend := (w+1)*32
+    if wend>a.ntile { wend = a.ntile }
+
+    va,vb := a.VecUint64[w], b.VecUint64[w]
+    if step%32==0 && va==vb && same_canon && _vec_word_simple(va) {
+      first,last,last_tm := _vec_word_anchors(va, wend-step)
+      if first>=0 {
+
+        // Steps before the first anchor belong to knots from an earlier
+        // word and still need comparing.
+        //
+        for anchor := step+first; step<anchor; step++ {
+          ta,tb := ca.next(),cb.next()
+          if ta!=nil { ka = ta }
+          if tb!=nil { kb = tb }
+          d,ok := _knot_step_diff(ka, kb, step)
+          if ok { diff+=d ; comparable++ }
+        }
+
+        comparable += wend-step
+
+        ka = _knot_canon(&a, _tilemap_knot(tilemap, last_tm, w*32+last))
+        kb = ka
+        ca.step,cb.step = wend,wend
+        step = wend
+        continue
+      }
+    }
+
+    ta,tb := ca.next(),cb.next()
+    if ta!=nil { ka = ta }
+    if tb!=nil { kb = tb }
+
+    d,ok := _knot_step_diff(ka, kb, step)
+    if ok { diff+=d ; comparable++ }
+    step++
+  }
+
+  return diff, comparable, nil
+}
@@ -0,0 +1,10 @@
+package cgf
+
+// The path as a caller that put it together by hand would have it,
+// without the random access tables PathIntermediateFromBytes builds.
+//
+func PathIntermediateWithoutRank(pathi PathIntermediate) PathIntermediate {
+  pathi.ovf_rank = nil
+  pathi.fof_offset = nil
+  return pathi
+}
@@ -0,0 +1,120 @@
+package cgf
+
+import "fmt"
+import "github.com/abeconnelly/dlug"
+
+// Optional header extension records.
+//
+// Extension records are stored after the last path block, starting at
+// the final entry of the path offset table, so readers that don't know
+// about them (including the C++ reader) never look there.  The layout is:
+//
+//   { Code dlug, Length dlug, Data [Length]byte } ...
+//   ExtByteLen u64       (number of bytes of records above)
+//   ExtMagic   [8]byte
+//
+// The trailing length and magic let a reader that only has the end of
+// the file find the start of the records.  Records with unknown codes
+// are preserved as-is.
+//
+
+var CGF_EXT_MAGIC []byte = []byte{ '"', 'c', 'g', 'f', '.', 'x', '"', '}' }
+
+const CGF_EXT_CHECKSUM int = 1
+
+type HeaderExtRecord struct {
+  Code int
+  Data []byte
+}
+
+func BytesFromHeaderExt(ext []HeaderExtRecord) []byte {
+  if len(ext)==0 { return nil }
+
+  buf := make([]byte, 8)
+  b := make([]byte, 0, 1024)
+
+  for i:=0; i<len(ext); i++ {
+    b = append(b, dlug.MarshalUint64(uint64(ext[i].Code))...)
+    b = append(b, dlug.MarshalUint64(uint64(len(ext[i].Data)))...)
+    b = append(b, ext[i].Data...)
+  }
+
+  tobyte64(buf, uint64(len(b)))
+  b = append(b, buf[0:8]...)
+  b = append(b, CGF_EXT_MAGIC...)
+
+  return b
+}
+
+// b should start at the first extension record and end at the magic.
+//
+func HeaderExtFromBytes(b []byte) ([]HeaderExtRecord, error) {
+  ext := []HeaderExtRecord{}
+  if len(b)==0 { return ext, nil }
+
+  if len(b)<16 { return nil, fmt.Errorf("extension trailer truncated (%d bytes)", len(b)) }
+
+  for i:=0; i<8; i++ {
+    if b[len(b)-8+i] != CGF_EXT_MAGIC[i] { return nil, fmt.Errorf("bad extension magic") }
+  }
+
+  ext_len := int(byte2uint64(b[len(b)-16:len(b)-8]))
+  if ext_len != len(b)-16 {
+    return nil, fmt.Errorf("extension length mismatch (%d != %d)", ext_len, len(b)-16)
+  }
+
+  n:=0
+  for n<ext_len {
+    code,dn := dlug.ConvertUint64(b[n:])
+    if dn<=0 || (n+dn)>ext_len { return nil, fmt.Errorf("bad extension record code at %d", n) }
+    n+=dn
+
+    l,dn := dlug.ConvertUint64(b[n:])
+    if dn<=0 || (n+dn+int(l))>ext_len { return nil, fmt.Errorf("bad extension record length at %d", n) }
+    n+=dn
+
+    ext = append(ext, HeaderExtRecord{ Code:int(code), Data:b[n:n+int(l)] })
+    n+=int(l)
+  }
+
+  return ext, nil
+}
+
+func HeaderIntermediateGetExt(hdri *HeaderIntermediate, code int) ([]byte, bool) {
+  for i:=0; i<len(hdri.ext); i++ {
+    if hdri.ext[i].Code == code { return hdri.ext[i].Data, true }
+  }
+  return nil, false
+}
+
+// Replace the record with `code` or add it if it isn't there.
+// A nil data removes the record.
+//
+func HeaderIntermediateSetExt(hdri *HeaderIntermediate, code int, data []byte) {
+  for i:=0; i<len(hdri.ext); i++ {
+    if hdri.ext[i].Code != code { continue }
+    if data==nil {
+      hdri.ext = append(hdri.ext[:i], hdri.ext[i+1:]...)
+    } else {
+      hdri.ext[i].Data = data
+    }
+    return
+  }
+
+  if data==nil { return }
+  hdri.ext = append(hdri.ext, HeaderExtRecord{ Code:code, Data:data })
+}
+
+// Bytes of all extension records except the one with `code`, in order.
+// Used as input to the header digest.
+//
+func _header_ext_bytes_except(hdri *HeaderIntermediate, code int) []byte {
+  b := make([]byte, 0, 1024)
+  for i:=0; i<len(hdri.ext); i++ {
+    if hdri.ext[i].Code == code { continue }
+    b = append(b, dlug.MarshalUint64(uint64(hdri.ext[i].Code))...)
+    b = append(b, dlug.MarshalUint64(uint64(len(hdri.ext[i].Data)))...)
+    b = append(b, hdri.ext[i].Data...)
+  }
+  return b
+}
@@ -0,0 +1,61 @@
+package cgf
+
+import "fmt"
+
+import "github.com/abeconnelly/cglf"
+
+func _lib_tile_seq(sglf *cglf.SGLF, path, ver int, ti TileInfo) (string, error) {
+  steps,ok := sglf.Lib[path]
+  if !ok ||
+     ti.Step<0 || ti.Step>=len(steps) ||
+     ti.VarId<0 || ti.VarId>=len(steps[ti.Step]) {
+    return "", fmt.Errorf("tile %s not in library", TileIdString(path, ver, ti))
+  }
+  return steps[ti.Step][ti.VarId], nil
+}
+
+// Haplotype sequences, one per allele, of the knots anchored in
+// [beg,end) of a path.  Adjacent tiles share a TAG_LEN tag, which is
+// kept once; a nocall in either copy of the tag masks the base.  Nocall
+// bases are masked with 'n'.
+//
+func PathHaplotypeSeq(tilemap []TileMapEntry, pathi PathIntermediate, path, beg, end int, sglf *cglf.SGLF) ([]string, error) {
+  var hap [][]byte
+  ver := PathTagset(pathi)
+
+  e := PathKnotScan(tilemap, pathi, beg, end, func(anchor_step int, knot [][]TileInfo) error {
+    if hap==nil { hap = make([][]byte, len(knot)) }
+    if len(knot)!=len(hap) { return fmt.Errorf("step %x: allele count changed (%d != %d)", anchor_step, len(knot), len(hap)) }
+
+    for allele:=0; allele<len(knot); allele++ {
+      for i:=0; i<len(knot[allele]); i++ {
+        ti := knot[allele][i]
+
+        seq,e := _lib_tile_seq(sglf, path, ver, ti)
+        if e!=nil { return e }
+        if len(ti.NocallStartLen)>0 { seq = FillNocSeq(seq, ti.NocallStartLen) }
+
+        n := len(hap[allele])
+        if n==0 {
+          hap[allele] = append(hap[allele], seq...)
+          continue
+        }
+
+        if len(seq)<TAG_LEN || n<TAG_LEN { return fmt.Errorf("tile %s shorter than tag", TileIdString(path, ver, ti)) }
+        for k:=0; k<TAG_LEN; k++ {
+          if seq[k]=='n' || seq[k]=='N' { hap[allele][n-TAG_LEN+k] = 'n' }
+        }
+        hap[allele] = append(hap[allele], seq[TAG_LEN:]...)
+      }
+    }
+
+    return nil
+  })
+  if e!=nil { return nil, e }
+
+  res := make([]string, len(hap))
+  for allele:=0; allele<len(hap); allele++ {
+    res[allele] = string(hap[allele])
+  }
+  return res, nil
+}
@@ -64,7 +64,16 @@ func _noc_cmp(a,b string) bool {
 }
 
 func LoadSampleFastj(scan *autoio.AutoioHandle) ([][]TileInfo, error) {
+  allele_path,_,e := LoadSampleFastjTagset(scan)
+  return allele_path, e
+}
+
+// As LoadSampleFastj, also returning the tagset version of the tile IDs
+// (-1 if there are no tiles).  Every tile has to be in the same tagset.
+//
+func LoadSampleFastjTagset(scan *autoio.AutoioHandle) ([][]TileInfo, int, error) {
   line_no:=0
+  tagset := -1
 
   cur_seq := make([]byte, 0, 1024)
   tilepath := -1
@@ -101,16 +110,13 @@ func LoadSampleFastj(scan *autoio.AutoioHandle) ([][]TileInfo, error) {
       //
       if !first_tile {
         m5 := Md5sum2str( md5.Sum(cur_seq) )
-        if m5!=md5sum_str { return nil,fmt.Errorf("md5sums do not match %s != %s (line %d)", m5, md5sum_str, line_no) }
+        if m5!=md5sum_str { return nil,-1,fmt.Errorf("md5sums do not match %s != %s (line %d)", m5, md5sum_str, line_no) }
         ti := emit_fastj_tile(tilepath, tilestep, span_len, s_tag, cur_seq, e_tag)
 
-        if tilevar==0 {
-          allele_path[0] = append(allele_path[0], ti)
-        } else if tilevar==1 {
-          allele_path[1] = append(allele_path[1], ti)
-        } else {
-          return nil,fmt.Errorf("invalid tile variant allele %d", tilevar)
+        if tilevar<0 || tilevar>=CGF_MAX_PLOIDY {
+          return nil,-1,fmt.Errorf("invalid tile variant allele %d (only haploid and diploid paths are supported)", tilevar)
         }
+        allele_path[tilevar] = append(allele_path[tilevar], ti)
 
       }
       first_tile = false
@@ -118,44 +124,53 @@ func LoadSampleFastj(scan *autoio.AutoioHandle) ([][]TileInfo, error) {
       var pos int =0
 
       tileid,pos = simple_text_field(l[1:], "tileID")
-      if pos<0 { return nil,fmt.Errorf("no tileID found at line %d", line_no) }
+      if pos<0 { return nil,-1,fmt.Errorf("no tileID found at line %d", line_no) }
 
       md5sum_str,pos = simple_text_field(l[1:], "md5sum")
-      if pos<0 { return nil,fmt.Errorf("no md5sum found at line %d", line_no) }
+      if pos<0 { return nil,-1,fmt.Errorf("no md5sum found at line %d", line_no) }
 
       span_len,pos = simple_int_field(l[1:], "seedTileLength")
-      if pos<0 { return nil,fmt.Errorf("no md5sum found at line %d", line_no) }
+      if pos<0 { return nil,-1,fmt.Errorf("no md5sum found at line %d", line_no) }
 
       s_tag,pos = simple_text_field(l[1:], "startTag")
-      if pos<0 { return nil,fmt.Errorf("no startTag found at line %d", line_no) }
+      if pos<0 { return nil,-1,fmt.Errorf("no startTag found at line %d", line_no) }
       _ = s_tag
 
       e_tag,pos = simple_text_field(l[1:], "endTag")
-      if pos<0 { return nil,fmt.Errorf("no endTag found at line %d", line_no) }
+      if pos<0 { return nil,-1,fmt.Errorf("no endTag found at line %d", line_no) }
       _ = e_tag
 
       start_tile_flag,pos = simple_bool_field(l[1:], "startTile")
-      if pos<0 { return nil,fmt.Errorf("no startTile found at line %d", line_no) }
+      if pos<0 { return nil,-1,fmt.Errorf("no startTile found at line %d", line_no) }
       _ = start_tile_flag
 
       end_tile_flag,pos = simple_bool_field(l[1:], "endTile")
-      if pos<0 { return nil,fmt.Errorf("no endTile found at line %d", line_no) }
+      if pos<0 { return nil,-1,fmt.Errorf("no endTile found at line %d", line_no) }
       _ = end_tile_flag
 
 
 
       tile_parts := strings.Split(tileid, ".")
+      if len(tile_parts)<4 { return nil,-1,fmt.Errorf("invalid tileID '%s' at line %d", tileid, line_no) }
+
+      if t,e := strconv.ParseInt(tile_parts[1], 16, 64) ; e==nil {
+        if tagset>=0 && int(t)!=tagset {
+          return nil,-1,fmt.Errorf("tileID %s at line %d is in tagset %02x, earlier tiles are in tagset %02x", tileid, line_no, t, tagset)
+        }
+        tagset = int(t)
+      } else { return nil,-1,e }
+
       if t,e := strconv.ParseInt(tile_parts[0], 16, 64) ; e==nil {
         tilepath = int(t)
-      } else { return nil,e }
+      } else { return nil,-1,e }
 
       if t,e := strconv.ParseInt(tile_parts[2], 16, 64) ; e==nil {
         tilestep = int(t)
-      } else { return nil,e }
+      } else { return nil,-1,e }
 
       if t,e := strconv.ParseInt(tile_parts[3], 16, 64) ; e==nil {
         tilevar = int(t)
-      } else { return nil,e }
+      } else { return nil,-1,e }
 
       // Header parsed, go on
       //
@@ -163,7 +178,7 @@ func LoadSampleFastj(scan *autoio.AutoioHandle) ([][]TileInfo, error) {
       continue
     }
 
-    if first_tile { return nil,fmt.Errorf("found body before header (line %d)", line_no) }
+    if first_tile { return nil,-1,fmt.Errorf("found body before header (line %d)", line_no) }
 
     cur_seq = append(cur_seq, l[:]...)
 
@@ -173,18 +188,24 @@ func LoadSampleFastj(scan *autoio.AutoioHandle) ([][]TileInfo, error) {
   //
   if !first_tile {
     m5 := Md5sum2str( md5.Sum(cur_seq) )
-    if m5!=md5sum_str { return nil,fmt.Errorf("md5sums do not match %s != %s (line %d)", m5, md5sum_str, line_no) }
+    if m5!=md5sum_str { return nil,-1,fmt.Errorf("md5sums do not match %s != %s (line %d)", m5, md5sum_str, line_no) }
     ti := emit_fastj_tile(tilepath, tilestep, span_len, s_tag, cur_seq, e_tag)
 
-    if tilevar==0 {
-      allele_path[0] = append(allele_path[0], ti)
-    } else if tilevar==1 {
-      allele_path[1] = append(allele_path[1], ti)
-    } else {
-      return nil,fmt.Errorf("invalid tile variant allele %d", tilevar)
+    if tilevar<0 || tilevar>=CGF_MAX_PLOIDY {
+      return nil,-1,fmt.Errorf("invalid tile variant allele %d (only haploid and diploid paths are supported)", tilevar)
     }
+    allele_path[tilevar] = append(allele_path[tilevar], ti)
+
+  }
 
+  // Haploid path
+  //
+  if len(allele_path[1])==0 && len(allele_path[0])>0 {
+    return allele_path[:1],tagset,nil
+  }
+  if len(allele_path[0])==0 && len(allele_path[1])>0 {
+    return nil,-1,fmt.Errorf("no tiles found for allele 0")
   }
 
-  return allele_path,nil
+  return allele_path,tagset,nil
 }
@@ -0,0 +1,110 @@
+package cgf
+
+import "fmt"
+
+// VarId given to every tile of a knot masked by a KnotFilter.
+// Step and Span are kept so the masked knot still covers the same steps.
+//
+const KNOT_MASK_VARID int = -1
+
+// Quality filter shared by the export actions.  A knot fails the filter if
+// either allele has more than MaxNocallFrac of its bases as nocall, if it
+// is stored in the final overflow section (with DropFinalOverflow) or if
+// any of its tiles spans more than MaxSpan steps.  A failing knot is either
+// dropped or, with Mask, replaced by a masked knot (see KNOT_MASK_VARID).
+//
+// The nocall fraction needs the tile lengths, which come from TileLen
+// (path, step, varid, span).  TileLen returns -1 for a tile it doesn't
+// know, which is an error.
+//
+type KnotFilter struct {
+  MaxNocallFrac float64
+  DropFinalOverflow bool
+  MaxSpan int
+  Mask bool
+
+  TileLen func(path, step, varid, span int) int
+}
+
+// A filter that passes everything.
+//
+func NewKnotFilter() *KnotFilter {
+  return &KnotFilter{ MaxNocallFrac:-1.0 }
+}
+
+func (f *KnotFilter) Enabled() bool {
+  return f!=nil && (f.MaxNocallFrac>=0 || f.DropFinalOverflow || f.MaxSpan>0)
+}
+
+// Reason the knot anchored at anchor_step fails the filter, or "" if it
+// passes.
+//
+func (f *KnotFilter) Check(pathi PathIntermediate, path, anchor_step int, knot [][]TileInfo) (string, error) {
+  if !f.Enabled() || knot==nil { return "", nil }
+
+  if f.DropFinalOverflow && IsFinalOverflow(pathi, anchor_step) {
+    return "final-overflow", nil
+  }
+
+  if f.MaxSpan>0 {
+    for allele:=0; allele<len(knot); allele++ {
+      for i:=0; i<len(knot[allele]); i++ {
+        if knot[allele][i].Span > f.MaxSpan { return fmt.Sprintf("span>%d", f.MaxSpan), nil }
+      }
+    }
+  }
+
+  if f.MaxNocallFrac>=0 {
+    if f.TileLen==nil { return "", fmt.Errorf("nocall fraction filter needs tile lengths") }
+
+    for allele:=0; allele<len(knot); allele++ {
+      tot := 0
+      noc := 0
+      for i:=0; i<len(knot[allele]); i++ {
+        ti := knot[allele][i]
+        if ti.VarId<0 { continue }
+        n := f.TileLen(path, ti.Step, ti.VarId, ti.Span)
+        if n<0 { return "", fmt.Errorf("no length for tile %s", TileIdString(path, pathi.tagset, ti)) }
+        tot += n
+        for p:=1; p<len(ti.NocallStartLen); p+=2 { noc += ti.NocallStartLen[p] }
+      }
+      if tot>0 && (float64(noc)/float64(tot)) > f.MaxNocallFrac {
+        return fmt.Sprintf("nocall>%g", f.MaxNocallFrac), nil
+      }
+    }
+  }
+
+  return "", nil
+}
+
+// Returns the knot to use in place of `knot` (a masked copy if it fails
+// and Mask is set) and whether to keep it at all.
+//
+func (f *KnotFilter) Apply(pathi PathIntermediate, path, anchor_step int, knot [][]TileInfo) ([][]TileInfo, bool, error) {
+  reason,e := f.Check(pathi, path, anchor_step, knot)
+  if e!=nil { return nil, false, e }
+  if reason=="" { return knot, true, nil }
+  if !f.Mask { return nil, false, nil }
+  return MaskKnot(knot), true, nil
+}
+
+func MaskKnot(knot [][]TileInfo) [][]TileInfo {
+  masked := make([][]TileInfo, len(knot))
+  for allele:=0; allele<len(knot); allele++ {
+    for i:=0; i<len(knot[allele]); i++ {
+      ti := TileInfo{ Step:knot[allele][i].Step, Span:knot[allele][i].Span, VarId:KNOT_MASK_VARID }
+      masked[allele] = append(masked[allele], ti)
+    }
+  }
+  return masked
+}
+
+// Tile id as printed by `knot-2`: path.ver.step.varid+span, with "---"
+// in place of the variant id of a masked tile.
+//
+func TileIdString(path, ver int, ti TileInfo) string {
+  if ti.VarId==KNOT_MASK_VARID {
+    return fmt.Sprintf("%04x.%02x.%04x.---+%x", path, ver, ti.Step, ti.Span)
+  }
+  return fmt.Sprintf("%04x.%02x.%04x.%03x+%x", path, ver, ti.Step, ti.VarId, ti.Span)
+}
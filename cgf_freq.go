@@ -0,0 +1,186 @@
+package cgf
+
+import "fmt"
+import "sort"
+import "sync"
+
+// Tile variant at a step.  Masked alleles (see KnotFilter) are counted
+// under VarId KNOT_MASK_VARID.
+//
+type TileVariantKey struct {
+  Path int
+  Step int
+  VarId int
+  Span int
+}
+
+// Count is the number of alleles carrying the tile variant, Loq how many
+// of those have nocalls and Masked how many were masked by the filter.
+//
+type TileFreqCount struct {
+  Count int
+  Loq int
+  Masked int
+}
+
+type TileFreq map[TileVariantKey]*TileFreqCount
+
+func (tf TileFreq) add(key TileVariantKey, loq, masked bool) {
+  c,ok := tf[key]
+  if !ok {
+    c = &TileFreqCount{}
+    tf[key] = c
+  }
+  c.Count++
+  if loq { c.Loq++ }
+  if masked { c.Masked++ }
+}
+
+// Count the tile variants of every allele of the knots anchored in
+// [beg,end).  Knots dropped by filt (which may be nil) aren't counted.
+//
+func (tf TileFreq) AddPath(tilemap []TileMapEntry, pathi PathIntermediate, path, beg, end int, filt *KnotFilter) error {
+  return PathKnotScan(tilemap, pathi, beg, end, func(anchor_step int, knot [][]TileInfo) error {
+    knot,keep,e := filt.Apply(pathi, path, anchor_step, knot)
+    if e!=nil { return e }
+    if !keep { return nil }
+
+    for allele:=0; allele<len(knot); allele++ {
+      for i:=0; i<len(knot[allele]); i++ {
+        ti := knot[allele][i]
+        key := TileVariantKey{ Path:path, Step:ti.Step, VarId:ti.VarId, Span:ti.Span }
+        tf.add(key, len(ti.NocallStartLen)>0, ti.VarId==KNOT_MASK_VARID)
+      }
+    }
+    return nil
+  })
+}
+
+func (tf TileFreq) Merge(o TileFreq) {
+  for key,oc := range o {
+    c,ok := tf[key]
+    if !ok {
+      c = &TileFreqCount{}
+      tf[key] = c
+    }
+    c.Count += oc.Count
+    c.Loq += oc.Loq
+    c.Masked += oc.Masked
+  }
+}
+
+// Keys ordered by path, step, variant id and span.
+//
+func (tf TileFreq) SortedKeys() []TileVariantKey {
+  keys := make([]TileVariantKey, 0, len(tf))
+  for key := range tf { keys = append(keys, key) }
+  sort.Slice(keys, func(i, j int) bool {
+    a,b := keys[i], keys[j]
+    if a.Path!=b.Path { return a.Path<b.Path }
+    if a.Step!=b.Step { return a.Step<b.Step }
+    if a.VarId!=b.VarId { return a.VarId<b.VarId }
+    return a.Span<b.Span
+  })
+  return keys
+}
+
+// Total number of alleles with a tile anchored at each (path, step).
+//
+func (tf TileFreq) StepTotals() map[[2]int]int {
+  tot := make(map[[2]int]int)
+  for key,c := range tf {
+    tot[[2]int{key.Path, key.Step}] += c.Count
+  }
+  return tot
+}
+
+// Step ranges of a sample's paths to count, keyed by path.  Paths not in
+// the map are skipped, a nil map counts every step of every path.  Ends
+// of -1 or past the end of the path are closed at the end of the path.
+//
+type SampleRangeFunc func(hdri *HeaderIntermediate) (map[int][][2]int, error)
+
+// Tile variant frequencies over the CGFs in fns, nproc samples at a time.
+// Each sample's header is read first and then only the path blocks being
+// counted, one at a time.  ranges (nil for everything) picks the steps and
+// filt (which may be nil) drops knots.  Also returns the headers, without
+// path blocks, and the tagsets of the cohort's paths (see
+// MergePathTagsets).
+//
+func CohortFreq(fns []string, nproc int, ranges SampleRangeFunc, filt *KnotFilter) ([]*HeaderRef, TileFreq, map[int]int, error) {
+  hrefs := make([]*HeaderRef, len(fns))
+  freq := TileFreq{}
+  tagsets := make(map[int]int)
+
+  var lock sync.Mutex
+  var first_err error
+  var wg sync.WaitGroup
+
+  idx_ch := make(chan int)
+  for w:=0; w<nproc; w++ {
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+      for i := range idx_ch {
+        href,local_freq,e := _sample_freq(fns[i], ranges, filt)
+
+        lock.Lock()
+        if e==nil {
+          hrefs[i] = href
+          freq.Merge(local_freq)
+          e = MergePathTagsets(tagsets, &href.Header)
+        }
+        if e!=nil && first_err==nil { first_err = fmt.Errorf("%s: %v", fns[i], e) }
+        lock.Unlock()
+      }
+    }()
+  }
+
+  for i:=0; i<len(fns); i++ { idx_ch <- i }
+  close(idx_ch)
+  wg.Wait()
+
+  if first_err!=nil { return nil, nil, nil, first_err }
+  return hrefs, freq, tagsets, nil
+}
+
+func _sample_freq(fn string, ranges SampleRangeFunc, filt *KnotFilter) (*HeaderRef, TileFreq, error) {
+  f,e := StoreOpen(fn)
+  if e!=nil { return nil, nil, e }
+  defer f.Close()
+
+  href,e := ReadHeaderRef(f, f.Size())
+  if e!=nil { return nil, nil, e }
+  hdri := &href.Header
+
+  var sel map[int][][2]int
+  if ranges!=nil {
+    sel,e = ranges(hdri)
+    if e!=nil { return nil, nil, e }
+  }
+
+  tf := TileFreq{}
+  for path:=0; path<len(hdri.StepPerPath); path++ {
+    nstep := hdri.StepPerPath[path]
+    if nstep==0 { continue }
+
+    r := [][2]int{ [2]int{0, nstep} }
+    if sel!=nil {
+      var ok bool
+      if r,ok = sel[path] ; !ok { continue }
+    }
+
+    pathi,e := href.ReadPath(f, path)
+    if e!=nil { return nil, nil, e }
+
+    for i:=0; i<len(r); i++ {
+      beg,end := r[i][0],r[i][1]
+      if end<0 || end>nstep { end = nstep }
+      if beg>=end { continue }
+      e = tf.AddPath(hdri.TileMap, pathi, path, beg, end, filt)
+      if e!=nil { return nil, nil, e }
+    }
+  }
+
+  return href, tf, nil
+}
@@ -0,0 +1,70 @@
+package cgf_test
+
+import "testing"
+import "bytes"
+import "os"
+
+import "github.com/abeconnelly/cgf"
+import "github.com/abeconnelly/cgf/synth"
+
+// Cohort frequencies read a path at a time against the same counts over
+// the whole files in memory, and PLINK genotypes read the same way.
+//
+func TestCohortFreq(t *testing.T) {
+  p := synth.DefaultParams()
+  p.Paths = 3
+  p.Steps = 200
+  p.Samples = 3
+  res,cgf_bytes := _synth_fixture(t, p)
+
+  ranges := func(hdri *cgf.HeaderIntermediate) (map[int][][2]int, error) {
+    return map[int][][2]int{ 1:[][2]int{ {10, 40}, {150, -1} } }, nil
+  }
+
+  for _,sel := range []cgf.SampleRangeFunc{ nil, ranges } {
+    hrefs,got,_,e := cgf.CohortFreq(res.CGFFiles, 2, sel, nil)
+    if e!=nil { t.Fatal(e) }
+
+    want := cgf.TileFreq{}
+    for _,b := range cgf_bytes {
+      hdri := _synth_header(t, b)
+      for path:=0; path<p.Paths; path++ {
+        beg,end := []int{0},[]int{hdri.StepPerPath[path]}
+        if sel!=nil {
+          if path!=1 { continue }
+          beg,end = []int{10, 150},[]int{40, hdri.StepPerPath[path]}
+        }
+        pathi,e := cgf.HeaderIntermediateLoadPath(&hdri, path)
+        if e!=nil { t.Fatal(e) }
+        for i:=0; i<len(beg); i++ {
+          e = want.AddPath(hdri.TileMap, pathi, path, beg[i], end[i], nil)
+          if e!=nil { t.Fatal(e) }
+        }
+      }
+    }
+
+    if len(got)!=len(want) { t.Fatalf("%d tile variants, want %d", len(got), len(want)) }
+    for k,w := range want {
+      if g,ok := got[k] ; !ok || *g!=*w { t.Fatalf("%+v: %+v, want %+v", k, got[k], w) }
+    }
+
+    if sel!=nil { continue }
+
+    pg := cgf.NewPlinkGenotyper(cgf.PlinkMarkersFromFreq(got, map[int]int{}, 0.05), nil)
+    for i,fn := range res.CGFFiles {
+      hdri := _synth_header(t, cgf_bytes[i])
+      gw,e := pg.SampleGenotypes(&hdri)
+      if e!=nil { t.Fatal(e) }
+
+      f,e := os.Open(fn)
+      if e!=nil { t.Fatal(e) }
+      gr,e := pg.ReadSampleGenotypes(hrefs[i], f)
+      f.Close()
+      if e!=nil { t.Fatal(e) }
+      if !bytes.Equal(gw, gr) { t.Errorf("%s: genotypes read a path at a time differ", fn) }
+    }
+  }
+
+  _,_,_,e := cgf.CohortFreq(append([]string{}, res.CGFFiles[0], res.CGFFiles[0]+".missing"), 2, nil, nil)
+  if e==nil { t.Error("no error for a missing file") }
+}
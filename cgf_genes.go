@@ -0,0 +1,277 @@
+package cgf
+
+import "fmt"
+import "strings"
+import "strconv"
+import "github.com/abeconnelly/autoio"
+
+// Gene level report of a sample's tiles.
+//
+// Genes are read from a GFF3 or GTF file.  GFF3 genes are the "gene"
+// features (named by their Name attribute, or ID); GTF genes are the
+// extent of all features with the same gene_id (named by gene_name, or
+// gene_id).  Coordinates are taken to 0 based, half open intervals as
+// everywhere else.  Each gene is placed on the tiles overlapping it with
+// a tile assembly, and every step of those is classified by the knot of
+// the sample covering it:
+//
+//   noncanonical : a tile of some allele isn't the library's variant 0
+//   spanning     : a tile of some allele spans more than one step
+//   complex      : the knot is stored as a final overflow record
+//   loq          : a tile of some allele has nocalls
+//
+
+type Gene struct {
+  Id string
+  Name string
+  Chrom string
+  Beg int
+  End int
+  Strand string
+}
+
+type GeneStep struct {
+  Path int
+  Step int
+
+  // Knot covering the step and the step it's anchored at.
+  //
+  AnchorStep int
+  Knot [][]TileInfo
+
+  NonCanonical bool
+  Spanning bool
+  Complex bool
+  Loq bool
+}
+
+func (gs *GeneStep) Canonical() bool {
+  return !(gs.NonCanonical || gs.Spanning || gs.Complex || gs.Loq)
+}
+
+// Comma separated classes of the step, "canonical" if it has none.
+//
+func (gs *GeneStep) Flags() string {
+  f := []string{}
+  if gs.NonCanonical { f = append(f, "noncanonical") }
+  if gs.Spanning { f = append(f, "spanning") }
+  if gs.Complex { f = append(f, "complex") }
+  if gs.Loq { f = append(f, "loq") }
+  if len(f)==0 { return "canonical" }
+  return strings.Join(f, ",")
+}
+
+type GeneReport struct {
+  Gene Gene
+
+  // Tiles overlapping the gene, in the assembly's tagset, and the
+  // sample's steps in the file's tagset (nil without a sample).
+  //
+  Tiles []TileposRange
+  Steps []GeneStep
+
+  NonCanonical int
+  Spanning int
+  Complex int
+  Loq int
+}
+
+func _gff_attr(attr string, gtf bool) map[string]string {
+  m := make(map[string]string)
+  for _,kv := range strings.Split(attr, ";") {
+    kv = strings.TrimSpace(kv)
+    if len(kv)==0 { continue }
+
+    var k,v string
+    if gtf {
+      p := strings.IndexAny(kv, " \t")
+      if p<0 { continue }
+      k,v = kv[:p], strings.Trim(strings.TrimSpace(kv[p+1:]), "\"")
+    } else {
+      p := strings.Index(kv, "=")
+      if p<0 { continue }
+      k,v = kv[:p], kv[p+1:]
+    }
+    if _,ok := m[k] ; !ok { m[k] = v }
+  }
+  return m
+}
+
+// Genes of a GFF3 or GTF file, in file order.  Only the genes named in
+// `names` (by name or id) are kept if it isn't empty.
+//
+func LoadGenes(fn string, names []string) ([]Gene, error) {
+  scan,e := autoio.OpenReadScanner(fn)
+  if e!=nil { return nil, e }
+  defer scan.Close()
+
+  want := make(map[string]bool)
+  for _,n := range names { want[n] = true }
+
+  genes := []Gene{}
+  gtf_idx := make(map[string]int)
+  line_no := 0
+
+  for scan.ReadScan() {
+    l := scan.ReadText()
+    line_no++
+    if strings.HasPrefix(l, "##FASTA") { break }
+    if len(strings.TrimSpace(l))==0 || l[0]=='#' { continue }
+
+    fields := strings.Split(l, "\t")
+    if len(fields)!=9 { return nil, fmt.Errorf("%s: expected 9 tab separated fields (line %d)", fn, line_no) }
+
+    beg,e := strconv.Atoi(fields[3])
+    if e!=nil { return nil, fmt.Errorf("%s: %v (line %d)", fn, e, line_no) }
+    end,e := strconv.Atoi(fields[4])
+    if e!=nil { return nil, fmt.Errorf("%s: %v (line %d)", fn, e, line_no) }
+    if beg<1 || end<beg { return nil, fmt.Errorf("%s: invalid feature interval %d-%d (line %d)", fn, beg, end, line_no) }
+    beg--
+
+    gtf := strings.Contains(fields[8], "gene_id \"") || strings.Contains(fields[8], "gene_id\t\"")
+    attr := _gff_attr(fields[8], gtf)
+
+    if gtf {
+      id := attr["gene_id"]
+      if len(id)==0 { continue }
+
+      if i,ok := gtf_idx[id] ; ok {
+        if genes[i].Chrom!=fields[0] { return nil, fmt.Errorf("%s: gene %s on %s and %s (line %d)", fn, id, genes[i].Chrom, fields[0], line_no) }
+        if beg<genes[i].Beg { genes[i].Beg = beg }
+        if end>genes[i].End { genes[i].End = end }
+        continue
+      }
+
+      name := attr["gene_name"]
+      if len(name)==0 { name = id }
+      if len(want)>0 && !want[name] && !want[id] { continue }
+
+      gtf_idx[id] = len(genes)
+      genes = append(genes, Gene{ Id:id, Name:name, Chrom:fields[0], Beg:beg, End:end, Strand:fields[6] })
+      continue
+    }
+
+    if fields[2]!="gene" { continue }
+
+    id := attr["ID"]
+    name := attr["Name"]
+    if len(name)==0 { name = id }
+    if len(want)>0 && !want[name] && !want[id] { continue }
+
+    genes = append(genes, Gene{ Id:id, Name:name, Chrom:fields[0], Beg:beg, End:end, Strand:fields[6] })
+  }
+
+  return genes, nil
+}
+
+// Whether the knot anchored at step is stored as a final overflow
+// record.
+//
+func _step_final_overflow(pathi *PathIntermediate, step int) bool {
+  vec := pathi.VecUint64[step/32]
+  m := uint(step%32)
+  if (vec & (1<<(32+m))) == 0 { return false }
+
+  cache_counter := _vec_cache_count(vec, m)
+  if cache_counter < 8 {
+    hexit := int((vec >> (4*uint(cache_counter))) & 0xf)
+    if hexit < 0xd { return false }
+  }
+
+  ovf_pos := _overflow_pos(pathi, step)
+  if ovf_pos>=len(pathi.ofsi.span_flag) || pathi.ofsi.span_flag[ovf_pos] { return false }
+  return pathi.ofsi.final_overflow_flag[ovf_pos]
+}
+
+// Knot covering step and the step it's anchored at.
+//
+func _covering_knot(tilemap []TileMapEntry, pathi PathIntermediate, step int) (int, [][]TileInfo) {
+  for anchor:=step; anchor>=0; anchor-- {
+    knot := GetKnot(tilemap, pathi, anchor)
+    if knot!=nil { return anchor, knot }
+  }
+  return -1, nil
+}
+
+func _gene_step(tilemap []TileMapEntry, pathi *PathIntermediate, path, step int) GeneStep {
+  gs := GeneStep{ Path:path, Step:step }
+  gs.AnchorStep,gs.Knot = _covering_knot(tilemap, *pathi, step)
+  if gs.Knot==nil { return gs }
+
+  gs.Complex = _step_final_overflow(pathi, gs.AnchorStep)
+  for allele:=0; allele<len(gs.Knot); allele++ {
+    for _,ti := range gs.Knot[allele] {
+      if ti.Step>step || ti.Step+ti.Span<=step { continue }
+      if ti.VarId!=0 { gs.NonCanonical = true }
+      if ti.Span>1 { gs.Spanning = true }
+      if len(ti.NocallStartLen)>0 { gs.Loq = true }
+    }
+  }
+  return gs
+}
+
+type GeneReporter struct {
+  Assembly *TileAssembly
+
+  // Sample to classify the steps of (nil to list tiles only), with the
+  // tagset mapping to use if its tagset isn't the assembly's.
+  //
+  Header *HeaderIntermediate
+  TagsetMap *TagsetMap
+
+  paths map[int]*PathIntermediate
+}
+
+func (gr *GeneReporter) _chrom(chrom string) string {
+  for _,c := range gr.Assembly.PathChrom {
+    if c==chrom { return chrom }
+  }
+  alt := "chr" + chrom
+  if strings.HasPrefix(chrom, "chr") { alt = chrom[3:] }
+  for _,c := range gr.Assembly.PathChrom {
+    if c==alt { return alt }
+  }
+  return chrom
+}
+
+func (gr *GeneReporter) _path(path int) (*PathIntermediate, error) {
+  if gr.paths==nil { gr.paths = make(map[int]*PathIntermediate) }
+  if p,ok := gr.paths[path] ; ok { return p, nil }
+
+  if path>=len(gr.Header.StepPerPath) || gr.Header.StepPerPath[path]==0 { return nil, fmt.Errorf("path %04x not in the CGF", path) }
+  pathi,e := HeaderIntermediateLoadPath(gr.Header, path)
+  if e!=nil { return nil, e }
+  gr.paths[path] = &pathi
+  return &pathi, nil
+}
+
+func (gr *GeneReporter) Report(gene Gene) (GeneReport, error) {
+  rep := GeneReport{ Gene:gene }
+
+  tiles,e := gr.Assembly.TileposForRegion(gr._chrom(gene.Chrom), gene.Beg, gene.End)
+  if e!=nil { return rep, fmt.Errorf("gene %s: %v", gene.Name, e) }
+  rep.Tiles = tiles
+
+  if gr.Header==nil { return rep, nil }
+
+  rep.Steps = []GeneStep{}
+  for _,r := range tiles {
+    path,_,beg,end,e := HeaderIntermediateResolveRange(gr.Header, gr.TagsetMap, r.Path, gr.Assembly.Tagset, r.Beg, r.End)
+    if e!=nil { return rep, fmt.Errorf("gene %s: %v", gene.Name, e) }
+
+    pathi,e := gr._path(path)
+    if e!=nil { return rep, fmt.Errorf("gene %s: %v", gene.Name, e) }
+    if end>pathi.ntile { end = pathi.ntile }
+
+    for step:=beg; step<end; step++ {
+      gs := _gene_step(gr.Header.TileMap, pathi, path, step)
+      if gs.NonCanonical { rep.NonCanonical++ }
+      if gs.Spanning { rep.Spanning++ }
+      if gs.Complex { rep.Complex++ }
+      if gs.Loq { rep.Loq++ }
+      rep.Steps = append(rep.Steps, gs)
+    }
+  }
+
+  return rep, nil
+}
@@ -1,7 +1,6 @@
 //package main
 package cgf
 
-import "os"
 import "fmt"
 import "log"
 import "strings"
@@ -16,6 +15,13 @@ const CGF_DEFAULT_LIBRARY_VERSION string = "0.1.0"
 //const CGF_MAGIC uint64 = 0x7b226367662e6222
 var CGF_MAGIC []byte = []byte{ '"', 'c', 'g', 'f', '.', 'b', '"', '{' }
 
+// Header flags live in the top byte of the PathCount word, which no real
+// path count reaches.  Readers that don't know about them see an
+// impossible path count and reject the file rather than misread it.
+//
+const CGF_HEADER_FLAG_MASK uint64 = 0xff<<56
+const CGF_HEADER_FLAG_CHECKSUM uint64 = 1<<56
+
 type TileMapEntry struct {
   TileMap int
   Variant [][]int
@@ -24,12 +30,16 @@ type TileMapEntry struct {
 
 //func write_cgf_from_intermediate(ofn string, hdri *HeaderIntermediate) {
 func WriteCGFFromIntermediate(ofn string, hdri *HeaderIntermediate) {
+
+  e := HeaderIntermediateUpdateChecksum(hdri)
+  if e!=nil { log.Fatal(e) }
+
   //hdr_bytes := bytes_from_headerintermediate(*hdri)
   hdr_bytes := BytesFromHeaderIntermediate(*hdri)
 
 
   //f,err := os.Create("./okok.cgf")
-  f,err := os.Create(ofn)
+  f,err := StoreCreate(ofn)
   if err!=nil { log.Fatal(err) }
   f.Write(hdr_bytes)
 
@@ -42,10 +52,26 @@ func WriteCGFFromIntermediate(ofn string, hdri *HeaderIntermediate) {
     }
   }
 
+  f.Write(BytesFromHeaderExt(hdri.ext))
+
+  err = f.Close()
+  if err!=nil { log.Fatal(err) }
+
+}
+
+// Whole CGF file, as WriteCGFFromIntermediate writes it.
+//
+func CGFBytesFromIntermediate(hdri *HeaderIntermediate) ([]byte, error) {
+  e := HeaderIntermediateUpdateChecksum(hdri)
+  if e!=nil { return nil, e }
 
-  f.Sync()
-  f.Close()
+  b := BytesFromHeaderIntermediate(*hdri)
+  for i:=0; i<len(hdri.PathBytes); i++ {
+    b = append(b, hdri.PathBytes[i]...)
+  }
+  b = append(b, BytesFromHeaderExt(hdri.ext)...)
 
+  return b, nil
 }
 
 //func headerintermediate_add_path(hdri *headerintermediate, path int, PathBytes []byte) {
@@ -77,14 +103,15 @@ func HeaderIntermediateAddPath(hdri *HeaderIntermediate, path int, PathBytes []b
   //fmt.Printf(">> HeaderIntermediateAddPath len(hdri.StepPerPath) %v, path %v, pathi.ntile %v\n", len(hdri.StepPerPath), path, pathi.ntile)
 
   hdri.StepPerPath[path] = pathi.ntile
-  hdri.PathBytes[path] = PathBytes
-
-  prev_len := hdri.path_offset[path+1] - hdri.path_offset[path]
 
-  for idx:=path; idx<hdri.pathcount; idx++ {
-    hdri.path_offset[idx+1] += len(PathBytes) - prev_len
+  if HeaderIntermediatePathCompression(hdri, path)!=CGF_COMPRESS_NONE {
+    codes := _header_compression_codes(hdri)
+    codes[path] = CGF_COMPRESS_NONE
+    _header_set_compression_codes(hdri, codes)
   }
 
+  _header_intermediate_set_path_bytes(hdri, path, PathBytes)
+
 
   /*
   fmt.Printf(">>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>\n")
@@ -100,6 +127,40 @@ func HeaderIntermediateAddPath(hdri *HeaderIntermediate, path int, PathBytes []b
   */
 }
 
+// Replace the stored bytes of an existing path, shifting the offsets of
+// the paths after it.
+//
+func _header_intermediate_set_path_bytes(hdri *HeaderIntermediate, path int, PathBytes []byte) {
+  hdri.PathBytes[path] = PathBytes
+
+  prev_len := hdri.path_offset[path+1] - hdri.path_offset[path]
+
+  for idx:=path; idx<hdri.pathcount; idx++ {
+    hdri.path_offset[idx+1] += len(PathBytes) - prev_len
+  }
+}
+
+// Verify the path block (see HeaderIntermediateVerifyPath), inflate it if
+// it was stored compressed and decode it.
+//
+func HeaderIntermediateLoadPath(hdri *HeaderIntermediate, path int) (PathIntermediate, error) {
+  e := HeaderIntermediateVerifyPath(hdri, path)
+  if e!=nil { return PathIntermediate{}, e }
+
+  if len(hdri.PathBytes[path])==0 { return PathIntermediate{}, fmt.Errorf("path %x is empty", path) }
+
+  b,e := HeaderIntermediateRawPathBytes(hdri, path)
+  if e!=nil { return PathIntermediate{}, e }
+
+  pathi,_ := PathIntermediateFromBytes(b)
+  pathi.ploidy = HeaderIntermediatePathPloidy(hdri, path)
+  pathi.canon = HeaderIntermediatePathCanon(hdri, path)
+  pathi.phase = HeaderIntermediatePathPhase(hdri, path)
+  pathi.tagset = HeaderIntermediatePathTagset(hdri, path)
+  if pathi.tagset<0 { pathi.tagset = 0 }
+  return pathi, nil
+}
+
 //func unpack_tilemap(tilemap_bytes []byte) []TileMapEntry {
 func UnpackTileMap(tilemap_bytes []byte) []TileMapEntry {
   m := make([]TileMapEntry, 0, 1024)
@@ -340,7 +401,7 @@ func CGFFillHeader(cgf *CGF, b []byte) int {
   cgf.LibraryVersion,dn = byte2string(b[n:])
   n+=dn
 
-  cgf.PathCount = byte2uint64(b[n:])
+  cgf.PathCount = byte2uint64(b[n:]) &^ CGF_HEADER_FLAG_MASK
   n+=8
 
   cgf.TileMapLen = byte2uint64(b[n:])
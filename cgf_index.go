@@ -0,0 +1,474 @@
+package cgf
+
+import "fmt"
+import "os"
+import "sort"
+import "strings"
+import "strconv"
+
+import "github.com/abeconnelly/dlug"
+
+// Inverted index from tile variant to the samples carrying it.
+//
+// An index is a directory holding `samples.tsv` (sample number, name and
+// source file, one per line, in the order samples were added) and one
+// posting file per tile path, `<path>.idx`.  Samples are numbered in the
+// order they were added, so adding samples only appends to the posting
+// lists of the paths they touch and never needs the old CGFs again.
+//
+// Knots that are canonical (both alleles variant 0, span 1, no nocalls)
+// are not stored.  Instead each path keeps the samples that have it and
+// each step the samples that are *not* canonical there (including steps
+// inside a spanning tile), and carriers of variant 0 are filled in from
+// those at query time.
+//
+// Each path also records the tagset version its samples were encoded
+// against (see cgf_tagset.go).  Samples disagreeing with the index on a
+// path's tagset can't be added, and tile positions in another tagset are
+// resolved against it at query time.
+//
+// Posting file layout, all numbers dlug:
+//
+//   Magic [8]byte
+//   StepCount
+//   Present
+//   PostingCount
+//   { StepDelta, VarId, Span, Het, Hom, Loq } [PostingCount]
+//   NonCanonCount
+//   { StepDelta, NonCanon } [NonCanonCount]
+//   Tagset
+//
+// where each sample list (Present, Het, Hom, Loq, NonCanon) is a count followed by
+// delta coded sample numbers.  Postings are sorted by step then variant.
+// Tagset is one more than the tagset version, 0 if no sample recorded it,
+// and is missing from older index files.
+//
+
+var CGF_INDEX_MAGIC []byte = []byte{ '"', 'c', 'g', 'f', '.', 'i', '"', '{' }
+
+type TilePosting struct {
+  Span int
+  Het []int
+  Hom []int
+  Loq []int
+}
+
+// Postings are keyed by step then variant id, so the postings of a step
+// are a single lookup.
+//
+type PathIndex struct {
+  NStep int
+  Present []int
+  Post map[int]map[int]*TilePosting
+  NonCanon map[int][]int
+  Tagset int
+  dirty bool
+}
+
+type TileIndex struct {
+  Dir string
+  Samples []string
+  Sources []string
+  Path map[int]*PathIndex
+}
+
+type TileCarrier struct {
+  Sample int
+  Step int
+  VarId int
+  Span int
+  Hom bool
+  Loq bool
+}
+
+func _new_path_index() *PathIndex {
+  return &PathIndex{ Post:make(map[int]map[int]*TilePosting), NonCanon:make(map[int][]int), Tagset:-1 }
+}
+
+// Open the index in `dir`, creating an empty one if there is none.
+// Path postings are read as they are needed.
+//
+func LoadTileIndex(dir string) (*TileIndex, error) {
+  idx := TileIndex{ Dir:dir, Path:make(map[int]*PathIndex) }
+
+  b,e := StoreReadFile(StoreJoin(dir, "samples.tsv"))
+  if os.IsNotExist(e) { return &idx, nil }
+  if e!=nil { return nil, e }
+
+  lines := strings.Split(string(b), "\n")
+  for i:=0; i<len(lines); i++ {
+    if len(lines[i])==0 || lines[i][0]=='#' { continue }
+    f := strings.Split(lines[i], "\t")
+    if len(f)!=3 { return nil, fmt.Errorf("samples.tsv: invalid line %d", i+1) }
+
+    n,e := strconv.Atoi(f[0])
+    if e!=nil || n!=len(idx.Samples) { return nil, fmt.Errorf("samples.tsv: bad sample number on line %d", i+1) }
+
+    idx.Samples = append(idx.Samples, f[1])
+    idx.Sources = append(idx.Sources, f[2])
+  }
+
+  return &idx, nil
+}
+
+func (idx *TileIndex) HasSource(source string) bool {
+  for i:=0; i<len(idx.Sources); i++ {
+    if idx.Sources[i]==source { return true }
+  }
+  return false
+}
+
+func (idx *TileIndex) path_fn(path int) string {
+  return StoreJoin(idx.Dir, fmt.Sprintf("%04x.idx", path))
+}
+
+func (idx *TileIndex) LoadPath(path int) (*PathIndex, error) {
+  if pidx,ok := idx.Path[path] ; ok { return pidx, nil }
+
+  b,e := StoreReadFile(idx.path_fn(path))
+  if os.IsNotExist(e) {
+    pidx := _new_path_index()
+    idx.Path[path] = pidx
+    return pidx, nil
+  }
+  if e!=nil { return nil, e }
+
+  pidx,e := PathIndexFromBytes(b)
+  if e!=nil { return nil, fmt.Errorf("%s: %v", idx.path_fn(path), e) }
+  idx.Path[path] = pidx
+  return pidx, nil
+}
+
+func _append_sample_list(b []byte, l []int) []byte {
+  b = append(b, dlug.MarshalUint64(uint64(len(l)))...)
+  prv := 0
+  for i:=0; i<len(l); i++ {
+    b = append(b, dlug.MarshalUint64(uint64(l[i]-prv))...)
+    prv = l[i]
+  }
+  return b
+}
+
+func _sample_list_from_bytes(b []byte, n *int) []int {
+  cnt,dn := dlug.ConvertUint64(b[*n:])
+  *n+=dn
+
+  l := make([]int, int(cnt))
+  prv := 0
+  for i:=0; i<int(cnt); i++ {
+    d,dn := dlug.ConvertUint64(b[*n:])
+    *n+=dn
+    prv += int(d)
+    l[i] = prv
+  }
+  return l
+}
+
+func BytesFromPathIndex(pidx *PathIndex) []byte {
+  b := make([]byte, 0, 1024)
+  b = append(b, CGF_INDEX_MAGIC...)
+  b = append(b, dlug.MarshalUint64(uint64(pidx.NStep))...)
+  b = _append_sample_list(b, pidx.Present)
+
+  keys := make([][2]int, 0, len(pidx.Post))
+  for step,vp := range pidx.Post {
+    for varid := range vp { keys = append(keys, [2]int{step, varid}) }
+  }
+  sort.Slice(keys, func(i, j int) bool {
+    if keys[i][0]!=keys[j][0] { return keys[i][0]<keys[j][0] }
+    return keys[i][1]<keys[j][1]
+  })
+
+  b = append(b, dlug.MarshalUint64(uint64(len(keys)))...)
+  prv_step := 0
+  for i:=0; i<len(keys); i++ {
+    p := pidx.Post[keys[i][0]][keys[i][1]]
+    b = append(b, dlug.MarshalUint64(uint64(keys[i][0]-prv_step))...)
+    b = append(b, dlug.MarshalUint64(uint64(keys[i][1]))...)
+    b = append(b, dlug.MarshalUint64(uint64(p.Span))...)
+    b = _append_sample_list(b, p.Het)
+    b = _append_sample_list(b, p.Hom)
+    b = _append_sample_list(b, p.Loq)
+    prv_step = keys[i][0]
+  }
+
+  steps := make([]int, 0, len(pidx.NonCanon))
+  for s := range pidx.NonCanon { steps = append(steps, s) }
+  sort.Ints(steps)
+
+  b = append(b, dlug.MarshalUint64(uint64(len(steps)))...)
+  prv_step = 0
+  for i:=0; i<len(steps); i++ {
+    b = append(b, dlug.MarshalUint64(uint64(steps[i]-prv_step))...)
+    b = _append_sample_list(b, pidx.NonCanon[steps[i]])
+    prv_step = steps[i]
+  }
+
+  b = append(b, dlug.MarshalUint64(uint64(pidx.Tagset+1))...)
+
+  return b
+}
+
+func PathIndexFromBytes(b []byte) (pidx *PathIndex, err error) {
+  if len(b)<8 { return nil, fmt.Errorf("index file too short") }
+  for i:=0; i<8; i++ {
+    if b[i]!=CGF_INDEX_MAGIC[i] { return nil, fmt.Errorf("bad index magic") }
+  }
+
+  defer func() {
+    if r := recover() ; r!=nil {
+      pidx = nil
+      err = fmt.Errorf("corrupt index file")
+    }
+  }()
+
+  pidx = _new_path_index()
+  n := 8
+
+  v,dn := dlug.ConvertUint64(b[n:])
+  n+=dn
+  pidx.NStep = int(v)
+  pidx.Present = _sample_list_from_bytes(b, &n)
+
+  npost,dn := dlug.ConvertUint64(b[n:])
+  n+=dn
+
+  step := 0
+  for i:=0; i<int(npost); i++ {
+    v,dn = dlug.ConvertUint64(b[n:])
+    n+=dn
+    step += int(v)
+
+    varid,dn := dlug.ConvertUint64(b[n:])
+    n+=dn
+
+    span,dn := dlug.ConvertUint64(b[n:])
+    n+=dn
+
+    p := TilePosting{ Span:int(span) }
+    p.Het = _sample_list_from_bytes(b, &n)
+    p.Hom = _sample_list_from_bytes(b, &n)
+    p.Loq = _sample_list_from_bytes(b, &n)
+    pidx.add_posting(step, int(varid), &p)
+  }
+
+  nstep,dn := dlug.ConvertUint64(b[n:])
+  n+=dn
+
+  step = 0
+  for i:=0; i<int(nstep); i++ {
+    v,dn = dlug.ConvertUint64(b[n:])
+    n+=dn
+    step += int(v)
+    pidx.NonCanon[step] = _sample_list_from_bytes(b, &n)
+  }
+
+  if n<len(b) {
+    v,dn = dlug.ConvertUint64(b[n:])
+    n+=dn
+    pidx.Tagset = int(v)-1
+  }
+
+  return pidx, nil
+}
+
+func (pidx *PathIndex) add_posting(step, varid int, p *TilePosting) {
+  vp,ok := pidx.Post[step]
+  if !ok {
+    vp = make(map[int]*TilePosting)
+    pidx.Post[step] = vp
+  }
+  vp[varid] = p
+}
+
+func _knot_canonical(knot [][]TileInfo) bool {
+  for allele:=0; allele<len(knot); allele++ {
+    if len(knot[allele])!=1 { return false }
+    ti := knot[allele][0]
+    if ti.VarId!=0 || ti.Span!=1 || len(ti.NocallStartLen)>0 { return false }
+  }
+  return true
+}
+
+// Add the postings of one path of a sample.
+//
+func (pidx *PathIndex) AddKnots(sample int, tilemap []TileMapEntry, pathi PathIntermediate) error {
+  pidx.dirty = true
+  if pathi.ntile > pidx.NStep { pidx.NStep = pathi.ntile }
+  pidx.Present = append(pidx.Present, sample)
+
+  return PathKnotScan(tilemap, pathi, 0, pathi.ntile, func(anchor_step int, knot [][]TileInfo) error {
+    if _knot_canonical(knot) { return nil }
+
+    type var_count struct {
+      n int
+      loq bool
+      span int
+    }
+    counts := make(map[[2]int]*var_count)
+    order := make([][2]int, 0, 4)
+
+    end_step := anchor_step+1
+    for allele:=0; allele<len(knot); allele++ {
+      for i:=0; i<len(knot[allele]); i++ {
+        ti := knot[allele][i]
+        k := [2]int{ti.Step, ti.VarId}
+        vc,ok := counts[k]
+        if !ok {
+          vc = &var_count{ span:ti.Span }
+          counts[k] = vc
+          order = append(order, k)
+        }
+        vc.n++
+        if len(ti.NocallStartLen)>0 { vc.loq = true }
+        if (ti.Step+ti.Span) > end_step { end_step = ti.Step+ti.Span }
+      }
+    }
+
+    for i:=0; i<len(order); i++ {
+      vc := counts[order[i]]
+      p,ok := pidx.Post[order[i][0]][order[i][1]]
+      if !ok {
+        p = &TilePosting{ Span:vc.span }
+        pidx.add_posting(order[i][0], order[i][1], p)
+      }
+      if vc.n>=len(knot) {
+        p.Hom = append(p.Hom, sample)
+      } else {
+        p.Het = append(p.Het, sample)
+      }
+      if vc.loq { p.Loq = append(p.Loq, sample) }
+    }
+
+    for s:=anchor_step; s<end_step; s++ {
+      pidx.NonCanon[s] = append(pidx.NonCanon[s], sample)
+    }
+
+    return nil
+  })
+}
+
+// Add a sample.  The sample number is its position in idx.Samples.
+//
+func (idx *TileIndex) AddSample(name, source string, hdri *HeaderIntermediate) error {
+  sample := len(idx.Samples)
+
+  // Check every path before any posting is added.
+  //
+  for path:=0; path<len(hdri.StepPerPath); path++ {
+    if hdri.StepPerPath[path]==0 { continue }
+
+    pidx,e := idx.LoadPath(path)
+    if e!=nil { return e }
+
+    ver := HeaderIntermediatePathTagset(hdri, path)
+    if ver>=0 && pidx.Tagset>=0 && ver!=pidx.Tagset {
+      return fmt.Errorf("path %04x encoded against tagset %02x, index uses tagset %02x", path, ver, pidx.Tagset)
+    }
+  }
+
+  for path:=0; path<len(hdri.StepPerPath); path++ {
+    if hdri.StepPerPath[path]==0 { continue }
+
+    pathi,e := HeaderIntermediateLoadPath(hdri, path)
+    if e!=nil { return e }
+
+    pidx,e := idx.LoadPath(path)
+    if e!=nil { return e }
+
+    e = pidx.AddKnots(sample, hdri.TileMap, pathi)
+    if e!=nil { return e }
+
+    if ver := HeaderIntermediatePathTagset(hdri, path) ; ver>=0 { pidx.Tagset = ver }
+  }
+
+  idx.Samples = append(idx.Samples, name)
+  idx.Sources = append(idx.Sources, source)
+  return nil
+}
+
+// Write the sample list and every path that changed.
+//
+func (idx *TileIndex) Save() error {
+  paths := make([]int, 0, len(idx.Path))
+  for path := range idx.Path { paths = append(paths, path) }
+  sort.Ints(paths)
+
+  for i:=0; i<len(paths); i++ {
+    pidx := idx.Path[paths[i]]
+    if !pidx.dirty { continue }
+    e := StoreWriteFile(idx.path_fn(paths[i]), BytesFromPathIndex(pidx))
+    if e!=nil { return e }
+    pidx.dirty = false
+  }
+
+  lines := make([]string, 0, len(idx.Samples)+1)
+  lines = append(lines, "#sample\tname\tsource")
+  for i:=0; i<len(idx.Samples); i++ {
+    lines = append(lines, fmt.Sprintf("%d\t%s\t%s", i, idx.Samples[i], idx.Sources[i]))
+  }
+  return StoreWriteFile(StoreJoin(idx.Dir, "samples.tsv"), []byte(strings.Join(lines, "\n")+"\n"))
+}
+
+// Path, tagset version and step range in the index for the step range
+// [beg,end) given in tagset ver, as HeaderIntermediateResolveRange.
+//
+func (idx *TileIndex) ResolveRange(tmap *TagsetMap, path, ver, beg, end int) (int, int, int, int, error) {
+  return _resolve_range(func(p int) (int, error) {
+    pidx,e := idx.LoadPath(p)
+    if e!=nil { return -1, e }
+    return pidx.Tagset, nil
+  }, tmap, path, ver, beg, end)
+}
+
+func _sorted_has(l []int, v int) bool {
+  i := sort.SearchInts(l, v)
+  return i<len(l) && l[i]==v
+}
+
+// Carriers of tile variant `varid` at path/step, or of every variant at
+// the step if varid is negative.  Carriers of variant 0 include the
+// samples with the path that are canonical at the step, which are never
+// stored.
+//
+func (idx *TileIndex) Query(path, step, varid int) ([]TileCarrier, error) {
+  pidx,e := idx.LoadPath(path)
+  if e!=nil { return nil, e }
+
+  res := make([]TileCarrier, 0, 16)
+
+  vp := pidx.Post[step]
+  varids := make([]int, 0, len(vp))
+  if varid<0 {
+    for v := range vp { varids = append(varids, v) }
+    sort.Ints(varids)
+  } else if _,ok := vp[varid] ; ok {
+    varids = append(varids, varid)
+  }
+
+  for i:=0; i<len(varids); i++ {
+    p := vp[varids[i]]
+    for j:=0; j<len(p.Het); j++ {
+      res = append(res, TileCarrier{ Sample:p.Het[j], Step:step, VarId:varids[i], Span:p.Span, Loq:_sorted_has(p.Loq, p.Het[j]) })
+    }
+    for j:=0; j<len(p.Hom); j++ {
+      res = append(res, TileCarrier{ Sample:p.Hom[j], Step:step, VarId:varids[i], Span:p.Span, Hom:true, Loq:_sorted_has(p.Loq, p.Hom[j]) })
+    }
+  }
+
+  if varid<=0 {
+    noncanon := pidx.NonCanon[step]
+    for i:=0; i<len(pidx.Present); i++ {
+      s := pidx.Present[i]
+      if _sorted_has(noncanon, s) { continue }
+      res = append(res, TileCarrier{ Sample:s, Step:step, VarId:0, Span:1, Hom:true })
+    }
+  }
+
+  sort.SliceStable(res, func(i, j int) bool {
+    if res[i].VarId!=res[j].VarId { return res[i].VarId<res[j].VarId }
+    return res[i].Sample<res[j].Sample
+  })
+
+  return res, nil
+}
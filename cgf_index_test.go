@@ -0,0 +1,62 @@
+package cgf_test
+
+import "testing"
+
+import "github.com/abeconnelly/cgf"
+import "github.com/abeconnelly/cgf/synth"
+
+// The index keeps the tagset its samples were encoded against, refuses
+// samples in another tagset and resolves query positions against it.
+//
+func TestIndexTagset(t *testing.T) {
+  p := synth.DefaultParams()
+  p.Paths = 2
+  p.Steps = 100
+  p.Samples = 3
+  _,cgf_bytes := _synth_fixture(t, p)
+
+  hdris := make([]cgf.HeaderIntermediate, len(cgf_bytes))
+  for i:=0; i<len(cgf_bytes); i++ {
+    hdris[i] = _synth_header(t, cgf_bytes[i])
+    ver := 1
+    if i==2 { ver = 2 }
+    e := cgf.HeaderIntermediateSetPathTagset(&hdris[i], 0, ver)
+    if e!=nil { t.Fatal(e) }
+  }
+
+  dir := t.TempDir()
+  idx,e := cgf.LoadTileIndex(dir)
+  if e!=nil { t.Fatal(e) }
+  for i:=0; i<2; i++ {
+    e = idx.AddSample("s", "src", &hdris[i])
+    if e!=nil { t.Fatal(e) }
+  }
+  if e = idx.AddSample("s", "src", &hdris[2]) ; e==nil { t.Fatal("no error adding a sample in another tagset") }
+  if len(idx.Samples)!=2 { t.Fatalf("%d samples after a rejected add, want 2", len(idx.Samples)) }
+  if e = idx.Save() ; e!=nil { t.Fatal(e) }
+
+  idx,e = cgf.LoadTileIndex(dir)
+  if e!=nil { t.Fatal(e) }
+  pidx,e := idx.LoadPath(0)
+  if e!=nil { t.Fatal(e) }
+  if pidx.Tagset!=1 { t.Fatalf("path 0 tagset %d, want 1", pidx.Tagset) }
+
+  // Index files written before the tagset was recorded accept any tagset.
+  //
+  b := cgf.BytesFromPathIndex(pidx)
+  old,e := cgf.PathIndexFromBytes(b[:len(b)-1])
+  if e!=nil { t.Fatal(e) }
+  if old.Tagset!=-1 { t.Errorf("tagset %d without the field, want -1", old.Tagset) }
+
+  if _,_,_,_,e = idx.ResolveRange(nil, 0, 1, 5, 10) ; e!=nil { t.Error(e) }
+  if _,_,_,_,e = idx.ResolveRange(nil, 1, 0, 5, 10) ; e!=nil { t.Error(e) }
+  if _,_,_,_,e = idx.ResolveRange(nil, 0, 2, 5, 10) ; e==nil { t.Error("no error for a position in another tagset") }
+
+  tmap,e := cgf.TagsetMapFromBytes([]byte("0000.02.0000-0063  0000.01.0003\n"))
+  if e!=nil { t.Fatal(e) }
+  path,ver,beg,end,e := idx.ResolveRange(tmap, 0, 2, 5, 10)
+  if e!=nil { t.Fatal(e) }
+  if path!=0 || ver!=1 || beg!=8 || end!=13 {
+    t.Errorf("mapped to %04x.%02x.%04x-%04x, want 0000.01.0008-000d", path, ver, beg, end)
+  }
+}
@@ -2,7 +2,6 @@
 package cgf
 
 import "fmt"
-import "io/ioutil"
 import "strconv"
 import "strings"
 import "crypto/md5"
@@ -10,6 +9,8 @@ import "crypto/md5"
 import "io"
 import "os/exec"
 import "bytes"
+import "io/ioutil"
+import "compress/gzip"
 
 import "log"
 
@@ -26,57 +27,67 @@ func print_fold_seq(s string, w int) {
   }
 }
 
+// The library tarball and its index can be in any store (see
+// cgf_store.go).  The tarball is a BGZF stream, read front to back, so
+// members listed in increasing offset order are extracted in one pass.
+//
 //func populate_sglf_from_cglf(cglf_path string, sglf *SGLF, path uint64) error {
 func PopulateSGLFFromCGLF(cglf_path string, sglf *cglf.SGLF, path uint64) error {
   var e error
 
-  cglf_lib_fn := fmt.Sprintf("%s/%04x.tar.gz", cglf_path, path) ; _ = cglf_lib_fn
-  cglf_tai_fn := fmt.Sprintf("%s/%04x.tar.tai", cglf_path, path)
+  cglf_lib_fn := StoreJoin(cglf_path, fmt.Sprintf("%04x.tar.gz", path))
+  cglf_tai_fn := StoreJoin(cglf_path, fmt.Sprintf("%04x.tar.tai", path))
 
-  tai,err := ioutil.ReadFile(cglf_tai_fn)
+  tai,err := StoreReadFile(cglf_tai_fn)
   if err!=nil { return err }
 
+  lib,e := StoreOpen(cglf_lib_fn)
+  if e!=nil { return e }
+  defer lib.Close()
+
+  var tar io.Reader
+  tar_pos := int64(0)
+
   tai_lines := strings.Split(string(tai), "\n")
 
   for i:=0; i<len(tai_lines); i++ {
     tai_line_parts := strings.Split(tai_lines[i], " ")
 
     tar_fn := tai_line_parts[0]
-    tar_fn_b := tai_line_parts[1]
-    tar_fn_s := tai_line_parts[2]
-
     if len(tar_fn) <= 2 { continue; }
+    if len(tai_line_parts)<3 { return fmt.Errorf("%s: invalid index line %d", cglf_tai_fn, i+1) }
+
+    tar_fn_b,e := strconv.ParseInt(tai_line_parts[1], 10, 64)
+    if e!=nil { return fmt.Errorf("%s: %v (line %d)", cglf_tai_fn, e, i+1) }
+    tar_fn_s,e := strconv.ParseInt(tai_line_parts[2], 10, 64)
+    if e!=nil { return fmt.Errorf("%s: %v (line %d)", cglf_tai_fn, e, i+1) }
+
+    // Member bytes of the (uncompressed) tar stream, restarting the
+    // stream if the index goes backwards.
+    //
+    if tar==nil || tar_fn_b<tar_pos {
+      gz,e := gzip.NewReader(StoreSequentialReader(lib))
+      if e!=nil { return fmt.Errorf("%s: %v", cglf_lib_fn, e) }
+      tar,tar_pos = gz,0
+    }
+    _,e = io.CopyN(ioutil.Discard, tar, tar_fn_b-tar_pos)
+    if e!=nil { return fmt.Errorf("%s: %s: %v", cglf_lib_fn, tar_fn, e) }
+    member := make([]byte, tar_fn_s)
+    _,e = io.ReadFull(tar, member)
+    if e!=nil { return fmt.Errorf("%s: %s: %v", cglf_lib_fn, tar_fn, e) }
+    tar_pos = tar_fn_b+tar_fn_s
 
-    BGZIP := "/home/abram/bin/bgzip"
-
-    /*
-    fmt.Printf("%s -c -b %s -s %s %s %s\n", BGZIP, tar_fn_b, tar_fn_s, cglf_lib_fn, tar_fn)
-    fmt.Printf("gunzip -c -\n")
-    fmt.Printf("twoBitGulp -terse -w 0")
-    */
-
-    cmd0 := exec.Command(BGZIP, "-c", "-b", tar_fn_b, "-s", tar_fn_s, cglf_lib_fn, tar_fn)
-    cmd1 := exec.Command("gunzip", "-c", "-")
-    cmd2 := exec.Command("twoBitGulp", "-terse", "-w", "0")
+    member_gz,e := gzip.NewReader(bytes.NewReader(member))
+    if e!=nil { return fmt.Errorf("%s: %s: %v", cglf_lib_fn, tar_fn, e) }
 
-    cmd1.Stdin,_ = cmd0.StdoutPipe()
-    cmd2.Stdin,_ = cmd1.StdoutPipe()
+    cmd := exec.Command("twoBitGulp", "-terse", "-w", "0")
+    cmd.Stdin = member_gz
 
     var b bytes.Buffer
-    cmd2.Stdout = &b
-
-    e = cmd0.Start()
-    if e!=nil { panic(e); log.Fatal(e) }
-
-    e = cmd1.Start()
-    if e!=nil { panic(e) ;log.Fatal(e) }
+    cmd.Stdout = &b
 
-    e = cmd2.Start()
-    if e!=nil { panic(e) ;log.Fatal(e) }
-
-
-    e = cmd2.Wait()
-    if e!=nil { panic(e) ; log.Fatal(e) }
+    e = cmd.Run()
+    if e!=nil { return fmt.Errorf("%s: %s: %v", cglf_lib_fn, tar_fn, e) }
 
     fmt.Printf(">>>>>>>>>>>>>>>>>>\n%s\n", b.Bytes())
 
@@ -86,95 +97,40 @@ func PopulateSGLFFromCGLF(cglf_path string, sglf *cglf.SGLF, path uint64) error
 }
 
 
-func cglf_helper(fn, name string) []byte {
-  cmd0 := exec.Command("gunzip", "-c", fn)
-  cmd1 := exec.Command("twoBitGulp", "-name", name, "-no-header", "-terse", "-w", "0")
+// The gzipped 2bit file is read through the store and inflated here, so
+// only twoBitGulp has to be installed.
+//
+func cglf_helper(fn, name string) ([]byte, error) {
+  z,e := StoreReadFile(fn)
+  if e!=nil { return nil, e }
+
+  r,e := gzip.NewReader(bytes.NewReader(z))
+  if e!=nil { return nil, fmt.Errorf("%s: %v", fn, e) }
 
-  r,w := io.Pipe()
-  cmd0.Stdout = w
+  cmd1 := exec.Command("twoBitGulp", "-name", name, "-no-header", "-terse", "-w", "0")
   cmd1.Stdin = r
 
   var b bytes.Buffer
   cmd1.Stdout = &b
 
-  var e error
-
-  e = cmd0.Start()
-  if e!=nil { panic(e); log.Fatal(e) }
-
-  e = cmd1.Start()
-  if e!=nil { panic(e) ;log.Fatal(e) }
-
-  e = cmd0.Wait()
-  if e!=nil { panic(e) ; log.Fatal(e) }
-
-  e = w.Close()
-  if e!=nil { panic(e) ; log.Fatal(e) }
-
-  e = cmd1.Wait()
-  if e!=nil { panic(e) ; log.Fatal(e) }
-
-  return b.Bytes()
-}
-
-func handle_loq(cgf_bytes []byte, path, tagset_version, step int) {
-}
-
-func handle_overflow_cascade(cgf_bytes []byte, path, tagset_version, step uint64, cglf_path string) {
-
-  tile_map_entry,e := LookupTileMapEntry(cgf_bytes, int(path), 0, int(step))
-  if e!=nil {
-    log.Fatal( fmt.Sprintf("ERROR: %v: could not find overflow entry for path %d, ver %d, step %d\n", e, path, tagset_version, step) )
-  }
-
-  if tile_map_entry.TileMap<0 {
-    fmt.Printf("Final overflow (not implemented yet)\n")
-
-    /*
-    toi,e := CGFTileOverflowInformation(cgf_bytes, path, 0, step)
-    if e!=nil {
-      log.Fatal("ERROR: %v: could not find overflow entry for path %d, ver %d, step %d\n", e, path, tagset_version, step)
-    }
-    */
-
-  } else {
-
-    fmt.Printf(">>>> %04x.%02x.%04x --> %d (%x)\n", path, tagset_version, step, tile_map_entry, tile_map_entry)
-
-    /*
-    tme := tilemap[hexit]
-
-    for allele:=0; allele<2; allele++ {
-      cur_step := int(step)
-      for a:=0; a<len(tme.Variant[allele]); a++ {
-
-        seq := cglf_get_lib_seq(uint64(path), uint64(cur_step), uint64(tme.Variant[allele][a]), uint64(tme.Span[allele][a]), cglf_path)
-
-        m5str := md5sum2str(md5.Sum([]byte(seq)))
-        fmt.Printf("> { \"notes\":\"allele%d[%d] %d+%d\", \"md5sum\":\"%s\" }\n",
-          allele, a, tme.Variant[allele][a], tme.Span[allele][a], m5str)
-        print_fold_seq(seq, 50)
-        fmt.Printf("\n")
-        cur_step += tme.Span[allele][a]
-
-      }
-    }
-    */
-
-  }
+  e = cmd1.Run()
+  if e!=nil { return nil, fmt.Errorf("%s: %s: %v", fn, name, e) }
 
+  return b.Bytes(), nil
 }
 
-
 // bootstrap.  We will replace this with a more efficient lookup
 //
+// An error is returned if the tile isn't in the library.
+//
 //func cglf_get_lib_seq(path, step, varid, span uint64, cglf_path string) string {
-func CGLFGetLibSeq(path, step, varid, span uint64, cglf_path string) string {
+func CGLFGetLibSeq(path, step, varid, span uint64, cglf_path string) (string, error) {
   ver := 0
-  fn := fmt.Sprintf("%s/%04x/%04x.%02x.%04x.2bit.gz", cglf_path, path, path, ver, step)
+  fn := StoreJoin(cglf_path, fmt.Sprintf("%04x/%04x.%02x.%04x.2bit.gz", path, path, ver, step))
   name := fmt.Sprintf("%04x.%02x.%04x.%03x+%x", path, ver, step, varid, span)
-  seq := cglf_helper(fn, name)
-  return string(seq)
+  seq,e := cglf_helper(fn, name)
+  if e!=nil { return "", e }
+  return string(seq), nil
 }
 
 
@@ -203,7 +159,7 @@ func PrintTileCGLF(cgf_fn string, tilepos string, cglf_path string) error {
   if e!=nil { return e }
   ind++
 
-  cgf_bytes,e := ioutil.ReadFile(cgf_fn)
+  cgf_bytes,e := StoreReadFile(cgf_fn)
   if e!=nil { return e }
 
   return print_tile_cglf_i(cgf_bytes, path,ver,step, cglf_path)
@@ -229,8 +185,11 @@ func print_tile_cglf_i(cgf_bytes []byte, path,ver,step uint64, cglf_path string)
   if int(path) >= len(hdri.StepPerPath) { log.Fatal("path out of range (max ", len(hdri.StepPerPath), " paths)") }
   if int(step) >= hdri.StepPerPath[path] { log.Fatal("step out of range (max ", hdri.StepPerPath[path], " steps)") }
 
+  if _,_,_,e := HeaderIntermediateResolveTilepos(&hdri, nil, int(path), int(ver), int(step)) ; e!=nil { return e }
+
   //pathi,_ := pathintermediate_from_bytes(hdri.path_bytes[path])
-  pathi,_ := PathIntermediateFromBytes(hdri.PathBytes[path])
+  pathi,e := HeaderIntermediateLoadPath(&hdri, int(path))
+  if e!=nil { return e }
 
   //knot := get_knot(hdri.tilemap, pathi, int(step))
   knot := GetKnot(hdri.TileMap, pathi, int(step))
@@ -250,11 +209,12 @@ func print_tile_cglf_i(cgf_bytes []byte, path,ver,step uint64, cglf_path string)
         knot[i][j].Span)
 
       //seq := cglf_get_lib_seq(uint64(path),
-      seq := CGLFGetLibSeq(uint64(path),
+      seq,e := CGLFGetLibSeq(uint64(path),
                               uint64(knot[i][j].Step),
                               uint64(knot[i][j].VarId),
                               uint64(knot[i][j].Span),
                               cglf_path)
+      if e!=nil { return e }
 
 
       n := len(seq)
@@ -497,29 +457,27 @@ func PrintTileSGLF(cgf_fn string, tilepos string, sglf cglf.SGLF) error {
   if e!=nil { return e }
   ind++
 
-  cgf_bytes,e := ioutil.ReadFile(cgf_fn)
+  cgf_bytes,e := StoreReadFile(cgf_fn)
   if e!=nil { return e }
 
   //hdri,dn := headerintermediate_from_bytes(cgf_bytes) ; _ = hdri
   hdri,dn := HeaderIntermediateFromBytes(cgf_bytes) ; _ = hdri
   if dn<0 { return fmt.Errorf("could not construct header from bytes") }
 
-  //patho,dn := pathintermediate_from_bytes(hdri.path_bytes[path])
-  patho,dn := PathIntermediateFromBytes(hdri.PathBytes[path])
-  if dn<0 { return fmt.Errorf("could not construct path") }
+  if _,_,_,e := HeaderIntermediateResolveTilepos(&hdri, nil, int(path), int(ver), int(step)) ; e!=nil { return e }
 
-  tilemap_bytes,_ := CGFTilemapBytes(cgf_bytes)
-  //tilemap := unpack_tilemap(tilemap_bytes)
-  tilemap := UnpackTileMap(tilemap_bytes)
+  //patho,dn := pathintermediate_from_bytes(hdri.path_bytes[path])
+  patho,e := HeaderIntermediateLoadPath(&hdri, int(path))
+  if e!=nil { return e }
 
-  return print_tile_sglf_i(tilemap, patho.VecUint64, path,ver,step, sglf)
+  return print_tile_sglf_i(hdri.TileMap, patho.VecUint64, patho.canon, path,ver,step, sglf)
 
   //return print_tile_sglf_i(cgf_bytes, path,ver,step, sglf)
 
 }
 
 //func print_tile_sglf_i(cgf_bytes []byte, path,ver,step uint64, sglf SGLF) error {
-func print_tile_sglf_i(tilemap []TileMapEntry, path_vec []uint64, path,ver,step uint64, sglf cglf.SGLF) error {
+func print_tile_sglf_i(tilemap []TileMapEntry, path_vec []uint64, canon map[int]int, path,ver,step uint64, sglf cglf.SGLF) error {
 
   //path_vec,e := CGFVectorUint64(cgf_bytes, int(path)) ; _ = path_vec
   //if e!=nil { return e }
@@ -559,10 +517,11 @@ func print_tile_sglf_i(tilemap []TileMapEntry, path_vec []uint64, path,ver,step
         for allele:=0; allele<2; allele++ {
           cur_step := int(step)
           for a:=0; a<len(tme.Variant[allele]); a++ {
-            seq := sglf.Lib[int(path)][cur_step][tme.Variant[allele][a]]
+            varid := _canon_swap(canon, cur_step, tme.Variant[allele][a])
+            seq := sglf.Lib[int(path)][cur_step][varid]
             m5str := Md5sum2str(md5.Sum([]byte(seq)))
             fmt.Printf("> { \"notes\":\"allele%d[%d] %d+%d\", \"md5sum\":\"%s\" }\n",
-              allele, a, tme.Variant[allele][a], tme.Span[allele][a], m5str)
+              allele, a, varid, tme.Span[allele][a], m5str)
             print_fold_seq(seq, 50)
             fmt.Printf("\n")
             cur_step += tme.Span[allele][a]
@@ -584,7 +543,7 @@ func print_tile_sglf_i(tilemap []TileMapEntry, path_vec []uint64, path,ver,step
   } else {
     fmt.Printf("# Canonincal tile:\n")
 
-    seq := sglf.Lib[int(path)][int(step)][0]
+    seq := sglf.Lib[int(path)][int(step)][_canon_swap(canon, int(step), 0)]
     m5str := Md5sum2str(md5.Sum([]byte(seq)))
     fmt.Printf("> { \"md5sum\":\"%s\" }\n", m5str)
     print_fold_seq(seq, 50)
@@ -0,0 +1,234 @@
+package cgf
+
+import "fmt"
+import "sort"
+import "crypto/md5"
+
+import "github.com/abeconnelly/cglf"
+
+// Liftover of a CGF to a new release of its tile library.
+//
+// A library rebuild can renumber the variants of a step and insert new
+// ones.  Old and new variants of each step are matched by sequence (md5)
+// and every path is decoded against the old library and encoded again
+// against the new one, so the tile map, overflow and loq entries come out
+// as the encoder would have written them.  Paths and steps (the tagset)
+// have to be the same in both libraries.  Canon tables (see cgf_canon.go)
+// are carried over to the new variant ids.
+//
+
+type LiftoverMap struct {
+  // VarId[path][step][old varid] is the new varid, -1 if the variant
+  // is gone from the new library.
+  //
+  VarId map[int][][]int
+}
+
+// Ver is the tagset version of the path the tile was found in (0 from
+// Vanished, which only sees the libraries).
+//
+type LiftoverTile struct {
+  Path int
+  Ver int
+  Step int
+  VarId int
+  Span int
+}
+
+func NewLiftoverMap(old_sglf, new_sglf *cglf.SGLF) *LiftoverMap {
+  lm := LiftoverMap{ VarId:make(map[int][][]int) }
+
+  for path,steps := range old_sglf.Lib {
+    lm.VarId[path] = make([][]int, len(steps))
+
+    for step:=0; step<len(steps); step++ {
+      m5 := make(map[[16]byte]int)
+      if new_steps,ok := new_sglf.Lib[path] ; ok && step<len(new_steps) {
+        for v:=0; v<len(new_steps[step]); v++ {
+          m5[md5.Sum([]byte(new_steps[step][v]))] = v
+        }
+      }
+
+      lm.VarId[path][step] = make([]int, len(steps[step]))
+      for v:=0; v<len(steps[step]); v++ {
+        nv,ok := m5[md5.Sum([]byte(steps[step][v]))]
+        if !ok { nv = -1 }
+        lm.VarId[path][step][v] = nv
+      }
+    }
+  }
+
+  return &lm
+}
+
+// New varid of a tile, -1 if it's gone (or wasn't in the old library).
+//
+func (lm *LiftoverMap) Lookup(path, step, varid int) int {
+  steps,ok := lm.VarId[path]
+  if !ok || step<0 || step>=len(steps) || varid<0 || varid>=len(steps[step]) { return -1 }
+  return steps[step][varid]
+}
+
+// Old library variants without a match in the new library, sorted.
+//
+func (lm *LiftoverMap) Vanished() []LiftoverTile {
+  res := []LiftoverTile{}
+  for path,steps := range lm.VarId {
+    for step:=0; step<len(steps); step++ {
+      for v:=0; v<len(steps[step]); v++ {
+        if steps[step][v]<0 { res = append(res, LiftoverTile{ Path:path, Step:step, VarId:v }) }
+      }
+    }
+  }
+  _sort_liftover_tiles(res)
+  return res
+}
+
+func _sort_liftover_tiles(t []LiftoverTile) {
+  sort.Slice(t, func(i, j int) bool {
+    if t[i].Path!=t[j].Path { return t[i].Path < t[j].Path }
+    if t[i].Step!=t[j].Step { return t[i].Step < t[j].Step }
+    return t[i].VarId < t[j].VarId
+  })
+}
+
+// Allele paths of a decoded path with tiles renumbered and sequences and
+// tags taken from the new library, ready for EmitPathBytes.  Tiles whose
+// variant vanished are returned instead (with their old varid).  A nil lm
+// keeps the varids.
+//
+func _liftover_allele_path(tilemap []TileMapEntry, pathi PathIntermediate, path int, lm *LiftoverMap, new_sglf *cglf.SGLF) ([][]TileInfo, []LiftoverTile, error) {
+  nstep := pathi.ntile
+  ver := PathTagset(pathi)
+  var allele_path [][]TileInfo
+  vanished := []LiftoverTile{}
+  seen := make(map[LiftoverTile]bool)
+
+  e := PathKnotScan(tilemap, pathi, 0, nstep, func(anchor_step int, knot [][]TileInfo) error {
+    if allele_path==nil { allele_path = make([][]TileInfo, len(knot)) }
+    if len(knot)!=len(allele_path) { return fmt.Errorf("step %x: allele count changed (%d != %d)", anchor_step, len(knot), len(allele_path)) }
+
+    for allele:=0; allele<len(knot); allele++ {
+      for _,ti := range knot[allele] {
+        nv := ti.VarId
+        if lm!=nil { nv = lm.Lookup(path, ti.Step, ti.VarId) }
+        if nv<0 {
+          lt := LiftoverTile{ Path:path, Ver:ver, Step:ti.Step, VarId:ti.VarId, Span:ti.Span }
+          if !seen[lt] { vanished = append(vanished, lt) ; seen[lt] = true }
+          continue
+        }
+
+        if ti.Step>=len(new_sglf.Lib[path]) || nv>=len(new_sglf.Lib[path][ti.Step]) {
+          return fmt.Errorf("tile %s not in library", TileIdString(path, ver, TileInfo{ Step:ti.Step, VarId:nv, Span:ti.Span }))
+        }
+        seq := new_sglf.Lib[path][ti.Step][nv]
+        if len(seq)<TAG_LEN { return fmt.Errorf("tile %s shorter than tag", TileIdString(path, ver, TileInfo{ Step:ti.Step, VarId:nv, Span:ti.Span })) }
+
+        nti := TileInfo{ Step:ti.Step, VarId:nv, Span:new_sglf.LibInfo[path][ti.Step][nv].Span, Seq:seq,
+          NocallStartLen:ti.NocallStartLen }
+        if ti.Step>0 { nti.PfxTag = seq[:TAG_LEN] }
+        if ti.Step+nti.Span<nstep { nti.SfxTag = seq[len(seq)-TAG_LEN:] }
+
+        allele_path[allele] = append(allele_path[allele], nti)
+      }
+    }
+    return nil
+  })
+  if e!=nil { return nil, nil, e }
+
+  return allele_path, vanished, nil
+}
+
+// Canon table entries of the path with the new varids.  Entries whose
+// variant is gone, became variant 0 or can't be canonical in the new
+// library are dropped.
+//
+func _liftover_canon(canon map[int]int, path int, lm *LiftoverMap, new_sglf *cglf.SGLF) map[int]int {
+  if len(canon)==0 { return nil }
+
+  m := make(map[int]int)
+  for step,v := range canon {
+    nv := lm.Lookup(path, step, v)
+    if nv<=0 { continue }
+    if (CanonTable{ path:{ step:nv } }).Check(new_sglf)!=nil { continue }
+    m[step] = nv
+  }
+  return m
+}
+
+// Re-encode the paths of hdri against sglf, renumbering tiles with lm
+// (nil to keep them) and storing each path with its table from canon.
+//
+func _header_reencode(hdri *HeaderIntermediate, lm *LiftoverMap, sglf *cglf.SGLF, canon CanonTable) ([]LiftoverTile, error) {
+  ctx := CGFContext{}
+  _cgf := CGF{}
+  _cgf.PathBytes = make([][]byte, 0, 1024)
+  CGFFillHeader(&_cgf, BytesFromHeaderIntermediate(*hdri))
+  ctx.CGF = &_cgf
+  ctx.SGLF = sglf
+  ctx.CanonTable = canon
+  ctx.Phase = make(map[int]PathPhase)
+  for path:=0; path<hdri.pathcount; path++ {
+    ctx.Phase[path] = HeaderIntermediatePathPhase(hdri, path)
+  }
+  ctx.ConstructTileMapLookup()
+
+  path_bytes := make([][]byte, len(hdri.StepPerPath))
+  vanished := []LiftoverTile{}
+
+  for path:=0; path<len(hdri.StepPerPath); path++ {
+    if hdri.StepPerPath[path]==0 || len(hdri.PathBytes[path])==0 { continue }
+
+    pathi,e := HeaderIntermediateLoadPath(hdri, path)
+    if e!=nil { return nil, fmt.Errorf("path %x: %v", path, e) }
+
+    allele_path,v,e := _liftover_allele_path(hdri.TileMap, pathi, path, lm, sglf)
+    if e!=nil { return nil, fmt.Errorf("path %x: %v", path, e) }
+    if len(v)>0 || len(vanished)>0 {
+      vanished = append(vanished, v...)
+      continue
+    }
+
+    path_bytes[path],e = ctx.EmitPathBytes(path, allele_path)
+    if e!=nil { return nil, fmt.Errorf("path %x: %v", path, e) }
+  }
+
+  if len(vanished)>0 {
+    _sort_liftover_tiles(vanished)
+    return vanished, nil
+  }
+
+  for path:=0; path<len(path_bytes); path++ {
+    if path_bytes[path]==nil { continue }
+
+    code := HeaderIntermediatePathCompression(hdri, path)
+    HeaderIntermediateAddPath(hdri, path, path_bytes[path])
+    if code!=CGF_COMPRESS_NONE {
+      e := HeaderIntermediateCompressPath(hdri, path, code)
+      if e!=nil { return nil, e }
+    }
+
+    e := HeaderIntermediateSetPathCanon(hdri, path, canon[path])
+    if e!=nil { return nil, e }
+  }
+
+  return nil, nil
+}
+
+// Re-encode every path of hdri against new_sglf and set the library
+// version to libver (if given).  Tiles in use whose variant vanished from
+// the new library are returned; hdri is only changed if there are none.
+//
+func HeaderIntermediateLiftover(hdri *HeaderIntermediate, lm *LiftoverMap, new_sglf *cglf.SGLF, libver string) ([]LiftoverTile, error) {
+  canon := CanonTable{}
+  for path:=0; path<hdri.pathcount; path++ {
+    if m := _liftover_canon(HeaderIntermediatePathCanon(hdri, path), path, lm, new_sglf) ; len(m)>0 { canon[path] = m }
+  }
+
+  vanished,e := _header_reencode(hdri, lm, new_sglf, canon)
+  if e!=nil || len(vanished)>0 { return vanished, e }
+
+  if len(libver)>0 { hdri.libver = libver }
+
+  return nil, nil
+}
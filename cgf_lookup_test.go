@@ -0,0 +1,110 @@
+package cgf_test
+
+import "testing"
+import "math/rand"
+
+import "github.com/abeconnelly/cgf"
+
+func _lookup_fixture(tb testing.TB) (cgf.HeaderIntermediate, cgf.PathIntermediate) {
+  hdri := _synth_header(tb, _compress_fixture(tb))
+  pathi,e := cgf.HeaderIntermediateLoadPath(&hdri, 0)
+  if e!=nil { tb.Fatal(e) }
+  return hdri, pathi
+}
+
+// Random access knots (found through the overflow rank table) against
+// the sequential scan.
+//
+func TestGetKnotScanAgree(t *testing.T) {
+  hdri,pathi := _lookup_fixture(t)
+  nstep := hdri.StepPerPath[0]
+
+  scanned := make(map[int][][]cgf.TileInfo)
+  e := cgf.PathKnotScan(hdri.TileMap, pathi, 0, nstep, func(anchor_step int, knot [][]cgf.TileInfo) error {
+    scanned[anchor_step] = knot
+    return nil
+  })
+  if e!=nil { t.Fatal(e) }
+  if len(scanned)==0 { t.Fatal("no knots scanned") }
+
+  for step:=0; step<nstep; step++ {
+    got := cgf.GetKnot(hdri.TileMap, pathi, step)
+    want,ok := scanned[step]
+    if !ok {
+      if got!=nil { t.Fatalf("step %04x: knot on a spanned step", step) }
+      continue
+    }
+
+    if len(got)!=len(want) { t.Fatalf("step %04x: %d alleles, want %d", step, len(got), len(want)) }
+    for a:=0; a<len(want); a++ {
+      if len(got[a])!=len(want[a]) { t.Fatalf("step %04x allele %d: %d tiles, want %d", step, a, len(got[a]), len(want[a])) }
+      for i:=0; i<len(want[a]); i++ {
+        g,w := got[a][i],want[a][i]
+        if g.Step!=w.Step || g.VarId!=w.VarId || g.Span!=w.Span || len(g.NocallStartLen)!=len(w.NocallStartLen) {
+          t.Fatalf("step %04x allele %d tile %d: %+v, want %+v", step, a, i, g, w)
+        }
+      }
+    }
+  }
+}
+
+// Knots of a path without the rank tables (one not built by
+// PathIntermediateFromBytes) against the same path with them.
+//
+func TestGetKnotWithoutRank(t *testing.T) {
+  hdri,pathi := _lookup_fixture(t)
+  bare := cgf.PathIntermediateWithoutRank(pathi)
+
+  nfof := 0
+  for step:=0; step<hdri.StepPerPath[0]; step++ {
+    want := cgf.GetKnot(hdri.TileMap, pathi, step)
+    got := cgf.GetKnot(hdri.TileMap, bare, step)
+    if len(got)!=len(want) { t.Fatalf("step %04x: %d alleles, want %d", step, len(got), len(want)) }
+    for a:=0; a<len(want); a++ {
+      if len(got[a])!=len(want[a]) { t.Fatalf("step %04x allele %d: %d tiles, want %d", step, a, len(got[a]), len(want[a])) }
+      for i:=0; i<len(want[a]); i++ {
+        g,w := got[a][i],want[a][i]
+        if g.Step!=w.Step || g.VarId!=w.VarId || g.Span!=w.Span || len(g.NocallStartLen)!=len(w.NocallStartLen) {
+          t.Fatalf("step %04x allele %d tile %d: %+v, want %+v", step, a, i, g, w)
+        }
+      }
+    }
+
+    if cgf.IsFinalOverflow(bare, step)!=cgf.IsFinalOverflow(pathi, step) { t.Fatalf("step %04x: final overflow differs", step) }
+    if cgf.IsFinalOverflow(pathi, step) { nfof++ }
+  }
+  if nfof==0 { t.Error("no final overflow steps in the fixture") }
+}
+
+// Single knot lookup at random steps of a loaded path.
+//
+func BenchmarkGetKnot(b *testing.B) {
+  hdri,pathi := _lookup_fixture(b)
+
+  steps := make([]int, 1024)
+  for i:=0; i<len(steps); i++ { steps[i] = rand.Intn(hdri.StepPerPath[0]) }
+
+  b.ResetTimer()
+  for i:=0; i<b.N; i++ {
+    cgf.GetKnot(hdri.TileMap, pathi, steps[i%len(steps)])
+  }
+}
+
+// Every knot of a 256 step range at a random start.
+//
+func BenchmarkPathKnotScan(b *testing.B) {
+  hdri,pathi := _lookup_fixture(b)
+  nstep := hdri.StepPerPath[0]
+
+  begs := make([]int, 1024)
+  for i:=0; i<len(begs); i++ { begs[i] = rand.Intn(nstep-256) }
+
+  nop := func(anchor_step int, knot [][]cgf.TileInfo) error { return nil }
+
+  b.ResetTimer()
+  for i:=0; i<b.N; i++ {
+    beg := begs[i%len(begs)]
+    e := cgf.PathKnotScan(hdri.TileMap, pathi, beg, beg+256, nop)
+    if e!=nil { b.Fatal(e) }
+  }
+}
@@ -2,6 +2,7 @@
 package cgf
 
 import _ "fmt"
+import "sort"
 
 func _skip_fofsi(vi []int) int {
   pos := 0
@@ -83,12 +84,7 @@ func GetSimpleTileMapEntry(tilemap []TileMapEntry, pathi PathIntermediate, ancho
     return 0, false, false, false
   }
 
-  cache_counter := 0
-  for i:=0; i<m; i++ {
-    if (pathi.VecUint64[vec_slice] & (1<<uint(32+i))) != 0 {
-      cache_counter++
-    }
-  }
+  cache_counter := _vec_cache_count(pathi.VecUint64[vec_slice], uint(m))
 
   hexit:=0
   if (cache_counter < 8) {
@@ -100,7 +96,7 @@ func GetSimpleTileMapEntry(tilemap []TileMapEntry, pathi PathIntermediate, ancho
   loq_flag := false ; _ = loq_flag
   if hexit == 0xe { loq_flag = true }
 
-  ovf_pos := CountOverflowVectorUint64(pathi.VecUint64, 0, anchor_step)
+  ovf_pos := _overflow_pos(&pathi, anchor_step)
 
   if pathi.ofsi.span_flag[ovf_pos] {
     return -1, true, false, false
@@ -120,8 +116,23 @@ func GetSimpleTileMapEntry(tilemap []TileMapEntry, pathi PathIntermediate, ancho
   return -1, loq_flag, false, false
 }
 
-//func get_knot(tilemap []TileMapEntry, pathi pathintermediate, anchor_step int) [][]TileInfo {
+// True if the knot anchored at anchor_step is stored in the final
+// overflow section.  Final overflow records are in step order.
+//
+func IsFinalOverflow(pathi PathIntermediate, anchor_step int) bool {
+  i := sort.SearchInts(pathi.fofsi.tilepos, anchor_step)
+  return i<len(pathi.fofsi.tilepos) && pathi.fofsi.tilepos[i]==anchor_step
+}
+
+// Knot anchored at anchor_step, one tile list per allele, or nil if the
+// step is in the middle of a spanning knot.
+//
 func GetKnot(tilemap []TileMapEntry, pathi PathIntermediate, anchor_step int) [][]TileInfo {
+  return _knot_ploidy(&pathi, _knot_canon(&pathi, _get_knot(tilemap, pathi, anchor_step)))
+}
+
+//func get_knot(tilemap []TileMapEntry, pathi pathintermediate, anchor_step int) [][]TileInfo {
+func _get_knot(tilemap []TileMapEntry, pathi PathIntermediate, anchor_step int) [][]TileInfo {
   tia := make([][]TileInfo, 2)
   tia[0] = make([]TileInfo, 0, 1)
   tia[1] = make([]TileInfo, 0, 1)
@@ -142,12 +153,7 @@ func GetKnot(tilemap []TileMapEntry, pathi PathIntermediate, anchor_step int) []
     return tia
   }
 
-  cache_counter := 0
-  for i:=0; i<m; i++ {
-    if (pathi.VecUint64[vec_slice] & (1<<uint(32+i))) != 0 {
-      cache_counter++
-    }
-  }
+  cache_counter := _vec_cache_count(pathi.VecUint64[vec_slice], uint(m))
 
   hexit:=0
   if (cache_counter < 8) {
@@ -177,7 +183,7 @@ func GetKnot(tilemap []TileMapEntry, pathi PathIntermediate, anchor_step int) []
   loq_flag := false ; _ = loq_flag
   if hexit == 0xe { loq_flag = true }
 
-  ovf_pos := CountOverflowVectorUint64(pathi.VecUint64, 0, anchor_step)
+  ovf_pos := _overflow_pos(&pathi, anchor_step)
 
   if pathi.ofsi.span_flag[ovf_pos] { return nil }
 
@@ -208,31 +214,24 @@ func GetKnot(tilemap []TileMapEntry, pathi PathIntermediate, anchor_step int) []
 
   if pathi.loqi.loq_flag[anchor_step] { loq_flag = true }
 
-  cur_pos := 0
-  for i:=0; i<len(pathi.fofsi.tilepos); i++ {
-    if pathi.fofsi.tilepos[i] == anchor_step {
-      knot,_ := _fofsi_knot(pathi.fofsi.variant_ints[cur_pos:])
+  cur_pos,ok := _fofsi_pos(&pathi, anchor_step)
+  if !ok { return nil }
 
-      for allele:=0; allele<2; allele++ {
-        run_span:=0
-        for i:=0; i<len(knot.varid[allele]); i++ {
-          ti:=TileInfo{}
-          ti.Step = anchor_step+run_span
-          ti.Span = knot.span[allele][i]
-          ti.VarId = knot.varid[allele][i]
-          tia[allele] = append(tia[allele], ti)
+  knot,_ := _fofsi_knot(pathi.fofsi.variant_ints[cur_pos:])
 
-          run_span += ti.Span
-        }
-      }
+  for allele:=0; allele<2; allele++ {
+    run_span:=0
+    for i:=0; i<len(knot.varid[allele]); i++ {
+      ti:=TileInfo{}
+      ti.Step = anchor_step+run_span
+      ti.Span = knot.span[allele][i]
+      ti.VarId = knot.varid[allele][i]
+      tia[allele] = append(tia[allele], ti)
 
-      _fill_knot_loq(tia, pathi, anchor_step)
-      return tia
+      run_span += ti.Span
     }
-
-    dn := _skip_fofsi(pathi.fofsi.variant_ints[cur_pos:])
-    cur_pos += dn
   }
 
-  return nil
+  _fill_knot_loq(tia, pathi, anchor_step)
+  return tia
 }
@@ -0,0 +1,108 @@
+package cgf
+
+import "fmt"
+import "sort"
+
+import "github.com/abeconnelly/dlug"
+
+// Metadata extension record (CGF_EXT_META):
+//
+//   Count dlug
+//   { KeyLen dlug, Key [KeyLen]byte, ValLen dlug, Val [ValLen]byte } [Count]
+//
+// Keys are kept sorted so the record is deterministic.  Conventional keys
+// written by the encoder are listed below; anything else is free-form.
+//
+// The record is part of the extension trailer, so it is carried through
+// append (paths replaced in place) and through bundles, where samples are
+// merged into one file and extracted, or read a path at a time, with their
+// trailer as it was added.
+//
+
+const CGF_EXT_META int = 3
+
+const CGF_META_SAMPLE_ID string = "sample-id"
+const CGF_META_CREATION_TIME string = "creation-time"
+const CGF_META_ENCODER_VERSION string = "encoder-version"
+const CGF_META_LIBRARY string = "library"
+const CGF_META_LIBRARY_MD5 string = "library-md5"
+
+// Per path keys, e.g. "path.02c5.fastj-md5"
+//
+func MetaPathKey(path int, key string) string {
+  return fmt.Sprintf("path.%04x.%s", path, key)
+}
+
+func HeaderIntermediateMeta(hdri *HeaderIntermediate) (map[string]string, error) {
+  meta := make(map[string]string)
+
+  b,ok := HeaderIntermediateGetExt(hdri, CGF_EXT_META)
+  if !ok { return meta, nil }
+
+  n:=0
+  count,dn := dlug.ConvertUint64(b[n:])
+  if dn<=0 { return nil, fmt.Errorf("bad metadata record") }
+  n+=dn
+
+  for i:=0; i<int(count); i++ {
+    kl,dn := dlug.ConvertUint64(b[n:])
+    if dn<=0 || (n+dn+int(kl))>len(b) { return nil, fmt.Errorf("bad metadata record") }
+    n+=dn
+    k := string(b[n:n+int(kl)])
+    n+=int(kl)
+
+    vl,dn := dlug.ConvertUint64(b[n:])
+    if dn<=0 || (n+dn+int(vl))>len(b) { return nil, fmt.Errorf("bad metadata record") }
+    n+=dn
+    v := string(b[n:n+int(vl)])
+    n+=int(vl)
+
+    meta[k] = v
+  }
+
+  return meta, nil
+}
+
+func HeaderIntermediateSetMetaMap(hdri *HeaderIntermediate, meta map[string]string) {
+  if len(meta)==0 {
+    HeaderIntermediateSetExt(hdri, CGF_EXT_META, nil)
+    return
+  }
+
+  keys := make([]string, 0, len(meta))
+  for k := range meta { keys = append(keys, k) }
+  sort.Strings(keys)
+
+  b := make([]byte, 0, 1024)
+  b = append(b, dlug.MarshalUint64(uint64(len(keys)))...)
+  for i:=0; i<len(keys); i++ {
+    b = append(b, dlug.MarshalUint64(uint64(len(keys[i])))...)
+    b = append(b, []byte(keys[i])...)
+    b = append(b, dlug.MarshalUint64(uint64(len(meta[keys[i]])))...)
+    b = append(b, []byte(meta[keys[i]])...)
+  }
+  HeaderIntermediateSetExt(hdri, CGF_EXT_META, b)
+}
+
+func HeaderIntermediateGetMeta(hdri *HeaderIntermediate, key string) (string, bool) {
+  meta,e := HeaderIntermediateMeta(hdri)
+  if e!=nil { return "", false }
+  v,ok := meta[key]
+  return v, ok
+}
+
+func HeaderIntermediateSetMeta(hdri *HeaderIntermediate, key, val string) error {
+  meta,e := HeaderIntermediateMeta(hdri)
+  if e!=nil { return e }
+  meta[key] = val
+  HeaderIntermediateSetMetaMap(hdri, meta)
+  return nil
+}
+
+func HeaderIntermediateDelMeta(hdri *HeaderIntermediate, key string) error {
+  meta,e := HeaderIntermediateMeta(hdri)
+  if e!=nil { return e }
+  delete(meta, key)
+  HeaderIntermediateSetMetaMap(hdri, meta)
+  return nil
+}
@@ -0,0 +1,69 @@
+package cgf_test
+
+import "testing"
+import "bytes"
+
+import "github.com/abeconnelly/cgf"
+
+func _meta_equal(tb testing.TB, what string, hdri *cgf.HeaderIntermediate, want map[string]string) {
+  tb.Helper()
+
+  got,e := cgf.HeaderIntermediateMeta(hdri)
+  if e!=nil { tb.Fatalf("%s: %v", what, e) }
+  if len(got)!=len(want) { tb.Fatalf("%s: %d meta keys, want %d", what, len(got), len(want)) }
+  for k,v := range want {
+    if got[k]!=v { tb.Errorf("%s: meta %s is %q, want %q", what, k, got[k], v) }
+  }
+}
+
+// The metadata record survives a path being appended and samples being
+// merged into, and extracted from, a bundle.
+//
+func TestMetaPreserved(t *testing.T) {
+  res,cgf_bytes,_ := _bundle_fixture(t, 2)
+
+  hdri := _synth_header(t, cgf_bytes[0])
+  e := cgf.HeaderIntermediateSetMeta(&hdri, "foo", "bar")
+  if e!=nil { t.Fatal(e) }
+  want,e := cgf.HeaderIntermediateMeta(&hdri)
+  if e!=nil { t.Fatal(e) }
+  if want[cgf.CGF_META_SAMPLE_ID]!=res.Samples[0] { t.Fatalf("sample id %q, want %q", want[cgf.CGF_META_SAMPLE_ID], res.Samples[0]) }
+
+  // Replace path 1 with the other sample's and add it again as a new
+  // path past the end.
+  //
+  other := _synth_header(t, cgf_bytes[1])
+  path_bytes,e := cgf.HeaderIntermediateRawPathBytes(&other, 1)
+  if e!=nil { t.Fatal(e) }
+  cgf.HeaderIntermediateAddPath(&hdri, 1, path_bytes)
+  cgf.HeaderIntermediateAddPath(&hdri, 3, path_bytes)
+
+  b,e := cgf.CGFBytesFromIntermediate(&hdri)
+  if e!=nil { t.Fatal(e) }
+  appended := _synth_header(t, b)
+  _meta_equal(t, "append", &appended, want)
+
+  bi := cgf.BundleIntermediate{}
+  e = cgf.BundleIntermediateAddCGF(&bi, "appended", b)
+  if e!=nil { t.Fatal(e) }
+  e = cgf.BundleIntermediateAddCGF(&bi, res.Samples[1], cgf_bytes[1])
+  if e!=nil { t.Fatal(e) }
+  bundle_bytes := cgf.BytesFromBundleIntermediate(bi)
+
+  bi,e = cgf.BundleIntermediateFromBytes(bundle_bytes)
+  if e!=nil { t.Fatal(e) }
+  xb,e := cgf.BundleIntermediateCGFBytes(&bi, cgf.BundleIntermediateSampleIndex(&bi, "appended"))
+  if e!=nil { t.Fatal(e) }
+  extracted := _synth_header(t, xb)
+  _meta_equal(t, "bundle extract", &extracted, want)
+
+  bref,e := cgf.ReadBundleRef(bytes.NewReader(bundle_bytes), int64(len(bundle_bytes)))
+  if e!=nil { t.Fatal(e) }
+  hdris,e := bref.ReadPathHeaders(bytes.NewReader(bundle_bytes), 3)
+  if e!=nil { t.Fatal(e) }
+  _meta_equal(t, "bundle path read", &hdris[0], want)
+
+  other_meta,e := cgf.HeaderIntermediateMeta(&other)
+  if e!=nil { t.Fatal(e) }
+  _meta_equal(t, "bundle path read, second sample", &hdris[1], other_meta)
+}
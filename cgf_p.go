@@ -480,6 +480,17 @@ func Peel(cgf_bytes []byte, path, step int) {
 
   path_bytes := path_b[path_b_s:]
 
+  // Compressed paths are inflated so the path header and vector below
+  // are read from the raw layout.
+  //
+  hdri,hdr_dn := HeaderIntermediateFromBytes(cgf_bytes)
+  if hdr_dn<0 { fmt.Printf("could not construct header from bytes\n") ; return }
+  if path<0 || path>=len(hdri.PathBytes) { fmt.Printf("path %x out of range\n", path) ; return }
+  if e := HeaderIntermediateVerifyPath(&hdri, path) ; e!=nil { fmt.Printf("%v\n", e) ; return }
+  raw,e := HeaderIntermediateRawPathBytes(&hdri, path)
+  if e!=nil { fmt.Printf("%v\n", e) ; return }
+  path_bytes = raw
+
   //DEBUG
   //
   fmt.Printf(">>> path %x, s %x, e %x\n", path, path_b_s, -1)
@@ -793,10 +804,7 @@ func CacheMapVal(vec_val uint64, offset uint) int {
   canon_bit := (vec_val&(1<<(32+uint(offset))))
   if canon_bit==0 { return 0 }
 
-  count:=uint(0)
-  for i:=uint(0); i<offset; i++ {
-    if vec_val&(1<<(32+i)) != 0 { count++ }
-  }
+  count := uint(_vec_cache_count(vec_val, offset))
 
   //fmt.Printf("... %8x %8x\n", vec_val>>32, vec_val & 0xffffffff )
 
@@ -0,0 +1,226 @@
+package cgf
+
+import "fmt"
+import "sort"
+import "bufio"
+import "bytes"
+import "strings"
+
+import "github.com/abeconnelly/dlug"
+
+// Phase extension record (CGF_EXT_PHASE):
+//
+//   PathCount dlug
+//   { Phase dlug, BlockCount dlug, BlockStepDelta [BlockCount]dlug } [PathCount]
+//
+// Phase is one of the CGF_PHASE_* values below.  Files without the
+// record, and paths past PathCount, are CGF_PHASE_UNKNOWN: the alleles are
+// kept in the order they came in, as the encoder always did.
+//
+// The knots of a CGF_PHASE_UNPHASED path are stored with their alleles in
+// a canonical order (the one with the lower tile map position, see
+// _knot_unphased_order), so "1:0" and "0:1" use the same tile map entry
+// and genotypes compare equal however the caller listed the alleles.
+// Decoded alleles of such a path carry no phase information.
+//
+// A CGF_PHASE_PHASED path can list phase blocks, by the steps they start
+// at (StepDelta from the previous start, from 0 for the first).  A block
+// runs to the start of the next one; the alleles of knots in the same
+// block are phased with each other, across blocks they aren't.  A phased
+// path without blocks is one block starting at 0.
+//
+// The phase set of a knot (as with the VCF PS field) is the start step of
+// its block, -1 for an unphased path.
+//
+// Phase blocks are read from text with one block start per line:
+//
+//   <path>.<ver>.<step>
+//
+
+const CGF_EXT_PHASE int = 8
+
+const CGF_PHASE_UNKNOWN int = 0
+const CGF_PHASE_PHASED int = 1
+const CGF_PHASE_UNPHASED int = 2
+
+type PathPhase struct {
+  Phase int
+
+  // Start steps of the phase blocks, sorted
+  //
+  Block []int
+}
+
+// Phase set of the knot anchored at step.
+//
+func (ph PathPhase) PhaseSet(step int) int {
+  if ph.Phase==CGF_PHASE_UNPHASED { return -1 }
+  i := sort.Search(len(ph.Block), func(i int) bool { return ph.Block[i] > step }) - 1
+  if i<0 { return 0 }
+  return ph.Block[i]
+}
+
+func KnotPhaseSet(pathi PathIntermediate, anchor_step int) int {
+  return pathi.phase.PhaseSet(anchor_step)
+}
+
+func PathIsUnphased(pathi PathIntermediate) bool {
+  return pathi.phase.Phase==CGF_PHASE_UNPHASED
+}
+
+func _header_phase(hdri *HeaderIntermediate) []PathPhase {
+  ph := make([]PathPhase, hdri.pathcount)
+
+  b,ok := HeaderIntermediateGetExt(hdri, CGF_EXT_PHASE)
+  if !ok { return ph }
+
+  n:=0
+  npath,dn := dlug.ConvertUint64(b[n:])
+  n+=dn
+  for i:=0; i<int(npath) && n<len(b); i++ {
+    p,dn := dlug.ConvertUint64(b[n:])
+    n+=dn
+    count,dn := dlug.ConvertUint64(b[n:])
+    n+=dn
+
+    cur := PathPhase{ Phase:int(p) }
+    step := 0
+    for j:=0; j<int(count) && n<len(b); j++ {
+      d,dn := dlug.ConvertUint64(b[n:])
+      n+=dn
+      step += int(d)
+      cur.Block = append(cur.Block, step)
+    }
+    if i<len(ph) { ph[i] = cur }
+  }
+  return ph
+}
+
+func HeaderIntermediatePathPhase(hdri *HeaderIntermediate, path int) PathPhase {
+  ph := _header_phase(hdri)
+  if path<0 || path>=len(ph) { return PathPhase{} }
+  return ph[path]
+}
+
+// Record the phase of a path (CGF_PHASE_UNKNOWN to clear it).  The path
+// bytes of an unphased path have to be encoded as such.
+//
+func HeaderIntermediateSetPathPhase(hdri *HeaderIntermediate, path int, phase PathPhase) error {
+  ph := _header_phase(hdri)
+  if path<0 || path>=len(ph) { return fmt.Errorf("path %x out of range", path) }
+  if phase.Phase<CGF_PHASE_UNKNOWN || phase.Phase>CGF_PHASE_UNPHASED { return fmt.Errorf("invalid phase %d", phase.Phase) }
+  if phase.Phase!=CGF_PHASE_PHASED && len(phase.Block)>0 { return fmt.Errorf("phase blocks on a path that isn't phased") }
+  if !sort.IntsAreSorted(phase.Block) { return fmt.Errorf("phase blocks not sorted") }
+  ph[path] = phase
+
+  any := false
+  for i:=0; i<len(ph); i++ {
+    if ph[i].Phase!=CGF_PHASE_UNKNOWN { any = true ; break }
+  }
+
+  if !any {
+    HeaderIntermediateSetExt(hdri, CGF_EXT_PHASE, nil)
+    return nil
+  }
+
+  b := make([]byte, 0, 1024)
+  b = append(b, dlug.MarshalUint64(uint64(len(ph)))...)
+  for i:=0; i<len(ph); i++ {
+    b = append(b, dlug.MarshalUint64(uint64(ph[i].Phase))...)
+    b = append(b, dlug.MarshalUint64(uint64(len(ph[i].Block)))...)
+    prev := 0
+    for _,step := range ph[i].Block {
+      b = append(b, dlug.MarshalUint64(uint64(step-prev))...)
+      prev = step
+    }
+  }
+  HeaderIntermediateSetExt(hdri, CGF_EXT_PHASE, b)
+  return nil
+}
+
+// Phase block starts, by path, from b (see above).  Tile positions in
+// another tagset than the file's are mapped with tmap (see
+// HeaderIntermediateResolveTilepos).
+//
+func PhaseBlocksFromBytes(hdri *HeaderIntermediate, tmap *TagsetMap, b []byte) (map[int][]int, error) {
+  blocks := make(map[int][]int)
+
+  scan := bufio.NewScanner(bytes.NewReader(b))
+  line_no := 0
+  for scan.Scan() {
+    l := strings.TrimSpace(scan.Text())
+    line_no++
+    if len(l)==0 || l[0]=='#' { continue }
+
+    path,ver,step,e := ParseTilepos(l)
+    if e!=nil { return nil, fmt.Errorf("%v (line %d)", e, line_no) }
+    path,_,step,e = HeaderIntermediateResolveTilepos(hdri, tmap, path, ver, step)
+    if e!=nil { return nil, fmt.Errorf("%v (line %d)", e, line_no) }
+    if path>=len(hdri.StepPerPath) || step>=hdri.StepPerPath[path] {
+      return nil, fmt.Errorf("%s not in the CGF (line %d)", l, line_no)
+    }
+
+    blocks[path] = append(blocks[path], step)
+  }
+  if e:=scan.Err() ; e!=nil { return nil, e }
+
+  for path := range blocks {
+    sort.Ints(blocks[path])
+    uniq := blocks[path][:0]
+    for i,step := range blocks[path] {
+      if i==0 || step!=uniq[len(uniq)-1] { uniq = append(uniq, step) }
+    }
+    blocks[path] = uniq
+  }
+
+  return blocks, nil
+}
+
+func _swap_knot_alleles(knot *CGFIntermediate) {
+  knot.step[0],knot.step[1] = knot.step[1],knot.step[0]
+  knot.seq[0],knot.seq[1] = knot.seq[1],knot.seq[0]
+  knot.varid[0],knot.varid[1] = knot.varid[1],knot.varid[0]
+  knot.span[0],knot.span[1] = knot.span[1],knot.span[0]
+  knot.loq[0],knot.loq[1] = knot.loq[1],knot.loq[0]
+  knot.nocall_start_len[0],knot.nocall_start_len[1] = knot.nocall_start_len[1],knot.nocall_start_len[0]
+}
+
+// Nocalls of the tiles of allele a sort before those of allele b.
+//
+func _nocall_less(a, b [][]int) bool {
+  for i:=0; i<len(a) && i<len(b); i++ {
+    for j:=0; j<len(a[i]) && j<len(b[i]); j++ {
+      if a[i][j]!=b[i][j] { return a[i][j] < b[i][j] }
+    }
+    if len(a[i])!=len(b[i]) { return len(a[i]) < len(b[i]) }
+  }
+  return len(a) < len(b)
+}
+
+// Put the alleles of an unphased knot in canonical order: the order with
+// a tile map entry if only one has, the one with the lower tile map
+// position if both have, the lower tile map key otherwise.  Alleles with
+// the same tiles are ordered by their nocalls.
+//
+func (ctx *CGFContext) _knot_unphased_order(knot *CGFIntermediate) {
+  k01 := create_tilemap_string_lookup2(knot.varid[0], knot.span[0], knot.varid[1], knot.span[1])
+  k10 := create_tilemap_string_lookup2(knot.varid[1], knot.span[1], knot.varid[0], knot.span[0])
+  if k01==k10 {
+    if _nocall_less(knot.nocall_start_len[1], knot.nocall_start_len[0]) { _swap_knot_alleles(knot) }
+    return
+  }
+
+  p01,ok01 := ctx.TileMapPosition[k01]
+  p10,ok10 := ctx.TileMapPosition[k10]
+
+  swap := false
+  if ok01 && ok10 {
+    swap = p10 < p01
+  } else if ok01 || ok10 {
+    swap = ok10
+  } else {
+    swap = k10 < k01
+  }
+
+  if swap { _swap_knot_alleles(knot) }
+}
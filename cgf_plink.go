@@ -0,0 +1,271 @@
+package cgf
+
+import "fmt"
+import "io"
+import "sort"
+import "bufio"
+import "strings"
+
+// PLINK (1.x binary) export of tile variants.
+//
+// Every tile variant (path, step, variant id, span) is a biallelic
+// pseudo-marker: a haplotype carries it or it doesn't.  Markers are the
+// variants whose frequency over the cohort, among the haplotypes with a
+// genotype for the step (see below), is at least min_freq and at most
+// 1-min_freq.  The marker id is the tile id
+// as printed by `knot-2`, the first allele (A1) is "P" (carrier), the
+// second (A2) "A" (non-carrier).
+//
+// A spanning tile is attributed to the step it's anchored at: it counts
+// for the markers of that step only, and haplotypes going over a step
+// with a tile anchored before it don't carry any of the step's variants.
+// A haplotype's genotype for the markers of a step is missing if its tile
+// anchored there has nocalls, is masked or filtered out (see KnotFilter),
+// or if the sample doesn't have the path.  Haploid paths are written as
+// homozygous.
+//
+// Positions in the .bim are 0 (chromosome and base pair) unless a tile
+// assembly is given, in which case they're the chromosome and the 1 based
+// start of the tile.
+//
+
+const PLINK_ALLELE_CARRIER string = "P"
+const PLINK_ALLELE_NONCARRIER string = "A"
+
+// Genotype codes of the .bed file (two bits per sample).
+//
+const PLINK_HOM_A1 byte = 0
+const PLINK_MISSING byte = 1
+const PLINK_HET byte = 2
+const PLINK_HOM_A2 byte = 3
+
+type PlinkMarker struct {
+  Path int
+  Ver int
+  Step int
+  VarId int
+  Span int
+
+  Freq float64
+}
+
+// Markers from the cohort's tile variant frequencies, in path, step,
+// variant id and span order.  Marker ids are in the tagset versions of the
+// cohort's paths (see MergePathTagsets, 0 for paths not in tagsets).
+//
+func PlinkMarkersFromFreq(tf TileFreq, tagsets map[int]int, min_freq float64) []PlinkMarker {
+  // Haplotypes with a tile over the step, anchored before it or anchored
+  // there and not low quality or masked.
+  //
+  totals := make(map[[2]int]int)
+  for key,c := range tf {
+    for s:=1; s<key.Span; s++ { totals[[2]int{key.Path, key.Step+s}] += c.Count }
+    if key.VarId==KNOT_MASK_VARID { continue }
+    totals[[2]int{key.Path, key.Step}] += c.Count - c.Loq - c.Masked
+  }
+
+  markers := []PlinkMarker{}
+  for _,key := range tf.SortedKeys() {
+    if key.VarId==KNOT_MASK_VARID { continue }
+    c := tf[key]
+    n := c.Count - c.Loq - c.Masked
+    tot := totals[[2]int{key.Path, key.Step}]
+    if n<=0 || tot==0 { continue }
+
+    f := float64(n)/float64(tot)
+    if f<min_freq || f>(1.0-min_freq) { continue }
+    markers = append(markers, PlinkMarker{ Path:key.Path, Ver:tagsets[key.Path], Step:key.Step, VarId:key.VarId, Span:key.Span, Freq:f })
+  }
+  return markers
+}
+
+type PlinkGenotyper struct {
+  Markers []PlinkMarker
+  Filter *KnotFilter
+
+  by_step map[[2]int][]int
+  paths []int
+
+  // Step ranges of each path holding the markers.
+  //
+  ranges map[int][][2]int
+}
+
+// Marker steps closer than this are scanned as one range, as seeking the
+// knot cursor costs about as much as decoding a Vector word's knots.
+//
+const PLINK_SCAN_GAP int = 32
+
+func NewPlinkGenotyper(markers []PlinkMarker, filt *KnotFilter) *PlinkGenotyper {
+  pg := PlinkGenotyper{ Markers:markers, Filter:filt }
+  pg.by_step = make(map[[2]int][]int)
+
+  seen := make(map[int]bool)
+  for i,m := range markers {
+    k := [2]int{m.Path, m.Step}
+    pg.by_step[k] = append(pg.by_step[k], i)
+    if !seen[m.Path] {
+      seen[m.Path] = true
+      pg.paths = append(pg.paths, m.Path)
+    }
+  }
+  sort.Ints(pg.paths)
+
+  pg.ranges = make(map[int][][2]int)
+  for _,path := range pg.paths {
+    steps := []int{}
+    for k := range pg.by_step {
+      if k[0]==path { steps = append(steps, k[1]) }
+    }
+    sort.Ints(steps)
+
+    r := [][2]int{}
+    for _,step := range steps {
+      if n:=len(r) ; n>0 && step<r[n-1][1]+PLINK_SCAN_GAP {
+        r[n-1][1] = step+1
+        continue
+      }
+      r = append(r, [2]int{step, step+1})
+    }
+    pg.ranges[path] = r
+  }
+
+  return &pg
+}
+
+// Genotype code of every marker for one sample.
+//
+func (pg *PlinkGenotyper) SampleGenotypes(hdri *HeaderIntermediate) ([]byte, error) {
+  return pg._sample_genotypes(hdri, func(path int) (PathIntermediate, error) {
+    return HeaderIntermediateLoadPath(hdri, path)
+  })
+}
+
+// As SampleGenotypes, reading the paths holding markers from r (the
+// sample's CGF) one at a time.
+//
+func (pg *PlinkGenotyper) ReadSampleGenotypes(href *HeaderRef, r io.ReaderAt) ([]byte, error) {
+  return pg._sample_genotypes(&href.Header, func(path int) (PathIntermediate, error) {
+    return href.ReadPath(r, path)
+  })
+}
+
+func (pg *PlinkGenotyper) _sample_genotypes(hdri *HeaderIntermediate, load_path func(path int) (PathIntermediate, error)) ([]byte, error) {
+
+  // Per marker and haplotype: 0 non-carrier, 1 carrier, -1 missing.
+  //
+  hap := make([][2]int8, len(pg.Markers))
+  for i:=0; i<len(hap); i++ { hap[i] = [2]int8{-1,-1} }
+
+  for _,path := range pg.paths {
+    if path>=len(hdri.StepPerPath) || hdri.StepPerPath[path]==0 { continue }
+
+    pathi,e := load_path(path)
+    if e!=nil { return nil, e }
+
+    for i,m := range pg.Markers {
+      if m.Path==path && m.Step<pathi.ntile { hap[i] = [2]int8{0,0} }
+    }
+
+    scan_fn := func(anchor_step int, knot [][]TileInfo) error {
+      reason,e := pg.Filter.Check(pathi, path, anchor_step, knot)
+      if e!=nil { return e }
+
+      for allele:=0; allele<len(knot); allele++ {
+        haps := []int{allele}
+        if len(knot)==1 { haps = []int{0,1} }
+
+        for _,ti := range knot[allele] {
+          idx,ok := pg.by_step[[2]int{path, ti.Step}]
+          if !ok { continue }
+
+          missing := reason!="" || len(ti.NocallStartLen)>0 || ti.VarId==KNOT_MASK_VARID
+          for _,i := range idx {
+            m := &pg.Markers[i]
+            for _,h := range haps {
+              if h>1 { continue }
+              if missing {
+                hap[i][h] = -1
+              } else if hap[i][h]>=0 && m.VarId==ti.VarId && m.Span==ti.Span {
+                hap[i][h] = 1
+              }
+            }
+          }
+        }
+      }
+      return nil
+    }
+
+    // Only the knots over marker steps are decoded.  A range starts at the
+    // knot covering its first step, which can hold marker tiles anchored
+    // after it.
+    //
+    last := 0
+    for _,r := range pg.ranges[path] {
+      if r[0]>=pathi.ntile { break }
+      beg,_ := _covering_knot(hdri.TileMap, pathi, r[0])
+      if beg<last { beg = last }
+      e = PathKnotScan(hdri.TileMap, pathi, beg, r[1], scan_fn)
+      if e!=nil { return nil, fmt.Errorf("path %04x: %v", path, e) }
+      last = r[1]
+    }
+  }
+
+  geno := make([]byte, len(pg.Markers))
+  for i:=0; i<len(hap); i++ {
+    switch {
+    case hap[i][0]<0 || hap[i][1]<0: geno[i] = PLINK_MISSING
+    case hap[i][0]+hap[i][1]==2: geno[i] = PLINK_HOM_A1
+    case hap[i][0]+hap[i][1]==1: geno[i] = PLINK_HET
+    default: geno[i] = PLINK_HOM_A2
+    }
+  }
+  return geno, nil
+}
+
+// .bed in SNP-major mode from the genotype codes of each sample
+// (geno[sample][marker]).
+//
+func WritePlinkBed(w io.Writer, geno [][]byte, nmarker int) error {
+  bw := bufio.NewWriter(w)
+  bw.Write([]byte{0x6c, 0x1b, 0x01})
+
+  row := make([]byte, (len(geno)+3)/4)
+  for m:=0; m<nmarker; m++ {
+    for i:=0; i<len(row); i++ { row[i] = 0 }
+    for s:=0; s<len(geno); s++ {
+      row[s/4] |= (geno[s][m]&3) << (2*uint(s%4))
+    }
+    bw.Write(row)
+  }
+  return bw.Flush()
+}
+
+// .bim, with positions from asm if it isn't nil.
+//
+func WritePlinkBim(w io.Writer, markers []PlinkMarker, asm *TileAssembly) error {
+  bw := bufio.NewWriter(w)
+  for _,m := range markers {
+    chrom,pos := "0",0
+    if asm!=nil {
+      c,beg,_,e := asm.TileRange(m.Path, m.Step, m.Span)
+      if e!=nil { return e }
+      chrom,pos = c,beg+1
+    }
+    id := TileIdString(m.Path, m.Ver, TileInfo{ Step:m.Step, VarId:m.VarId, Span:m.Span })
+    fmt.Fprintf(bw, "%s\t%s\t0\t%d\t%s\t%s\n", chrom, id, pos, PLINK_ALLELE_CARRIER, PLINK_ALLELE_NONCARRIER)
+  }
+  return bw.Flush()
+}
+
+// .fam with the sample names (whitespace replaced by '_') as family and
+// individual ids, no parents, sex or phenotype.
+//
+func WritePlinkFam(w io.Writer, names []string) error {
+  bw := bufio.NewWriter(w)
+  for _,name := range names {
+    name = strings.Join(strings.Fields(name), "_")
+    fmt.Fprintf(bw, "%s\t%s\t0\t0\t0\t-9\n", name, name)
+  }
+  return bw.Flush()
+}
@@ -0,0 +1,98 @@
+package cgf
+
+import "fmt"
+
+import "github.com/abeconnelly/dlug"
+
+// Ploidy extension record (CGF_EXT_PLOIDY):
+//
+//   PathCount dlug
+//   Ploidy    [PathCount]dlug
+//
+// Paths past PathCount, and paths with Ploidy 0, are diploid.  The record
+// is only written when some path isn't diploid.
+//
+// Haploid paths (male chrX/chrY, chrM) are encoded as homozygous diploid
+// paths, which costs nothing extra as every homozygous tile map entry is
+// already in the tile map and homozygous nocalls are stored once.  The
+// decoders drop the second allele again.  Higher ploidies aren't
+// supported.
+//
+
+const CGF_EXT_PLOIDY int = 4
+
+const CGF_MAX_PLOIDY int = 2
+
+func _header_ploidy(hdri *HeaderIntermediate) []int {
+  ploidy := make([]int, hdri.pathcount)
+  for i:=0; i<len(ploidy); i++ { ploidy[i] = 2 }
+
+  b,ok := HeaderIntermediateGetExt(hdri, CGF_EXT_PLOIDY)
+  if !ok { return ploidy }
+
+  n:=0
+  npath,dn := dlug.ConvertUint64(b[n:])
+  n+=dn
+  for i:=0; i<int(npath) && n<len(b); i++ {
+    p,dn := dlug.ConvertUint64(b[n:])
+    n+=dn
+    if i<len(ploidy) && p!=0 { ploidy[i] = int(p) }
+  }
+  return ploidy
+}
+
+func HeaderIntermediatePathPloidy(hdri *HeaderIntermediate, path int) int {
+  ploidy := _header_ploidy(hdri)
+  if path<0 || path>=len(ploidy) { return 2 }
+  return ploidy[path]
+}
+
+func HeaderIntermediateSetPathPloidy(hdri *HeaderIntermediate, path, ploidy int) error {
+  if ploidy<1 || ploidy>CGF_MAX_PLOIDY { return fmt.Errorf("path %x: ploidy %d not supported (1 or 2)", path, ploidy) }
+
+  p := _header_ploidy(hdri)
+  if path<0 || path>=len(p) { return fmt.Errorf("path %x out of range", path) }
+  p[path] = ploidy
+
+  all_diploid := true
+  for i:=0; i<len(p); i++ {
+    if p[i]!=2 { all_diploid = false ; break }
+  }
+
+  if all_diploid {
+    HeaderIntermediateSetExt(hdri, CGF_EXT_PLOIDY, nil)
+    return nil
+  }
+
+  b := make([]byte, 0, len(p)+8)
+  b = append(b, dlug.MarshalUint64(uint64(len(p)))...)
+  for i:=0; i<len(p); i++ {
+    b = append(b, dlug.MarshalUint64(uint64(p[i]))...)
+  }
+  HeaderIntermediateSetExt(hdri, CGF_EXT_PLOIDY, b)
+  return nil
+}
+
+// Allele paths ready for EmitPathBytes: a haploid path is doubled into a
+// homozygous diploid one.
+//
+func _diploid_allele_path(allele_path [][]TileInfo) ([][]TileInfo, error) {
+  if len(allele_path)==2 { return allele_path, nil }
+  if len(allele_path)!=1 { return nil, fmt.Errorf("ploidy %d not supported (1 or 2)", len(allele_path)) }
+
+  dup := make([]TileInfo, len(allele_path[0]))
+  copy(dup, allele_path[0])
+  for i:=0; i<len(dup); i++ {
+    if len(dup[i].NocallStartLen)>0 {
+      dup[i].NocallStartLen = append([]int(nil), dup[i].NocallStartLen...)
+    }
+  }
+  return [][]TileInfo{ allele_path[0], dup }, nil
+}
+
+// Knot trimmed to the path's ploidy.
+//
+func _knot_ploidy(pathi *PathIntermediate, knot [][]TileInfo) [][]TileInfo {
+  if pathi.ploidy>0 && len(knot)>pathi.ploidy { return knot[:pathi.ploidy] }
+  return knot
+}
@@ -9,14 +9,32 @@ import "github.com/abeconnelly/cglf"
 func PrintKnotFastjSGLF(knot [][]TileInfo, sglf cglf.SGLF, path, ver uint64, hdri HeaderIntermediate) {
   if len(knot)==0 { return }
 
+  // Phase notes: "Phase A|B", with the phase set if the path was
+  // recorded as phased, or "Unphased" (see cgf_phase.go).
+  //
+  phase := HeaderIntermediatePathPhase(&hdri, int(path))
+
   for i:=0; i<len(knot); i++ {
-    phase_str := "A"
-    if i==1 { phase_str = "B" }
+    phase_str := "\"Phase A\""
+    if i==1 { phase_str = "\"Phase B\"" }
+    if phase.Phase==CGF_PHASE_UNPHASED {
+      phase_str = "\"Unphased\""
+    } else if phase.Phase==CGF_PHASE_PHASED {
+      phase_str += fmt.Sprintf(",\"PhaseSet %d\"", phase.PhaseSet(knot[0][0].Step))
+    }
 
     cur_step := knot[i][0].Step
 
 
     for j:=0; j<len(knot[i]); j++ {
+
+      // Masked tiles (see KnotFilter) have no sequence to print.
+      //
+      if knot[i][j].VarId==KNOT_MASK_VARID {
+        cur_step += knot[i][j].Span
+        continue
+      }
+
       fmt.Printf("> {")
       fmt.Printf(" \"tileID\":\"%04x.%02x.%04x.%03x\", \"seedTileLength\":%d",
         path, ver,
@@ -88,7 +106,7 @@ func PrintKnotFastjSGLF(knot [][]TileInfo, sglf cglf.SGLF, path, ver uint64, hdr
         }
 
         fmt.Printf(", \"notes\":[")
-        fmt.Printf("\"Allele %d\",\"Phase %s\"", i, phase_str)
+        fmt.Printf("\"Allele %d\",%s", i, phase_str)
         fmt.Printf(",\"")
         fmt.Printf("*{")
         for p:=0; p<len(knot[i][j].NocallStartLen); p+=2 {
@@ -125,7 +143,7 @@ func PrintKnotFastjSGLF(knot [][]TileInfo, sglf cglf.SGLF, path, ver uint64, hdr
         }
 
         fmt.Printf(", \"notes\":[")
-        fmt.Printf("\"Allele %d\",\"Phase %s\"", i, phase_str)
+        fmt.Printf("\"Allele %d\",%s", i, phase_str)
         fmt.Printf(",\"")
         fmt.Printf("*{")
         for p:=0; p<len(knot[i][j].NocallStartLen); p+=2 {
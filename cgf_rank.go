@@ -0,0 +1,89 @@
+package cgf
+
+import "sort"
+import "math/bits"
+
+// Random access tables for a decoded path, built once at load time.
+//
+// A knot stored in the overflow map is found by its rank among the
+// overflow entries, the number of overflow steps before it.  ovf_rank[w]
+// holds that count for the first step of Vector word w, so the rank of
+// any step is ovf_rank[w] plus a popcount and at most 8 hexit tests in
+// its own word.  fof_offset[i] is where final overflow record i starts in
+// fofsi.variant_ints, found by binary search on fofsi.tilepos.
+//
+// Both are built by PathIntermediateFromBytes.  Paths put together any
+// other way (by the encoder, say) have neither table and fall back to the
+// on-disk overflow stride index, tilepos_idx, which holds the step of
+// every stride'th overflow entry, and to a walk of the final overflow
+// records.
+//
+
+// Cache (non-canonical) steps before offset m in the word.
+//
+func _vec_cache_count(vec uint64, m uint) int {
+  return bits.OnesCount64((vec>>32) & ((uint64(1)<<m)-1))
+}
+
+// Overflow entries among the first n cache steps of a word.
+//
+func _vec_overflow_count(vec uint64, n int) int {
+  c := 0
+  if n>8 { c = n-8 ; n = 8 }
+  for i:=0; i<n; i++ {
+    if ((vec>>(4*uint(i))) & 0xf) >= 0xd { c++ }
+  }
+  return c
+}
+
+func _build_path_rank(pathi *PathIntermediate) {
+  nword := len(pathi.VecUint64)
+
+  pathi.ovf_rank = make([]int32, nword+1)
+  r := 0
+  for w:=0; w<nword; w++ {
+    pathi.ovf_rank[w] = int32(r)
+    vec := pathi.VecUint64[w]
+    r += _vec_overflow_count(vec, bits.OnesCount64(vec>>32))
+  }
+  pathi.ovf_rank[nword] = int32(r)
+
+  pathi.fof_offset = make([]int, len(pathi.fofsi.tilepos))
+  pos := 0
+  for i:=0; i<len(pathi.fof_offset) && pos<len(pathi.fofsi.variant_ints); i++ {
+    pathi.fof_offset[i] = pos
+    pos += _skip_fofsi(pathi.fofsi.variant_ints[pos:])
+  }
+}
+
+// Position in the overflow map of the entry for step (the number of
+// overflow steps before it).
+//
+func _overflow_pos(pathi *PathIntermediate, step int) int {
+  w := step/32
+  vec := pathi.VecUint64[w]
+  in_word := _vec_overflow_count(vec, _vec_cache_count(vec, uint(step%32)))
+
+  if w<len(pathi.ovf_rank) { return int(pathi.ovf_rank[w]) + in_word }
+
+  // No rank table: start from the last stride checkpoint at or before
+  // step.
+  //
+  ofsi := &pathi.ofsi
+  k := sort.Search(len(ofsi.tilepos_idx), func(i int) bool { return ofsi.tilepos_idx[i] > step }) - 1
+  if k<0 || ofsi.stride<=0 { return CountOverflowVectorUint64(pathi.VecUint64, 0, step) }
+  return k*ofsi.stride + CountOverflowVectorUint64(pathi.VecUint64, ofsi.tilepos_idx[k], step)
+}
+
+// Start of the final overflow record for step in fofsi.variant_ints.
+//
+func _fofsi_pos(pathi *PathIntermediate, step int) (int, bool) {
+  i := sort.SearchInts(pathi.fofsi.tilepos, step)
+  if i>=len(pathi.fofsi.tilepos) || pathi.fofsi.tilepos[i]!=step { return 0, false }
+
+  if i<len(pathi.fof_offset) { return pathi.fof_offset[i], true }
+
+  pos := 0
+  for j:=0; j<i; j++ { pos += _skip_fofsi(pathi.fofsi.variant_ints[pos:]) }
+  return pos, true
+}
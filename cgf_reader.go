@@ -0,0 +1,91 @@
+package cgf
+
+import "fmt"
+import "io"
+
+// Header of a CGF read without its path blocks, with what's needed to
+// read single paths later.  Only the start of the file (up to the end of
+// the path offset table) and the extension trailer at the end are read.
+//
+type HeaderRef struct {
+  Header HeaderIntermediate
+  PathBase int64
+  Size int64
+}
+
+const _header_ref_read_size int64 = 1<<16
+
+func _read_at(r io.ReaderAt, off, n int64) ([]byte, error) {
+  b := make([]byte, n)
+  k,e := r.ReadAt(b, off)
+  if int64(k)==n { return b, nil }
+  if e==nil || e==io.EOF { e = io.ErrUnexpectedEOF }
+  return nil, e
+}
+
+func ReadHeaderRef(r io.ReaderAt, size int64) (*HeaderRef, error) {
+  href := HeaderRef{ Size:size }
+
+  // Read more of the start of the file until the header parses.
+  //
+  var hdri HeaderIntermediate
+  dn := -1
+  for n:=_header_ref_read_size; ; n*=2 {
+    if n>size { n = size }
+    b,e := _read_at(r, 0, n)
+    if e!=nil { return nil, e }
+
+    hdri,dn = HeaderIntermediateFromBytes(b)
+    if dn>=0 {
+      if n==size { return &HeaderRef{ Header:hdri, PathBase:int64(dn), Size:size }, nil }
+      break
+    }
+    if n==size { return nil, fmt.Errorf("header truncated or corrupt") }
+  }
+
+  href.PathBase = int64(dn)
+  href.Header = hdri
+  href.Header.PathBytes = make([][]byte, hdri.pathcount)
+  href.Header.ext = nil
+  href.Header.ext_err = nil
+
+  path_end := href.PathBase + int64(hdri.path_offset[hdri.pathcount])
+  if path_end < size {
+    b,e := _read_at(r, path_end, size-path_end)
+    if e!=nil { return nil, e }
+    href.Header.ext,href.Header.ext_err = HeaderExtFromBytes(b)
+  }
+
+  return &href, nil
+}
+
+// Stored (possibly compressed) bytes of a path block.
+//
+func (href *HeaderRef) ReadPathBytes(r io.ReaderAt, path int) ([]byte, error) {
+  hdri := &href.Header
+  if path<0 || path>=hdri.pathcount { return nil, fmt.Errorf("path %x out of range", path) }
+
+  if hdri.PathBytes[path]!=nil { return hdri.PathBytes[path], nil }
+
+  s := int64(hdri.path_offset[path])
+  n := int64(hdri.path_offset[path+1]) - s
+  if n==0 { return []byte{}, nil }
+  if href.PathBase+s+n > href.Size { return nil, fmt.Errorf("path %x: file truncated", path) }
+
+  return _read_at(r, href.PathBase+s, n)
+}
+
+// Read, verify and decode a path.  The HeaderRef isn't modified, so it
+// can be shared between goroutines.
+//
+func (href *HeaderRef) ReadPath(r io.ReaderAt, path int) (PathIntermediate, error) {
+  b,e := href.ReadPathBytes(r, path)
+  if e!=nil { return PathIntermediate{}, e }
+
+  hdri := href.Header
+  hdri.PathBytes = make([][]byte, len(href.Header.PathBytes))
+  copy(hdri.PathBytes, href.Header.PathBytes)
+  hdri.PathBytes[path] = b
+
+  return HeaderIntermediateLoadPath(&hdri, path)
+}
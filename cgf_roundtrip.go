@@ -0,0 +1,242 @@
+package cgf
+
+import "fmt"
+import "strings"
+import "crypto/md5"
+
+import "github.com/abeconnelly/cglf"
+
+// FastJ -> CGF -> FastJ round trip check.
+//
+// Every knot of a path is decoded, the FastJ record of each tile is
+// regenerated from the library (step, seedTileLength, tags and the
+// nocall masked sequence, which fixes the md5sum) and compared to the
+// tiles of the original FastJ, as loaded by LoadSampleFastj.
+//
+
+type RoundtripMismatch struct {
+  Path int
+  Ver int
+  Allele int
+  Step int
+
+  Field string
+  Want string
+  Got string
+
+  // Knot the mismatch was found in (nil if the CGF ran out of tiles
+  // first), how its anchor step is encoded and its loq information.
+  //
+  AnchorStep int
+  Knot [][]TileInfo
+  Orig [][]TileInfo
+  Encoding string
+  Loq bool
+  LoqNocall [][][]int
+}
+
+var _roundtrip_stop error = fmt.Errorf("roundtrip mismatch")
+
+// How the knot anchored at step is stored in the path vector.
+//
+func _step_encoding(pathi *PathIntermediate, step int) string {
+  vec := pathi.VecUint64[step/32]
+  m := uint(step%32)
+
+  if (vec & (1<<(32+m))) == 0 { return "canonical" }
+
+  cache_counter := _vec_cache_count(vec, m)
+
+  hexit := -1
+  if cache_counter < 8 {
+    hexit = int((vec >> (4*uint(cache_counter))) & 0xf)
+    if hexit == 0 { return "span" }
+    if hexit < 0xd { return fmt.Sprintf("tilemap hexit %x", hexit) }
+  }
+
+  kind := "overflow"
+  if hexit == 0xe { kind = "loq overflow" }
+  if hexit < 0 { kind = "overflow (past 8th hexit)" }
+
+  ovf_pos := _overflow_pos(pathi, step)
+  if ovf_pos>=len(pathi.ofsi.span_flag) { return kind + ", no overflow entry" }
+  if pathi.ofsi.span_flag[ovf_pos] { return kind + ", span" }
+  if pathi.ofsi.final_overflow_flag[ovf_pos] { return kind + ", final overflow" }
+  return fmt.Sprintf("%s, tilemap %d", kind, pathi.ofsi.TileMap[ovf_pos])
+}
+
+func _roundtrip_tile_cmp(sglf *cglf.SGLF, path, ver, nstep int, want *TileInfo, ti TileInfo) (field, w, g string, err error) {
+  if want==nil { return "tile", "(none)", TileIdString(path, ver, ti), nil }
+
+  if want.Step!=ti.Step { return "step", fmt.Sprintf("%04x", want.Step), fmt.Sprintf("%04x", ti.Step), nil }
+  if want.Span!=ti.Span { return "seedTileLength", fmt.Sprintf("%d", want.Span), fmt.Sprintf("%d", ti.Span), nil }
+
+  lib_seq,e := _lib_tile_seq(sglf, path, ver, ti)
+  if e!=nil { return "", "", "", e }
+  seq := lib_seq
+  if len(ti.NocallStartLen)>0 { seq = FillNocSeq(lib_seq, ti.NocallStartLen) }
+
+  if want.Seq!=seq {
+    k:=0
+    for k<len(seq) && k<len(want.Seq) && seq[k]==want.Seq[k] { k++ }
+    return "sequence",
+      fmt.Sprintf("md5 %s, len %d, %s at %d", Md5sum2str(md5.Sum([]byte(want.Seq))), len(want.Seq), _seq_ctx(want.Seq, k), k),
+      fmt.Sprintf("md5 %s, len %d, %s at %d", Md5sum2str(md5.Sum([]byte(seq))), len(seq), _seq_ctx(seq, k), k), nil
+  }
+
+  start_tag,end_tag := "",""
+  if len(lib_seq)<TAG_LEN { return "", "", "", fmt.Errorf("tile %s shorter than tag", TileIdString(path, ver, ti)) }
+  if ti.Step>0 { start_tag = lib_seq[:TAG_LEN] }
+  if ti.Step+ti.Span<nstep { end_tag = lib_seq[len(lib_seq)-TAG_LEN:] }
+
+  if !_noc_cmp(want.PfxTag, start_tag) { return "startTag", want.PfxTag, start_tag, nil }
+  if !_noc_cmp(want.SfxTag, end_tag) { return "endTag", want.SfxTag, end_tag, nil }
+
+  return "", "", "", nil
+}
+
+func _seq_ctx(s string, k int) string {
+  if k>=len(s) { return "(end)" }
+  e := k+8
+  if e>len(s) { e = len(s) }
+  return fmt.Sprintf("'%s'", s[k:e])
+}
+
+// Original tiles, per allele, from pos over [beg,end).
+//
+func _roundtrip_orig(orig [][]TileInfo, pos []int, beg, end int) [][]TileInfo {
+  res := make([][]TileInfo, len(orig))
+  for allele:=0; allele<len(orig); allele++ {
+    res[allele] = []TileInfo{}
+    for i:=pos[allele]; i<len(orig[allele]) && orig[allele][i].Step<end; i++ {
+      if orig[allele][i].Step+orig[allele][i].Span > beg { res[allele] = append(res[allele], orig[allele][i]) }
+    }
+  }
+  return res
+}
+
+// Compare the decoded path against the original FastJ tiles.  Returns
+// the first mismatch (nil if there is none) and the number of tiles
+// checked.  ver is the path's tagset, for the tile ids reported.
+//
+func PathRoundtripCheck(tilemap []TileMapEntry, pathi PathIntermediate, path, ver int, orig [][]TileInfo, sglf *cglf.SGLF) (*RoundtripMismatch, int, error) {
+  nstep := pathi.ntile
+  pos := make([]int, len(orig))
+  ntile := 0
+
+  var mm *RoundtripMismatch
+
+  e := PathKnotScan(tilemap, pathi, 0, nstep, func(anchor_step int, knot [][]TileInfo) error {
+    knot_end := anchor_step+1
+    for allele:=0; allele<len(knot); allele++ {
+      s := anchor_step
+      for i:=0; i<len(knot[allele]); i++ { s += knot[allele][i].Span }
+      if s>knot_end { knot_end = s }
+    }
+
+    found := func(allele, step int, field, w, g string) error {
+      mm = &RoundtripMismatch{ Path:path, Ver:ver, Allele:allele, Step:step, Field:field, Want:w, Got:g,
+        AnchorStep:anchor_step, Knot:knot, Orig:_roundtrip_orig(orig, pos, anchor_step, knot_end),
+        Encoding:_step_encoding(&pathi, anchor_step) }
+      if cgfi,ok := pathi.loqi.loqi_info[anchor_step] ; ok {
+        mm.Loq = true
+        mm.LoqNocall = cgfi.nocall_start_len
+      }
+      return _roundtrip_stop
+    }
+
+    if len(knot)!=len(orig) {
+      return found(0, anchor_step, "ploidy", fmt.Sprintf("%d", len(orig)), fmt.Sprintf("%d", len(knot)))
+    }
+
+    check := func(knot [][]TileInfo) (allele, step int, field, w, g string, err error) {
+      for allele=0; allele<len(knot); allele++ {
+        for i:=0; i<len(knot[allele]); i++ {
+          var want *TileInfo
+          if pos[allele]+i < len(orig[allele]) { want = &orig[allele][pos[allele]+i] }
+
+          field,w,g,err = _roundtrip_tile_cmp(sglf, path, ver, nstep, want, knot[allele][i])
+          if err!=nil || len(field)>0 { return allele, knot[allele][i].Step, field, w, g, err }
+        }
+      }
+      return 0, 0, "", "", "", nil
+    }
+
+    allele,step,field,w,g,e := check(knot)
+    if e!=nil { return e }
+
+    // The alleles of an unphased path are stored in canonical order, not
+    // the FastJ's.
+    //
+    if len(field)>0 && len(knot)==2 && PathIsUnphased(pathi) {
+      swapped := [][]TileInfo{ knot[1], knot[0] }
+      _,_,f,_,_,e := check(swapped)
+      if e!=nil { return e }
+      if len(f)==0 { knot,field = swapped,"" }
+    }
+    if len(field)>0 { return found(allele, step, field, w, g) }
+
+    for allele:=0; allele<len(knot); allele++ {
+      pos[allele] += len(knot[allele])
+      ntile += len(knot[allele])
+    }
+    return nil
+  })
+  if e==_roundtrip_stop { return mm, ntile, nil }
+  if e!=nil { return nil, ntile, e }
+
+  for allele:=0; allele<len(orig); allele++ {
+    if pos[allele]<len(orig[allele]) {
+      ti := orig[allele][pos[allele]]
+      return &RoundtripMismatch{ Path:path, Ver:ver, Allele:allele, Step:ti.Step, Field:"tile",
+        Want:fmt.Sprintf("%04x.%02x.%04x+%x", path, ver, ti.Step, ti.Span), Got:"(none)",
+        AnchorStep:ti.Step, Orig:_roundtrip_orig(orig, pos, ti.Step, ti.Step+ti.Span) }, ntile, nil
+    }
+  }
+
+  return nil, ntile, nil
+}
+
+func (mm *RoundtripMismatch) String() string {
+  var b strings.Builder
+
+  fmt.Fprintf(&b, "mismatch at %04x.%02x.%04x allele %d: %s\n", mm.Path, mm.Ver, mm.Step, mm.Allele, mm.Field)
+  fmt.Fprintf(&b, "  fastj: %s\n", mm.Want)
+  fmt.Fprintf(&b, "  cgf:   %s\n", mm.Got)
+  fmt.Fprintf(&b, "knot %04x.%02x.%04x\n", mm.Path, mm.Ver, mm.AnchorStep)
+
+  if mm.Knot!=nil {
+    fmt.Fprintf(&b, "  encoding: %s\n", mm.Encoding)
+    if mm.Loq {
+      fmt.Fprintf(&b, "  loq: yes, nocalls %v\n", mm.LoqNocall)
+    } else {
+      fmt.Fprintf(&b, "  loq: no\n")
+    }
+  }
+
+  tile_str := func(ti TileInfo, varid bool) string {
+    s := fmt.Sprintf("%04x.%02x.%04x+%x", mm.Path, mm.Ver, ti.Step, ti.Span)
+    if varid { s = TileIdString(mm.Path, mm.Ver, ti) }
+    if len(ti.NocallStartLen)>0 { s += fmt.Sprintf(" nocall %v", ti.NocallStartLen) }
+    return s
+  }
+
+  allele_name := []string{ "A", "B" }
+  for allele:=0; allele<len(mm.Orig) || allele<len(mm.Knot); allele++ {
+    name := fmt.Sprintf("%d", allele)
+    if allele<len(allele_name) { name = allele_name[allele] }
+
+    if allele<len(mm.Orig) {
+      for i:=0; i<len(mm.Orig[allele]); i++ {
+        fmt.Fprintf(&b, "  - %s %s\n", name, tile_str(mm.Orig[allele][i], false))
+      }
+    }
+    if allele<len(mm.Knot) {
+      for i:=0; i<len(mm.Knot[allele]); i++ {
+        fmt.Fprintf(&b, "  + %s %s\n", name, tile_str(mm.Knot[allele][i], true))
+      }
+    }
+  }
+
+  return b.String()
+}
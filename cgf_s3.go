@@ -0,0 +1,294 @@
+package cgf
+
+import "fmt"
+import "io"
+import "os"
+import "sort"
+import "time"
+import "bytes"
+import "strings"
+import "strconv"
+import "net/url"
+import "net/http"
+import "io/ioutil"
+import "crypto/hmac"
+import "crypto/sha256"
+import "encoding/hex"
+import "encoding/xml"
+
+// S3 API object store, signed with AWS signature version 4 (or
+// anonymous if there are no credentials).  Requests use path style
+// addressing (`endpoint/bucket/key`), which AWS, minio and Arvados
+// keep-web all accept.
+//
+// S3StoreForName takes its settings from the environment:
+//
+//   CGF_S3_ENDPOINT        (default https://s3.<region>.amazonaws.com)
+//   AWS_REGION             (default us-east-1)
+//   AWS_ACCESS_KEY_ID
+//   AWS_SECRET_ACCESS_KEY
+//   AWS_SESSION_TOKEN      (optional)
+//
+
+type S3Store struct {
+  Endpoint string
+  Bucket string
+  Region string
+
+  AccessKey string
+  SecretKey string
+  SessionToken string
+
+  Client *http.Client
+}
+
+const _s3_empty_sha256 string = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// Store and object key for an s3://bucket/key name.
+//
+func S3StoreForName(name string) (*S3Store, string, error) {
+  s := strings.TrimPrefix(name, "s3://")
+  p := strings.Index(s, "/")
+  bucket,key := s, ""
+  if p>=0 { bucket,key = s[:p], s[p+1:] }
+  if len(bucket)==0 { return nil, "", fmt.Errorf("%s: no bucket", name) }
+
+  region := os.Getenv("AWS_REGION")
+  if len(region)==0 { region = "us-east-1" }
+
+  endpoint := os.Getenv("CGF_S3_ENDPOINT")
+  if len(endpoint)==0 { endpoint = "https://s3." + region + ".amazonaws.com" }
+
+  st := S3Store{
+    Endpoint:strings.TrimSuffix(endpoint, "/"),
+    Bucket:bucket,
+    Region:region,
+    AccessKey:os.Getenv("AWS_ACCESS_KEY_ID"),
+    SecretKey:os.Getenv("AWS_SECRET_ACCESS_KEY"),
+    SessionToken:os.Getenv("AWS_SESSION_TOKEN") }
+
+  return &st, key, nil
+}
+
+// S3 flavour of RFC 3986 escaping: everything but unreserved characters
+// (and '/' in paths) is percent encoded.
+//
+func _s3_escape(s string, keep_slash bool) string {
+  var b bytes.Buffer
+  for i:=0; i<len(s); i++ {
+    ch := s[i]
+    if (ch>='A' && ch<='Z') || (ch>='a' && ch<='z') || (ch>='0' && ch<='9') ||
+       ch=='-' || ch=='_' || ch=='.' || ch=='~' || (keep_slash && ch=='/') {
+      b.WriteByte(ch)
+      continue
+    }
+    fmt.Fprintf(&b, "%%%02X", ch)
+  }
+  return b.String()
+}
+
+// Query string with sorted keys, escaped the same way.
+//
+func _s3_query(q url.Values) string {
+  qk := make([]string, 0, len(q))
+  for k := range q { qk = append(qk, k) }
+  sort.Strings(qk)
+
+  qp := []string{}
+  for _,k := range qk {
+    for _,v := range q[k] { qp = append(qp, _s3_escape(k, false) + "=" + _s3_escape(v, false)) }
+  }
+  return strings.Join(qp, "&")
+}
+
+func _hmac_sha256(key []byte, s string) []byte {
+  h := hmac.New(sha256.New, key)
+  h.Write([]byte(s))
+  return h.Sum(nil)
+}
+
+func _sha256_hex(b []byte) string {
+  h := sha256.Sum256(b)
+  return hex.EncodeToString(h[:])
+}
+
+// Add the signature version 4 headers to req.  Host, and every header
+// already set on req, are signed.
+//
+func (st *S3Store) sign(req *http.Request, payload_hash string, t time.Time) {
+  amz_date := t.UTC().Format("20060102T150405Z")
+  date := amz_date[:8]
+
+  req.Header.Set("x-amz-date", amz_date)
+  req.Header.Set("x-amz-content-sha256", payload_hash)
+  if len(st.SessionToken)>0 { req.Header.Set("x-amz-security-token", st.SessionToken) }
+  if len(st.AccessKey)==0 { return }
+
+  hdr := map[string]string{ "host":req.URL.Host }
+  for k,v := range req.Header {
+    hdr[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+  }
+  names := make([]string, 0, len(hdr))
+  for k := range hdr { names = append(names, k) }
+  sort.Strings(names)
+
+  var canon_hdr bytes.Buffer
+  for _,k := range names { canon_hdr.WriteString(k + ":" + hdr[k] + "\n") }
+  signed_hdr := strings.Join(names, ";")
+
+  path := req.URL.Path
+  if len(path)==0 { path = "/" }
+
+  canon_req := strings.Join([]string{
+    req.Method, _s3_escape(path, true), _s3_query(req.URL.Query()),
+    canon_hdr.String(), signed_hdr, payload_hash }, "\n")
+
+  scope := date + "/" + st.Region + "/s3/aws4_request"
+  to_sign := "AWS4-HMAC-SHA256\n" + amz_date + "\n" + scope + "\n" + _sha256_hex([]byte(canon_req))
+
+  key := _hmac_sha256([]byte("AWS4" + st.SecretKey), date)
+  key = _hmac_sha256(key, st.Region)
+  key = _hmac_sha256(key, "s3")
+  key = _hmac_sha256(key, "aws4_request")
+
+  req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+    st.AccessKey, scope, signed_hdr, hex.EncodeToString(_hmac_sha256(key, to_sign))))
+}
+
+func (st *S3Store) do(method, key string, query url.Values, hdr map[string]string, body []byte) (*http.Response, error) {
+  u,e := url.Parse(st.Endpoint + "/" + st.Bucket + "/" + key)
+  if e!=nil { return nil, e }
+  u.RawPath = _s3_escape(u.Path, true)
+  if query!=nil { u.RawQuery = _s3_query(query) }
+
+  req,e := http.NewRequest(method, u.String(), bytes.NewReader(body))
+  if e!=nil { return nil, e }
+  req.ContentLength = int64(len(body))
+  for k,v := range hdr { req.Header.Set(k, v) }
+
+  payload_hash := _s3_empty_sha256
+  if len(body)>0 { payload_hash = _sha256_hex(body) }
+  st.sign(req, payload_hash, time.Now())
+
+  client := st.Client
+  if client==nil { client = http.DefaultClient }
+
+  resp,e := client.Do(req)
+  if e!=nil { return nil, e }
+  if resp.StatusCode==http.StatusNotFound {
+    resp.Body.Close()
+    return nil, &os.PathError{ Op:method, Path:"s3://" + st.Bucket + "/" + key, Err:os.ErrNotExist }
+  }
+  if resp.StatusCode/100 != 2 {
+    msg,_ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+    resp.Body.Close()
+    return nil, fmt.Errorf("s3://%s/%s: %s %s: %s", st.Bucket, key, method, resp.Status, strings.TrimSpace(string(msg)))
+  }
+  return resp, nil
+}
+
+type _s3_reader struct {
+  st *S3Store
+  key string
+  size int64
+}
+
+func (r *_s3_reader) Size() int64 { return r.size }
+func (r *_s3_reader) Close() error { return nil }
+
+func (r *_s3_reader) ReadAt(p []byte, off int64) (int, error) {
+  if off>=r.size { return 0, io.EOF }
+  if len(p)==0 { return 0, nil }
+
+  end := off+int64(len(p))
+  if end>r.size { end = r.size }
+
+  resp,e := r.st.do("GET", r.key, nil, map[string]string{ "Range":fmt.Sprintf("bytes=%d-%d", off, end-1) }, nil)
+  if e!=nil { return 0, e }
+  defer resp.Body.Close()
+
+  // A server ignoring the range sends the whole object.
+  //
+  if resp.StatusCode==http.StatusOK {
+    _,e = io.CopyN(ioutil.Discard, resp.Body, off)
+    if e!=nil { return 0, e }
+  }
+
+  n,e := io.ReadFull(resp.Body, p[:end-off])
+  if e!=nil { return n, e }
+  if end-off < int64(len(p)) { return n, io.EOF }
+  return n, nil
+}
+
+func (st *S3Store) Open(key string) (StoreReader, error) {
+  resp,e := st.do("HEAD", key, nil, nil, nil)
+  if e!=nil { return nil, e }
+  resp.Body.Close()
+
+  size,e := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+  if e!=nil { return nil, fmt.Errorf("s3://%s/%s: bad Content-Length", st.Bucket, key) }
+
+  return &_s3_reader{ st:st, key:key, size:size }, nil
+}
+
+// Objects are written with a single PUT when the writer is closed.
+//
+type _s3_writer struct {
+  st *S3Store
+  key string
+  buf bytes.Buffer
+}
+
+func (w *_s3_writer) Write(p []byte) (int, error) { return w.buf.Write(p) }
+
+func (w *_s3_writer) Close() error {
+  resp,e := w.st.do("PUT", w.key, nil, nil, w.buf.Bytes())
+  if e!=nil { return e }
+  resp.Body.Close()
+  return nil
+}
+
+func (st *S3Store) Create(key string) (io.WriteCloser, error) {
+  return &_s3_writer{ st:st, key:key }, nil
+}
+
+type _s3_list_result struct {
+  Contents []struct {
+    Key string `xml:"Key"`
+  } `xml:"Contents"`
+  IsTruncated bool `xml:"IsTruncated"`
+  NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (st *S3Store) List(dir string) ([]string, error) {
+  prefix := dir
+  if len(prefix)>0 && !strings.HasSuffix(prefix, "/") { prefix += "/" }
+
+  keys := []string{}
+  token := ""
+  for {
+    q := url.Values{}
+    q.Set("list-type", "2")
+    q.Set("prefix", prefix)
+    q.Set("delimiter", "/")
+    if len(token)>0 { q.Set("continuation-token", token) }
+
+    resp,e := st.do("GET", "", q, nil, nil)
+    if e!=nil { return nil, e }
+
+    var res _s3_list_result
+    e = xml.NewDecoder(resp.Body).Decode(&res)
+    resp.Body.Close()
+    if e!=nil { return nil, fmt.Errorf("s3://%s/%s: %v", st.Bucket, prefix, e) }
+
+    for i:=0; i<len(res.Contents); i++ {
+      keys = append(keys, res.Contents[i].Key)
+    }
+
+    if !res.IsTruncated || len(res.NextContinuationToken)==0 { break }
+    token = res.NextContinuationToken
+  }
+
+  sort.Strings(keys)
+  return keys, nil
+}
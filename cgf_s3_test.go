@@ -0,0 +1,230 @@
+package cgf_test
+
+import "testing"
+import "fmt"
+import "io"
+import "os"
+import "sort"
+import "sync"
+import "bytes"
+import "strings"
+import "strconv"
+import "net/http"
+import "net/http/httptest"
+import "io/ioutil"
+import "encoding/xml"
+import "encoding/json"
+
+import "github.com/abeconnelly/cgf"
+import "github.com/abeconnelly/cgf/synth"
+
+// Stand-in for an S3 API server: one bucket held in memory, HEAD, GET
+// (with Range), PUT and ListObjectsV2 (two keys per page, to exercise
+// continuation).  Object GETs are counted.
+//
+type _s3_standin struct {
+  bucket string
+
+  lock sync.Mutex
+  objects map[string][]byte
+  gets int
+  unsigned int
+}
+
+func _new_s3_standin(t *testing.T, bucket string) *_s3_standin {
+  s := &_s3_standin{ bucket:bucket, objects:make(map[string][]byte) }
+
+  ts := httptest.NewServer(s)
+  t.Cleanup(ts.Close)
+
+  t.Setenv("CGF_S3_ENDPOINT", ts.URL)
+  t.Setenv("AWS_REGION", "us-east-1")
+  t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+  t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+  t.Setenv("AWS_SESSION_TOKEN", "")
+  return s
+}
+
+func (s *_s3_standin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  s.lock.Lock()
+  defer s.lock.Unlock()
+
+  if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") { s.unsigned++ }
+
+  p := strings.TrimPrefix(r.URL.Path, "/")
+  if p!=s.bucket && !strings.HasPrefix(p, s.bucket + "/") { http.Error(w, "no such bucket", http.StatusNotFound) ; return }
+  key := strings.TrimPrefix(strings.TrimPrefix(p, s.bucket), "/")
+
+  if r.Method=="GET" && r.URL.Query().Get("list-type")=="2" {
+    s.list(w, r)
+    return
+  }
+
+  switch r.Method {
+  case "PUT":
+    b,e := ioutil.ReadAll(r.Body)
+    if e!=nil { http.Error(w, e.Error(), http.StatusBadRequest) ; return }
+    s.objects[key] = b
+
+  case "HEAD":
+    b,ok := s.objects[key]
+    if !ok { w.WriteHeader(http.StatusNotFound) ; return }
+    w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+
+  case "GET":
+    b,ok := s.objects[key]
+    if !ok { http.Error(w, "no such key", http.StatusNotFound) ; return }
+    s.gets++
+
+    rng := r.Header.Get("Range")
+    if len(rng)==0 { w.Write(b) ; return }
+
+    var beg,end int
+    if _,e := fmt.Sscanf(rng, "bytes=%d-%d", &beg, &end) ; e!=nil || beg>end || end>=len(b) {
+      http.Error(w, "bad range " + rng, http.StatusRequestedRangeNotSatisfiable)
+      return
+    }
+    w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", beg, end, len(b)))
+    w.WriteHeader(http.StatusPartialContent)
+    w.Write(b[beg:end+1])
+
+  default:
+    http.Error(w, "unsupported", http.StatusMethodNotAllowed)
+  }
+}
+
+func (s *_s3_standin) list(w http.ResponseWriter, r *http.Request) {
+  prefix := r.URL.Query().Get("prefix")
+
+  keys := []string{}
+  for k := range s.objects {
+    if !strings.HasPrefix(k, prefix) || strings.Contains(k[len(prefix):], "/") { continue }
+    keys = append(keys, k)
+  }
+  sort.Strings(keys)
+
+  start := 0
+  if tok := r.URL.Query().Get("continuation-token") ; len(tok)>0 { start,_ = strconv.Atoi(tok) }
+  end := start+2
+  if end>len(keys) { end = len(keys) }
+
+  type content struct { Key string `xml:"Key"` }
+  res := struct {
+    XMLName xml.Name `xml:"ListBucketResult"`
+    Contents []content `xml:"Contents"`
+    IsTruncated bool `xml:"IsTruncated"`
+    NextContinuationToken string `xml:"NextContinuationToken,omitempty"`
+  }{}
+  for _,k := range keys[start:end] { res.Contents = append(res.Contents, content{ Key:k }) }
+  if end<len(keys) {
+    res.IsTruncated = true
+    res.NextContinuationToken = strconv.Itoa(end)
+  }
+
+  w.Header().Set("Content-Type", "application/xml")
+  xml.NewEncoder(w).Encode(res)
+}
+
+func (s *_s3_standin) get_count() int {
+  s.lock.Lock()
+  defer s.lock.Unlock()
+  return s.gets
+}
+
+func TestS3StoreReadWrite(t *testing.T) {
+  s3 := _new_s3_standin(t, "bkt")
+
+  b := make([]byte, 2*cgf.STORE_READ_CHUNK + 1000)
+  for i:=0; i<len(b); i++ { b[i] = byte(i*7 + i/251) }
+
+  e := cgf.StoreWriteFile("s3://bkt/dir/blob.bin", b)
+  if e!=nil { t.Fatal(e) }
+
+  // Whole object reads are a single GET.
+  //
+  n := s3.get_count()
+  rb,e := cgf.StoreReadFile("s3://bkt/dir/blob.bin")
+  if e!=nil { t.Fatal(e) }
+  if !bytes.Equal(rb, b) { t.Fatal("object read back differs") }
+  if g := s3.get_count()-n ; g!=1 { t.Errorf("StoreReadFile made %d GETs, want 1", g) }
+
+  // Range reads.
+  //
+  f,e := cgf.StoreOpen("s3://bkt/dir/blob.bin")
+  if e!=nil { t.Fatal(e) }
+  defer f.Close()
+  if f.Size()!=int64(len(b)) { t.Fatalf("size %d, want %d", f.Size(), len(b)) }
+
+  p := make([]byte, 100)
+  k,e := f.ReadAt(p, 12345)
+  if e!=nil || k!=len(p) || !bytes.Equal(p, b[12345:12345+100]) { t.Fatalf("ReadAt: %d %v", k, e) }
+  k,e = f.ReadAt(p, int64(len(b)-10))
+  if e!=io.EOF || k!=10 || !bytes.Equal(p[:10], b[len(b)-10:]) { t.Fatalf("ReadAt at the end: %d %v", k, e) }
+
+  // Streaming reads are one GET per chunk.
+  //
+  n = s3.get_count()
+  rb,e = ioutil.ReadAll(cgf.StoreSequentialReader(f))
+  if e!=nil { t.Fatal(e) }
+  if !bytes.Equal(rb, b) { t.Fatal("streamed object differs") }
+  want := (len(b) + cgf.STORE_READ_CHUNK - 1) / cgf.STORE_READ_CHUNK
+  if g := s3.get_count()-n ; g!=want { t.Errorf("StoreSequentialReader made %d GETs, want %d", g, want) }
+
+  _,e = cgf.StoreReadFile("s3://bkt/dir/nosuchobject")
+  if !os.IsNotExist(e) { t.Errorf("missing object: %v", e) }
+
+  if s3.unsigned>0 { t.Errorf("%d unsigned requests", s3.unsigned) }
+}
+
+func TestS3StoreList(t *testing.T) {
+  _new_s3_standin(t, "bkt")
+
+  for _,name := range []string{ "dir/a.cgf", "dir/b.cgf", "dir/c.txt", "dir/d.cgf", "dir/sub/e.cgf", "other/f.cgf" } {
+    e := cgf.StoreWriteFile("s3://bkt/" + name, []byte(name))
+    if e!=nil { t.Fatal(e) }
+  }
+
+  names,e := cgf.StoreList("s3://bkt/dir", ".cgf")
+  if e!=nil { t.Fatal(e) }
+  want := []string{ "s3://bkt/dir/a.cgf", "s3://bkt/dir/b.cgf", "s3://bkt/dir/d.cgf" }
+  if strings.Join(names, " ")!=strings.Join(want, " ") { t.Fatalf("listed %v, want %v", names, want) }
+
+  if !cgf.StoreIsDir("s3://bkt/dir") { t.Error("s3://bkt/dir isn't a directory") }
+  if cgf.StoreIsDir("s3://bkt/nodir") { t.Error("s3://bkt/nodir is a directory") }
+}
+
+// The query server over CGF files in the stand-in store.
+//
+func TestS3StoreServer(t *testing.T) {
+  p := synth.DefaultParams()
+  p.Samples = 2
+  res,cgf_bytes := _synth_fixture(t, p)
+
+  _new_s3_standin(t, "bkt")
+  for i,b := range cgf_bytes {
+    e := cgf.StoreWriteFile(fmt.Sprintf("s3://bkt/cgf/%s.cgf", res.Samples[i]), b)
+    if e!=nil { t.Fatal(e) }
+  }
+
+  srv,e := cgf.NewCGFServer("s3://bkt/cgf", 2)
+  if e!=nil { t.Fatal(e) }
+  if len(srv.Samples)!=len(res.Samples) { t.Fatalf("%d samples, want %d", len(srv.Samples), len(res.Samples)) }
+
+  hdri := _synth_header(t, cgf_bytes[0])
+  pathi,e := cgf.HeaderIntermediateLoadPath(&hdri, 0)
+  if e!=nil { t.Fatal(e) }
+
+  rec := httptest.NewRecorder()
+  srv.ServeHTTP(rec, httptest.NewRequest("GET", "/knot?sample=" + res.Samples[0] + "&tilepos=0000.00.0000", nil))
+  if rec.Code!=http.StatusOK { t.Fatalf("status %d: %s", rec.Code, rec.Body.String()) }
+
+  var r struct { Knot cgf.ServerKnot `json:"knot"` }
+  e = json.NewDecoder(rec.Body).Decode(&r)
+  if e!=nil { t.Fatal(e) }
+
+  knot := cgf.GetKnot(hdri.TileMap, pathi, 0)
+  if len(r.Knot.Alleles)!=len(knot) { t.Fatalf("%d alleles, want %d", len(r.Knot.Alleles), len(knot)) }
+  for a:=0; a<len(knot); a++ {
+    if e := _server_tiles_eq(r.Knot.Alleles[a], knot[a], 0) ; e!=nil { t.Fatalf("allele %d: %v", a, e) }
+  }
+}
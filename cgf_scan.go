@@ -0,0 +1,153 @@
+package cgf
+
+import "sort"
+import "math/bits"
+
+// Knot built from a tilemap entry, anchored at anchor_step.
+//
+func _tilemap_knot(tilemap []TileMapEntry, tm, anchor_step int) [][]TileInfo {
+  tia := make([][]TileInfo, len(tilemap[tm].Variant))
+  for allele:=0; allele<len(tilemap[tm].Variant); allele++ {
+    run_span:=0
+    for i:=0; i<len(tilemap[tm].Variant[allele]); i++ {
+      ti:=TileInfo{}
+      ti.Step = anchor_step+run_span
+      ti.Span = tilemap[tm].Span[allele][i]
+      ti.VarId = tilemap[tm].Variant[allele][i]
+      tia[allele] = append(tia[allele], ti)
+
+      run_span += ti.Span
+    }
+  }
+  return tia
+}
+
+// Knot from the final overflow record starting at variant_ints[0].
+//
+func _fofsi_tile_knot(variant_ints []int, anchor_step int) [][]TileInfo {
+  knot,_ := _fofsi_knot(variant_ints)
+
+  tia := make([][]TileInfo, 2)
+  for allele:=0; allele<2; allele++ {
+    run_span:=0
+    for i:=0; i<len(knot.varid[allele]); i++ {
+      ti:=TileInfo{}
+      ti.Step = anchor_step+run_span
+      ti.Span = knot.span[allele][i]
+      ti.VarId = knot.varid[allele][i]
+      tia[allele] = append(tia[allele], ti)
+
+      run_span += ti.Span
+    }
+  }
+  return tia
+}
+
+// Sequential knot decoder for one path.  Each call to next decodes the
+// knot anchored at the following step, keeping the overflow position,
+// the final overflow record and the in-word counter from the previous
+// call so that no step is looked at twice.
+//
+type _knot_cursor struct {
+  tilemap []TileMapEntry
+  pathi *PathIntermediate
+  step int
+
+  ovf_pos int
+  fof_rec int
+  fof_pos int
+  cache_counter int
+}
+
+func _new_knot_cursor(tilemap []TileMapEntry, pathi *PathIntermediate, beg int) *_knot_cursor {
+  cur := _knot_cursor{ tilemap:tilemap, pathi:pathi, step:beg }
+  cur.ovf_pos = _overflow_pos(pathi, beg)
+  cur.fof_rec = sort.SearchInts(pathi.fofsi.tilepos, beg)
+  if cur.fof_rec<len(pathi.fof_offset) {
+    cur.fof_pos = pathi.fof_offset[cur.fof_rec]
+  } else {
+    cur.fof_rec = 0
+  }
+  if beg%32 != 0 {
+    vec := pathi.VecUint64[beg/32]
+    cur.cache_counter = bits.OnesCount64((vec>>32) & ((1<<uint(beg%32))-1))
+  }
+  return &cur
+}
+
+// Knot anchored at cur.step (nil for steps in the middle of a spanning
+// knot), advancing to the next step.
+//
+func (cur *_knot_cursor) next() [][]TileInfo {
+  pathi := cur.pathi
+  step := cur.step
+  cur.step++
+
+  vec := pathi.VecUint64[step/32]
+  m := uint(step%32)
+
+  if m==0 { cur.cache_counter = 0 }
+
+  if (vec & (1<<(32+m))) == 0 {
+    tia := _tilemap_knot(cur.tilemap, 0, step)
+    _fill_knot_loq(tia, *pathi, step)
+    return _knot_ploidy(pathi, _knot_canon(pathi, tia))
+  }
+
+  hexit := 0xf
+  if cur.cache_counter < 8 {
+    hexit = int((vec >> (4*uint(cur.cache_counter))) & 0xf)
+  }
+  cur.cache_counter++
+
+  if hexit == 0 { return nil }
+
+  var tia [][]TileInfo
+
+  if hexit < 0xd {
+    tia = _tilemap_knot(cur.tilemap, hexit, step)
+  } else {
+    cur_ovf := cur.ovf_pos
+    cur.ovf_pos++
+
+    if pathi.ofsi.span_flag[cur_ovf] { return nil }
+
+    if !pathi.ofsi.final_overflow_flag[cur_ovf] {
+      tia = _tilemap_knot(cur.tilemap, pathi.ofsi.TileMap[cur_ovf], step)
+    } else {
+      for cur.fof_rec<len(pathi.fofsi.tilepos) && pathi.fofsi.tilepos[cur.fof_rec]<step {
+        cur.fof_pos += _skip_fofsi(pathi.fofsi.variant_ints[cur.fof_pos:])
+        cur.fof_rec++
+      }
+      if cur.fof_rec>=len(pathi.fofsi.tilepos) || pathi.fofsi.tilepos[cur.fof_rec]!=step { return nil }
+      tia = _fofsi_tile_knot(pathi.fofsi.variant_ints[cur.fof_pos:], step)
+    }
+  }
+
+  _fill_knot_loq(tia, *pathi, step)
+  return _knot_ploidy(pathi, _knot_canon(pathi, tia))
+}
+
+// Decode the knots anchored in [beg,end) in a single pass over the
+// Vector words, the overflow map and the final overflow records, calling
+// fn for each one.  The result for each knot is the same as GetKnot's;
+// steps in the middle of a spanning knot are skipped.  A non-nil error
+// from fn stops the scan and is returned.
+//
+func PathKnotScan(tilemap []TileMapEntry, pathi PathIntermediate, beg, end int, fn func(anchor_step int, knot [][]TileInfo) error) error {
+  if beg<0 { beg = 0 }
+  if end>pathi.ntile { end = pathi.ntile }
+  if beg>=end { return nil }
+
+  cur := _new_knot_cursor(tilemap, &pathi, beg)
+  for cur.step<end {
+    step := cur.step
+    tia := cur.next()
+    if tia==nil { continue }
+
+    e := fn(step, tia)
+    if e!=nil { return e }
+  }
+
+  return nil
+}
@@ -0,0 +1,354 @@
+package cgf
+
+import "fmt"
+import "sync"
+import "strings"
+import "strconv"
+import "net/http"
+import "encoding/json"
+import "path/filepath"
+import "container/list"
+
+// HTTP/JSON query server over a directory (local or any Store) of CGF
+// files.
+//
+// Headers of every *.cgf file in the directory are read once at start up
+// and kept in memory.  Paths are read and decoded on demand and kept in
+// an LRU cache bounded by a number of decoded paths.
+//
+//   GET /samples                                  sample list
+//   GET /knot?sample=S&tilepos=2c5.00.0104        knot anchored at a step
+//   GET /range?tilepos=2c5.00.0100-0110[&sample=S1,S2,...]
+//                                                 knots anchored in a step range
+//                                                 (end exclusive, all samples
+//                                                 with the path if none given)
+//   GET /concordance?a=S1&b=S2[&path=2c5]         tile allele concordance
+//
+// Sample names are the sample-id metadata entry, or the file name without
+// .cgf.  Tile positions are converted to each sample's tagset with
+// TagsetMap if it's set (see cgf_tagset.go).  Knots carry their phase
+// set, -1 on unphased paths (see cgf_phase.go).  Errors are returned as
+// {"error":"..."} with a 4xx/5xx status.
+//
+
+type CGFServerSample struct {
+  Name string `json:"name"`
+  File string `json:"file"`
+  Paths []int `json:"paths"`
+
+  href *HeaderRef
+  fn string
+}
+
+type CGFServer struct {
+  Dir string
+  Samples []*CGFServerSample
+  TagsetMap *TagsetMap
+
+  sample_idx map[string]int
+  mux *http.ServeMux
+
+  cache_max int
+  cache_lock sync.Mutex
+  cache_list *list.List
+  cache_map map[[2]int]*list.Element
+}
+
+type _path_cache_entry struct {
+  key [2]int
+  pathi *PathIntermediate
+}
+
+type ServerTile struct {
+  Tile string `json:"tile"`
+  Step int `json:"step"`
+  VarId int `json:"varid"`
+  Span int `json:"span"`
+  Nocall []int `json:"nocall,omitempty"`
+}
+
+type ServerKnot struct {
+  Step int `json:"step"`
+  PhaseSet int `json:"phase_set"`
+  Alleles [][]ServerTile `json:"alleles"`
+}
+
+func NewCGFServer(dir string, cache_paths int) (*CGFServer, error) {
+  if cache_paths<1 { cache_paths = 1 }
+
+  srv := CGFServer{ Dir:dir, cache_max:cache_paths }
+  srv.sample_idx = make(map[string]int)
+  srv.cache_list = list.New()
+  srv.cache_map = make(map[[2]int]*list.Element)
+
+  fns,e := StoreList(dir, ".cgf")
+  if e!=nil { return nil, e }
+
+  for i:=0; i<len(fns); i++ {
+    href,e := _read_header_ref_file(fns[i])
+    if e!=nil { return nil, fmt.Errorf("%s: %v", fns[i], e) }
+
+    name,ok := HeaderIntermediateGetMeta(&href.Header, CGF_META_SAMPLE_ID)
+    if !ok || len(name)==0 { name = strings.TrimSuffix(filepath.Base(fns[i]), ".cgf") }
+    if _,dup := srv.sample_idx[name] ; dup { return nil, fmt.Errorf("%s: duplicate sample name %s", fns[i], name) }
+
+    smp := CGFServerSample{ Name:name, File:filepath.Base(fns[i]), Paths:[]int{}, href:href, fn:fns[i] }
+    for path:=0; path<len(href.Header.StepPerPath); path++ {
+      if href.Header.StepPerPath[path]>0 { smp.Paths = append(smp.Paths, path) }
+    }
+
+    srv.sample_idx[name] = len(srv.Samples)
+    srv.Samples = append(srv.Samples, &smp)
+  }
+
+  srv.mux = http.NewServeMux()
+  srv.mux.HandleFunc("/samples", srv.handle_samples)
+  srv.mux.HandleFunc("/knot", srv.handle_knot)
+  srv.mux.HandleFunc("/range", srv.handle_range)
+  srv.mux.HandleFunc("/concordance", srv.handle_concordance)
+
+  return &srv, nil
+}
+
+func _read_header_ref_file(fn string) (*HeaderRef, error) {
+  f,e := StoreOpen(fn)
+  if e!=nil { return nil, e }
+  defer f.Close()
+
+  return ReadHeaderRef(f, f.Size())
+}
+
+func (srv *CGFServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  srv.mux.ServeHTTP(w, r)
+}
+
+// Decoded path, from the cache if it's there.
+//
+func (srv *CGFServer) load_path(sample, path int) (*PathIntermediate, error) {
+  key := [2]int{sample, path}
+
+  srv.cache_lock.Lock()
+  if ele,ok := srv.cache_map[key] ; ok {
+    srv.cache_list.MoveToFront(ele)
+    srv.cache_lock.Unlock()
+    return ele.Value.(*_path_cache_entry).pathi, nil
+  }
+  srv.cache_lock.Unlock()
+
+  smp := srv.Samples[sample]
+  if path<0 || path>=len(smp.href.Header.StepPerPath) || smp.href.Header.StepPerPath[path]==0 {
+    return nil, fmt.Errorf("sample %s has no path %x", smp.Name, path)
+  }
+
+  f,e := StoreOpen(smp.fn)
+  if e!=nil { return nil, e }
+  defer f.Close()
+
+  pathi,e := smp.href.ReadPath(f, path)
+  if e!=nil { return nil, fmt.Errorf("%s: %v", smp.File, e) }
+
+  srv.cache_lock.Lock()
+  defer srv.cache_lock.Unlock()
+
+  if ele,ok := srv.cache_map[key] ; ok {
+    srv.cache_list.MoveToFront(ele)
+    return ele.Value.(*_path_cache_entry).pathi, nil
+  }
+
+  srv.cache_map[key] = srv.cache_list.PushFront(&_path_cache_entry{ key:key, pathi:&pathi })
+  for srv.cache_list.Len() > srv.cache_max {
+    ele := srv.cache_list.Back()
+    delete(srv.cache_map, ele.Value.(*_path_cache_entry).key)
+    srv.cache_list.Remove(ele)
+  }
+
+  return &pathi, nil
+}
+
+func _server_json(w http.ResponseWriter, status int, v interface{}) {
+  w.Header().Set("Content-Type", "application/json")
+  w.WriteHeader(status)
+  json.NewEncoder(w).Encode(v)
+}
+
+func _server_error(w http.ResponseWriter, status int, e error) {
+  _server_json(w, status, map[string]string{ "error":e.Error() })
+}
+
+func (srv *CGFServer) sample_param(r *http.Request, key string) (int, error) {
+  name := r.URL.Query().Get(key)
+  if len(name)==0 { return -1, fmt.Errorf("missing parameter '%s'", key) }
+  idx,ok := srv.sample_idx[name]
+  if !ok { return -1, fmt.Errorf("unknown sample '%s'", name) }
+  return idx, nil
+}
+
+// path.ver.beg[-end] with hex fields and an exclusive end, end -1 if
+// it's left open.  Without a range the end is beg+1.
+//
+func _parse_tilepos_range(s string) (path, ver, beg, end int, err error) {
+  p := strings.Index(s, "-")
+  if p<0 {
+    path,ver,beg,err = ParseTilepos(s)
+    end = beg+1
+    return
+  }
+
+  path,ver,beg,err = ParseTilepos(s[:p])
+  if err!=nil { return }
+
+  end = -1
+  if p+1<len(s) {
+    var u64 int64
+    u64,err = strconv.ParseInt(s[p+1:], 16, 64)
+    if err!=nil { return }
+    end = int(u64)
+  }
+  return
+}
+
+func _server_knot(pathi *PathIntermediate, path, ver, step int, knot [][]TileInfo) ServerKnot {
+  sk := ServerKnot{ Step:step, PhaseSet:KnotPhaseSet(*pathi, step), Alleles:make([][]ServerTile, len(knot)) }
+  for allele:=0; allele<len(knot); allele++ {
+    sk.Alleles[allele] = make([]ServerTile, len(knot[allele]))
+    for i:=0; i<len(knot[allele]); i++ {
+      ti := knot[allele][i]
+      sk.Alleles[allele][i] = ServerTile{
+        Tile:TileIdString(path, ver, ti),
+        Step:ti.Step, VarId:ti.VarId, Span:ti.Span,
+        Nocall:NocallStartLenAbsolute(ti.NocallStartLen) }
+      if len(ti.NocallStartLen)==0 { sk.Alleles[allele][i].Nocall = nil }
+    }
+  }
+  return sk
+}
+
+func (srv *CGFServer) handle_samples(w http.ResponseWriter, r *http.Request) {
+  _server_json(w, http.StatusOK, srv.Samples)
+}
+
+func (srv *CGFServer) handle_knot(w http.ResponseWriter, r *http.Request) {
+  sample,e := srv.sample_param(r, "sample")
+  if e!=nil { _server_error(w, http.StatusBadRequest, e) ; return }
+
+  path,ver,step,e := ParseTilepos(r.URL.Query().Get("tilepos"))
+  if e!=nil { _server_error(w, http.StatusBadRequest, fmt.Errorf("tilepos: %v", e)) ; return }
+
+  path,ver,step,e = HeaderIntermediateResolveTilepos(&srv.Samples[sample].href.Header, srv.TagsetMap, path, ver, step)
+  if e!=nil { _server_error(w, http.StatusBadRequest, e) ; return }
+
+  pathi,e := srv.load_path(sample, path)
+  if e!=nil { _server_error(w, http.StatusNotFound, e) ; return }
+  if step<0 || step>=pathi.ntile { _server_error(w, http.StatusNotFound, fmt.Errorf("step %x out of range", step)) ; return }
+
+  knot := GetKnot(srv.Samples[sample].href.Header.TileMap, *pathi, step)
+  if knot==nil {
+    _server_json(w, http.StatusOK, map[string]interface{}{ "sample":srv.Samples[sample].Name, "step":step, "spanned":true })
+    return
+  }
+
+  _server_json(w, http.StatusOK, map[string]interface{}{ "sample":srv.Samples[sample].Name, "knot":_server_knot(pathi, path, ver, step, knot) })
+}
+
+func (srv *CGFServer) handle_range(w http.ResponseWriter, r *http.Request) {
+  path,ver,beg,end,e := _parse_tilepos_range(r.URL.Query().Get("tilepos"))
+  if e!=nil { _server_error(w, http.StatusBadRequest, fmt.Errorf("tilepos: %v", e)) ; return }
+
+  samples := make([]int, 0, len(srv.Samples))
+  if names := r.URL.Query().Get("sample") ; len(names)>0 {
+    for _,name := range strings.Split(names, ",") {
+      idx,ok := srv.sample_idx[name]
+      if !ok { _server_error(w, http.StatusBadRequest, fmt.Errorf("unknown sample '%s'", name)) ; return }
+      samples = append(samples, idx)
+    }
+  } else {
+    for i:=0; i<len(srv.Samples); i++ {
+      spp := srv.Samples[i].href.Header.StepPerPath
+      if path>=0 && path<len(spp) && spp[path]>0 { samples = append(samples, i) }
+    }
+  }
+
+  type sample_knots struct {
+    Sample string `json:"sample"`
+    Knots []ServerKnot `json:"knots"`
+  }
+
+  res := make([]sample_knots, 0, len(samples))
+  for _,sample := range samples {
+    spath,sver,sbeg,send,e := HeaderIntermediateResolveRange(&srv.Samples[sample].href.Header, srv.TagsetMap, path, ver, beg, end)
+    if e!=nil { _server_error(w, http.StatusBadRequest, fmt.Errorf("%s: %v", srv.Samples[sample].Name, e)) ; return }
+
+    pathi,e := srv.load_path(sample, spath)
+    if e!=nil { _server_error(w, http.StatusNotFound, e) ; return }
+
+    sk := sample_knots{ Sample:srv.Samples[sample].Name, Knots:[]ServerKnot{} }
+    e = PathKnotScan(srv.Samples[sample].href.Header.TileMap, *pathi, sbeg, _range_end(send, pathi.ntile), func(anchor_step int, knot [][]TileInfo) error {
+      sk.Knots = append(sk.Knots, _server_knot(pathi, spath, sver, anchor_step, knot))
+      return nil
+    })
+    if e!=nil { _server_error(w, http.StatusInternalServerError, e) ; return }
+
+    res = append(res, sk)
+  }
+
+  _server_json(w, http.StatusOK, map[string]interface{}{ "path":path, "beg":beg, "end":_range_end(end, -1), "samples":res })
+}
+
+func _range_end(end, n int) int {
+  if end<0 || (n>=0 && end>n) { return n }
+  return end
+}
+
+// Concordance over the paths both samples have (or just `path`):
+// differing tile alleles and comparable steps as for PathPairDistance,
+// and the fraction of compared alleles that agree.
+//
+func (srv *CGFServer) handle_concordance(w http.ResponseWriter, r *http.Request) {
+  a,e := srv.sample_param(r, "a")
+  if e!=nil { _server_error(w, http.StatusBadRequest, e) ; return }
+  b,e := srv.sample_param(r, "b")
+  if e!=nil { _server_error(w, http.StatusBadRequest, e) ; return }
+
+  ha := &srv.Samples[a].href.Header
+  hb := &srv.Samples[b].href.Header
+  if string(ha.TileMapBytes)!=string(hb.TileMapBytes) {
+    _server_error(w, http.StatusBadRequest, fmt.Errorf("samples have different tile maps"))
+    return
+  }
+
+  paths := make([]int, 0, len(srv.Samples[a].Paths))
+  if p := r.URL.Query().Get("path") ; len(p)>0 {
+    u64,e := strconv.ParseInt(p, 16, 64)
+    if e!=nil { _server_error(w, http.StatusBadRequest, fmt.Errorf("path: %v", e)) ; return }
+    paths = append(paths, int(u64))
+  } else {
+    for _,path := range srv.Samples[a].Paths {
+      if path<len(hb.StepPerPath) && hb.StepPerPath[path]>0 { paths = append(paths, path) }
+    }
+  }
+
+  diff,comparable,alleles := 0,0,0
+  for _,path := range paths {
+    pa,e := srv.load_path(a, path)
+    if e!=nil { _server_error(w, http.StatusNotFound, e) ; return }
+    pb,e := srv.load_path(b, path)
+    if e!=nil { _server_error(w, http.StatusNotFound, e) ; return }
+
+    d,m,e := PathPairDistance(ha.TileMap, *pa, *pb)
+    if e!=nil { _server_error(w, http.StatusInternalServerError, fmt.Errorf("path %x: %v", path, e)) ; return }
+
+    ploidy := HeaderIntermediatePathPloidy(ha, path)
+    if p := HeaderIntermediatePathPloidy(hb, path) ; p<ploidy { ploidy = p }
+
+    diff += d
+    comparable += m
+    alleles += ploidy*m
+  }
+
+  res := map[string]interface{}{
+    "a":srv.Samples[a].Name, "b":srv.Samples[b].Name, "paths":len(paths),
+    "diff":diff, "comparable":comparable }
+  if alleles>0 { res["concordance"] = 1.0 - float64(diff)/float64(alleles) }
+
+  _server_json(w, http.StatusOK, res)
+}
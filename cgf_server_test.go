@@ -0,0 +1,166 @@
+package cgf_test
+
+import "testing"
+import "fmt"
+import "strings"
+import "net/http"
+import "net/http/httptest"
+import "encoding/json"
+import "path/filepath"
+
+import "github.com/abeconnelly/cgf"
+import "github.com/abeconnelly/cgf/synth"
+
+func _server_fixture(t *testing.T) (*httptest.Server, *synth.Result, [][]byte) {
+  p := synth.DefaultParams()
+  p.Paths = 2
+  p.Steps = 200
+  p.Samples = 2
+  res,cgf_bytes := _synth_fixture(t, p)
+
+  srv,e := cgf.NewCGFServer(filepath.Dir(res.CGFFiles[0]), 4)
+  if e!=nil { t.Fatal(e) }
+
+  ts := httptest.NewServer(srv)
+  t.Cleanup(ts.Close)
+  return ts, res, cgf_bytes
+}
+
+func _server_get(t *testing.T, ts *httptest.Server, url string, want_status int, v interface{}) {
+  t.Helper()
+
+  resp,e := http.Get(ts.URL + url)
+  if e!=nil { t.Fatal(e) }
+  defer resp.Body.Close()
+
+  if resp.StatusCode!=want_status { t.Fatalf("GET %s: status %d, want %d", url, resp.StatusCode, want_status) }
+  if ct := resp.Header.Get("Content-Type") ; ct!="application/json" { t.Fatalf("GET %s: content type %q", url, ct) }
+  if e := json.NewDecoder(resp.Body).Decode(v) ; e!=nil { t.Fatalf("GET %s: %v", url, e) }
+}
+
+func TestServerSamples(t *testing.T) {
+  ts,res,_ := _server_fixture(t)
+
+  var samples []cgf.CGFServerSample
+  _server_get(t, ts, "/samples", http.StatusOK, &samples)
+
+  if len(samples)!=len(res.Samples) { t.Fatalf("%d samples, want %d", len(samples), len(res.Samples)) }
+  for i,smp := range samples {
+    if smp.Name!=res.Samples[i] { t.Errorf("sample %d named %s, want %s", i, smp.Name, res.Samples[i]) }
+    if smp.File!=filepath.Base(res.CGFFiles[i]) { t.Errorf("sample %s file %s", smp.Name, smp.File) }
+    if len(smp.Paths)!=2 || smp.Paths[0]!=0 || smp.Paths[1]!=1 { t.Errorf("sample %s paths %v", smp.Name, smp.Paths) }
+  }
+}
+
+func _server_tiles_eq(st []cgf.ServerTile, ti []cgf.TileInfo, path int) error {
+  if len(st)!=len(ti) { return fmt.Errorf("%d tiles, want %d", len(st), len(ti)) }
+  for i:=0; i<len(st); i++ {
+    if st[i].Step!=ti[i].Step || st[i].VarId!=ti[i].VarId || st[i].Span!=ti[i].Span {
+      return fmt.Errorf("tile %d is %+v, want %+v", i, st[i], ti[i])
+    }
+    if id := cgf.TileIdString(path, 0, ti[i]) ; st[i].Tile!=id { return fmt.Errorf("tile id %s, want %s", st[i].Tile, id) }
+    if (len(st[i].Nocall)>0) != (len(ti[i].NocallStartLen)>0) { return fmt.Errorf("tile %s nocalls differ", st[i].Tile) }
+  }
+  return nil
+}
+
+// Every step of a path, against the knots decoded from the file itself.
+//
+func TestServerKnot(t *testing.T) {
+  ts,res,cgf_bytes := _server_fixture(t)
+
+  path := 1
+  hdri := _synth_header(t, cgf_bytes[0])
+  pathi,e := cgf.HeaderIntermediateLoadPath(&hdri, path)
+  if e!=nil { t.Fatal(e) }
+
+  nknot := 0
+  for step:=0; step<hdri.StepPerPath[path]; step++ {
+    var r struct {
+      Sample string `json:"sample"`
+      Spanned bool `json:"spanned"`
+      Knot *cgf.ServerKnot `json:"knot"`
+    }
+    _server_get(t, ts, fmt.Sprintf("/knot?sample=%s&tilepos=%04x.00.%04x", res.Samples[0], path, step), http.StatusOK, &r)
+    if r.Sample!=res.Samples[0] { t.Fatalf("step %04x: sample %s", step, r.Sample) }
+
+    knot := cgf.GetKnot(hdri.TileMap, pathi, step)
+    if knot==nil {
+      if !r.Spanned || r.Knot!=nil { t.Fatalf("step %04x: expected a spanned step", step) }
+      continue
+    }
+    nknot++
+
+    if r.Knot==nil { t.Fatalf("step %04x: no knot", step) }
+    if r.Knot.Step!=step { t.Fatalf("step %04x: knot at %04x", step, r.Knot.Step) }
+    if len(r.Knot.Alleles)!=len(knot) { t.Fatalf("step %04x: %d alleles, want %d", step, len(r.Knot.Alleles), len(knot)) }
+    for a:=0; a<len(knot); a++ {
+      if e := _server_tiles_eq(r.Knot.Alleles[a], knot[a], path) ; e!=nil { t.Fatalf("step %04x allele %d: %v", step, a, e) }
+    }
+  }
+  if nknot==0 { t.Fatal("no knots decoded") }
+}
+
+func TestServerRange(t *testing.T) {
+  ts,res,cgf_bytes := _server_fixture(t)
+
+  var r struct {
+    Samples []struct {
+      Sample string `json:"sample"`
+      Knots []cgf.ServerKnot `json:"knots"`
+    } `json:"samples"`
+  }
+  _server_get(t, ts, "/range?tilepos=0000.00.0010-0040", http.StatusOK, &r)
+  if len(r.Samples)!=len(res.Samples) { t.Fatalf("%d samples, want %d", len(r.Samples), len(res.Samples)) }
+
+  for i,sk := range r.Samples {
+    hdri := _synth_header(t, cgf_bytes[i])
+    pathi,e := cgf.HeaderIntermediateLoadPath(&hdri, 0)
+    if e!=nil { t.Fatal(e) }
+
+    n := 0
+    e = cgf.PathKnotScan(hdri.TileMap, pathi, 0x10, 0x40, func(anchor_step int, knot [][]cgf.TileInfo) error {
+      if n>=len(sk.Knots) { return fmt.Errorf("missing knot at %04x", anchor_step) }
+      if sk.Knots[n].Step!=anchor_step { return fmt.Errorf("knot %d at %04x, want %04x", n, sk.Knots[n].Step, anchor_step) }
+      for a:=0; a<len(knot); a++ {
+        if e := _server_tiles_eq(sk.Knots[n].Alleles[a], knot[a], 0) ; e!=nil { return fmt.Errorf("step %04x allele %d: %v", anchor_step, a, e) }
+      }
+      n++
+      return nil
+    })
+    if e!=nil { t.Fatalf("%s: %v", sk.Sample, e) }
+    if n!=len(sk.Knots) { t.Fatalf("%s: %d knots, want %d", sk.Sample, len(sk.Knots), n) }
+  }
+}
+
+func TestServerErrors(t *testing.T) {
+  ts,res,_ := _server_fixture(t)
+  s0,s1 := res.Samples[0],res.Samples[1]
+
+  tests := []struct {
+    url string
+    status int
+  }{
+    { "/knot?tilepos=0000.00.0000", http.StatusBadRequest },
+    { "/knot?sample=nosuchsample&tilepos=0000.00.0000", http.StatusBadRequest },
+    { "/knot?sample=" + s0 + "&tilepos=zz", http.StatusBadRequest },
+    { "/knot?sample=" + s0 + "&tilepos=0000.01.0000", http.StatusBadRequest },
+    { "/knot?sample=" + s0 + "&tilepos=0005.00.0000", http.StatusNotFound },
+    { "/knot?sample=" + s0 + "&tilepos=0000.00.ffff", http.StatusNotFound },
+    { "/range?tilepos=0000.00.0000-0010&sample=" + s0 + ",nosuchsample", http.StatusBadRequest },
+    { "/range?tilepos=0000.00.00zz", http.StatusBadRequest },
+    { "/concordance?a=" + s0, http.StatusBadRequest },
+    { "/concordance?a=" + s0 + "&b=" + s1 + "&path=5", http.StatusNotFound },
+  }
+
+  for _,tc := range tests {
+    var r map[string]interface{}
+    _server_get(t, ts, tc.url, tc.status, &r)
+    msg,ok := r["error"].(string)
+    if !ok || len(strings.TrimSpace(msg))==0 { t.Errorf("GET %s: no error message in %v", tc.url, r) }
+  }
+
+  var r map[string]interface{}
+  _server_get(t, ts, "/concordance?a=" + s0 + "&b=" + s0, http.StatusOK, &r)
+  if c,ok := r["concordance"].(float64) ; !ok || c!=1.0 { t.Errorf("self concordance %v", r["concordance"]) }
+}
@@ -0,0 +1,212 @@
+package cgf
+
+import "io"
+import "os"
+import "sort"
+import "bufio"
+import "strings"
+import "io/ioutil"
+import "path/filepath"
+
+import "github.com/abeconnelly/cglf"
+
+// Storage backends for CGF, index and library files.
+//
+// Names are local file names or URLs, `s3://bucket/key`, for objects in
+// an S3 API store (AWS, or Arvados Keep through keep-web's S3 API).  The
+// Store* functions pick the backend from the name, so anything taking a
+// file name takes either.
+//
+
+type StoreReader interface {
+  io.ReaderAt
+  io.Closer
+  Size() int64
+}
+
+type Store interface {
+
+  // Open an object for (range) reads.
+  //
+  Open(name string) (StoreReader, error)
+
+  // Create or replace an object.  The object is complete once the
+  // writer is closed.
+  //
+  Create(name string) (io.WriteCloser, error)
+
+  // Names, usable with Open, of the objects directly under the
+  // "directory" name, sorted.
+  //
+  List(dir string) ([]string, error)
+}
+
+// Backend and the backend's name for the object.
+//
+func StoreForName(name string) (Store, string, error) {
+  if strings.HasPrefix(name, "s3://") {
+    return S3StoreForName(name)
+  }
+  return &LocalStore{}, name, nil
+}
+
+// Whole object, with a single read (one GET for remote stores).
+//
+func StoreReadFile(name string) ([]byte, error) {
+  st,key,e := StoreForName(name)
+  if e!=nil { return nil, e }
+
+  r,e := st.Open(key)
+  if e!=nil { return nil, e }
+  defer r.Close()
+
+  b := make([]byte, r.Size())
+  n,e := r.ReadAt(b, 0)
+  if e==io.EOF && n==len(b) { e = nil }
+  if e!=nil { return nil, e }
+  return b, nil
+}
+
+// Size of the reads of StoreSequentialReader.
+//
+const STORE_READ_CHUNK int = 8<<20
+
+// Reader streaming an opened object from the start, STORE_READ_CHUNK
+// bytes per read of r (small reads would be a range request each on
+// remote stores).
+//
+func StoreSequentialReader(r StoreReader) io.Reader {
+  return bufio.NewReaderSize(io.NewSectionReader(r, 0, r.Size()), STORE_READ_CHUNK)
+}
+
+func StoreWriteFile(name string, b []byte) error {
+  st,key,e := StoreForName(name)
+  if e!=nil { return e }
+
+  w,e := st.Create(key)
+  if e!=nil { return e }
+  _,e = w.Write(b)
+  if e!=nil { w.Close() ; return e }
+  return w.Close()
+}
+
+func StoreCreate(name string) (io.WriteCloser, error) {
+  st,key,e := StoreForName(name)
+  if e!=nil { return nil, e }
+  return st.Create(key)
+}
+
+func StoreOpen(name string) (StoreReader, error) {
+  st,key,e := StoreForName(name)
+  if e!=nil { return nil, e }
+  return st.Open(key)
+}
+
+// Full names of the objects directly under dir ending in suffix.
+//
+func StoreList(dir, suffix string) ([]string, error) {
+  st,key,e := StoreForName(dir)
+  if e!=nil { return nil, e }
+
+  names,e := st.List(key)
+  if e!=nil { return nil, e }
+
+  res := []string{}
+  for i:=0; i<len(names); i++ {
+    if !strings.HasSuffix(names[i], suffix) { continue }
+    if s3,ok := st.(*S3Store) ; ok {
+      names[i] = "s3://" + s3.Bucket + "/" + names[i]
+    }
+    res = append(res, names[i])
+  }
+  return res, nil
+}
+
+// Whether name is a directory (local), or a prefix with objects under
+// it (remote).
+//
+func StoreIsDir(name string) bool {
+  st,key,e := StoreForName(name)
+  if e!=nil { return false }
+  if _,local := st.(*LocalStore) ; local {
+    fi,e := os.Stat(key)
+    return e==nil && fi.IsDir()
+  }
+  names,e := st.List(key)
+  return e==nil && len(names)>0
+}
+
+func StoreJoin(dir, name string) string {
+  if strings.HasPrefix(dir, "s3://") {
+    return strings.TrimSuffix(dir, "/") + "/" + name
+  }
+  return filepath.Join(dir, name)
+}
+
+// SGLF library from any store.  cglf only loads local files, so remote
+// libraries go through a temporary file.
+//
+func LoadSGLF(name string) (cglf.SGLF, error) {
+  st,key,e := StoreForName(name)
+  if e!=nil { return cglf.SGLF{}, e }
+  if _,local := st.(*LocalStore) ; local {
+    return cglf.LoadGenomeLibraryCSV(key)
+  }
+
+  b,e := StoreReadFile(name)
+  if e!=nil { return cglf.SGLF{}, e }
+
+  f,e := ioutil.TempFile("", "cgf-sglf-")
+  if e!=nil { return cglf.SGLF{}, e }
+  defer os.Remove(f.Name())
+
+  _,e = f.Write(b)
+  if e2:=f.Close() ; e==nil { e = e2 }
+  if e!=nil { return cglf.SGLF{}, e }
+
+  return cglf.LoadGenomeLibraryCSV(f.Name())
+}
+
+//--------------
+// Local files
+//
+
+type LocalStore struct { }
+
+type _local_reader struct {
+  *os.File
+  size int64
+}
+
+func (r *_local_reader) Size() int64 { return r.size }
+
+func (st *LocalStore) Open(name string) (StoreReader, error) {
+  f,e := os.Open(name)
+  if e!=nil { return nil, e }
+
+  fi,e := f.Stat()
+  if e!=nil { f.Close() ; return nil, e }
+
+  return &_local_reader{ File:f, size:fi.Size() }, nil
+}
+
+func (st *LocalStore) Create(name string) (io.WriteCloser, error) {
+  if dir := filepath.Dir(name) ; dir!="." {
+    e := os.MkdirAll(dir, 0755)
+    if e!=nil { return nil, e }
+  }
+  return os.Create(name)
+}
+
+func (st *LocalStore) List(dir string) ([]string, error) {
+  fis,e := ioutil.ReadDir(dir)
+  if e!=nil { return nil, e }
+
+  names := make([]string, 0, len(fis))
+  for i:=0; i<len(fis); i++ {
+    if fis[i].IsDir() { continue }
+    names = append(names, filepath.Join(dir, fis[i].Name()))
+  }
+  sort.Strings(names)
+  return names, nil
+}
@@ -10,6 +10,12 @@ type CGFContext struct {
   TileMapArray    []TileMapEntry
   TileMapLookup   map[string]TileMapEntry
   TileMapPosition map[string]int
+
+  // Paths are encoded with their canon table, if any (see cgf_canon.go)
+  CanonTable      CanonTable
+
+  // Alleles of unphased paths are put in canonical order (see cgf_phase.go)
+  Phase           map[int]PathPhase
 }
 
 
@@ -45,7 +51,6 @@ type CGF struct {
 
 
 type PathStruct struct {
-  Name string
   Vector []uint64
 
   Overflow OverflowStruct
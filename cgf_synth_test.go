@@ -0,0 +1,32 @@
+package cgf_test
+
+import "testing"
+import "io/ioutil"
+
+import "github.com/abeconnelly/cgf"
+import "github.com/abeconnelly/cgf/synth"
+
+// Synthetic library, FastJ and CGF files in a temporary directory, with
+// the CGF bytes of every sample.
+//
+func _synth_fixture(tb testing.TB, p synth.Params) (*synth.Result, [][]byte) {
+  tb.Helper()
+
+  res,e := synth.Generate(tb.TempDir(), p)
+  if e!=nil { tb.Fatal(e) }
+
+  cgf_bytes := make([][]byte, len(res.CGFFiles))
+  for i,fn := range res.CGFFiles {
+    cgf_bytes[i],e = ioutil.ReadFile(fn)
+    if e!=nil { tb.Fatal(e) }
+  }
+  return res, cgf_bytes
+}
+
+func _synth_header(tb testing.TB, cgf_bytes []byte) cgf.HeaderIntermediate {
+  tb.Helper()
+
+  hdri,dn := cgf.HeaderIntermediateFromBytes(cgf_bytes)
+  if dn<0 { tb.Fatal("could not construct header from bytes") }
+  return hdri
+}
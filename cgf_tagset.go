@@ -0,0 +1,267 @@
+package cgf
+
+import "fmt"
+import "sort"
+import "bufio"
+import "bytes"
+import "strings"
+import "strconv"
+
+import "github.com/abeconnelly/dlug"
+
+// Tagset version extension record (CGF_EXT_TAGSET):
+//
+//   PathCount dlug
+//   Ver       [PathCount]dlug
+//
+// Ver is one more than the tagset version the path was encoded against
+// (the middle field of its tile IDs), 0 if it wasn't recorded.  Files
+// without the record, or paths past PathCount, accept tile positions in
+// any tagset.
+//
+// A tile position in another tagset than the file's is an error unless a
+// tagset mapping is given, a text file with lines of
+//
+//   <path>.<ver>.<beg>[-<end>]  <path>.<ver>.<beg>
+//
+// taking steps beg..end (inclusive, hex) of the first tagset to the
+// consecutive steps starting at beg of the second.  Mappings work both
+// ways.  Blank lines and lines starting with '#' are skipped.
+//
+
+const CGF_EXT_TAGSET int = 5
+
+func _header_tagsets(hdri *HeaderIntermediate) []int {
+  ver := make([]int, hdri.pathcount)
+  for i:=0; i<len(ver); i++ { ver[i] = -1 }
+
+  b,ok := HeaderIntermediateGetExt(hdri, CGF_EXT_TAGSET)
+  if !ok { return ver }
+
+  n:=0
+  npath,dn := dlug.ConvertUint64(b[n:])
+  n+=dn
+  for i:=0; i<int(npath) && n<len(b); i++ {
+    v,dn := dlug.ConvertUint64(b[n:])
+    n+=dn
+    if i<len(ver) { ver[i] = int(v)-1 }
+  }
+  return ver
+}
+
+// Tagset version of the path, -1 if it wasn't recorded.
+//
+func HeaderIntermediatePathTagset(hdri *HeaderIntermediate, path int) int {
+  ver := _header_tagsets(hdri)
+  if path<0 || path>=len(ver) { return -1 }
+  return ver[path]
+}
+
+// Tagset version of the tile ids of a loaded path (0 if the file didn't
+// record it).
+//
+func PathTagset(pathi PathIntermediate) int {
+  return pathi.tagset
+}
+
+// Add the tagset versions of the file's paths (0 where unrecorded) to
+// tagsets, keyed by path, for ids over a cohort.  Files disagreeing on a
+// path's tagset are an error.
+//
+func MergePathTagsets(tagsets map[int]int, hdri *HeaderIntermediate) error {
+  ver := _header_tagsets(hdri)
+  for path:=0; path<len(hdri.StepPerPath) && path<len(ver); path++ {
+    if hdri.StepPerPath[path]==0 { continue }
+    v := ver[path]
+    if v<0 { v = 0 }
+    if w,ok := tagsets[path] ; ok && w!=v {
+      return fmt.Errorf("path %04x encoded against tagset %02x, other files use tagset %02x", path, v, w)
+    }
+    tagsets[path] = v
+  }
+  return nil
+}
+
+// Distinct tagset versions recorded in the file, sorted.
+//
+func HeaderIntermediateTagsets(hdri *HeaderIntermediate) []int {
+  seen := make(map[int]bool)
+  res := []int{}
+  for _,v := range _header_tagsets(hdri) {
+    if v<0 || seen[v] { continue }
+    seen[v] = true
+    res = append(res, v)
+  }
+  sort.Ints(res)
+  return res
+}
+
+// Record the tagset version of a path (-1 to clear it).
+//
+func HeaderIntermediateSetPathTagset(hdri *HeaderIntermediate, path, ver int) error {
+  v := _header_tagsets(hdri)
+  if path<0 || path>=len(v) { return fmt.Errorf("path %x out of range", path) }
+  v[path] = ver
+
+  any := false
+  for i:=0; i<len(v); i++ {
+    if v[i]>=0 { any = true ; break }
+  }
+
+  if !any {
+    HeaderIntermediateSetExt(hdri, CGF_EXT_TAGSET, nil)
+    return nil
+  }
+
+  b := make([]byte, 0, len(v)+8)
+  b = append(b, dlug.MarshalUint64(uint64(len(v)))...)
+  for i:=0; i<len(v); i++ {
+    b = append(b, dlug.MarshalUint64(uint64(v[i]+1))...)
+  }
+  HeaderIntermediateSetExt(hdri, CGF_EXT_TAGSET, b)
+  return nil
+}
+
+type _tagset_range struct {
+  beg, end int
+  to_path, to_beg int
+}
+
+type TagsetMap struct {
+  // keyed by {path, ver, to_ver}, sorted by beg
+  //
+  ranges map[[3]int][]_tagset_range
+}
+
+func _parse_tagset_range(s string) (path, ver, beg, end int, err error) {
+  p := strings.Index(s, "-")
+  if p<0 {
+    path,ver,beg,err = ParseTilepos(s)
+    end = beg
+    return
+  }
+
+  path,ver,beg,err = ParseTilepos(s[:p])
+  if err!=nil { return }
+
+  var u64 int64
+  u64,err = strconv.ParseInt(s[p+1:], 16, 64)
+  end = int(u64)
+  return
+}
+
+func TagsetMapFromBytes(b []byte) (*TagsetMap, error) {
+  tmap := TagsetMap{ ranges:make(map[[3]int][]_tagset_range) }
+
+  add := func(path, ver, to_ver int, r _tagset_range) {
+    k := [3]int{ path, ver, to_ver }
+    tmap.ranges[k] = append(tmap.ranges[k], r)
+  }
+
+  scan := bufio.NewScanner(bytes.NewReader(b))
+  line_no := 0
+  for scan.Scan() {
+    l := strings.TrimSpace(scan.Text())
+    line_no++
+    if len(l)==0 || l[0]=='#' { continue }
+
+    fields := strings.Fields(l)
+    if len(fields)!=2 { return nil, fmt.Errorf("invalid tagset mapping (line %d)", line_no) }
+
+    path,ver,beg,end,e := _parse_tagset_range(fields[0])
+    if e!=nil { return nil, fmt.Errorf("%v (line %d)", e, line_no) }
+    if end<beg { return nil, fmt.Errorf("empty step range (line %d)", line_no) }
+
+    to_path,to_ver,to_beg,e := ParseTilepos(fields[1])
+    if e!=nil { return nil, fmt.Errorf("%v (line %d)", e, line_no) }
+    if ver==to_ver { return nil, fmt.Errorf("mapping within tagset %02x (line %d)", ver, line_no) }
+
+    add(path, ver, to_ver, _tagset_range{ beg:beg, end:end, to_path:to_path, to_beg:to_beg })
+    add(to_path, to_ver, ver, _tagset_range{ beg:to_beg, end:to_beg+end-beg, to_path:path, to_beg:beg })
+  }
+  if e:=scan.Err() ; e!=nil { return nil, e }
+
+  for k := range tmap.ranges {
+    r := tmap.ranges[k]
+    sort.Slice(r, func(i, j int) bool { return r[i].beg < r[j].beg })
+  }
+
+  return &tmap, nil
+}
+
+func LoadTagsetMap(name string) (*TagsetMap, error) {
+  b,e := StoreReadFile(name)
+  if e!=nil { return nil, e }
+  tmap,e := TagsetMapFromBytes(b)
+  if e!=nil { return nil, fmt.Errorf("%s: %v", name, e) }
+  return tmap, nil
+}
+
+// Path and step in tagset to_ver of step in tagset ver.
+//
+func (tmap *TagsetMap) Map(path, ver, step, to_ver int) (int, int, error) {
+  r := tmap.ranges[[3]int{ path, ver, to_ver }]
+  i := sort.Search(len(r), func(i int) bool { return r[i].beg > step }) - 1
+  if i<0 || step>r[i].end {
+    return -1, -1, fmt.Errorf("%04x.%02x.%04x has no tagset %02x position in the mapping", path, ver, step, to_ver)
+  }
+  return r[i].to_path, r[i].to_beg + step - r[i].beg, nil
+}
+
+// Path, tagset version and step in the file for a tile position given in
+// tagset ver.  Positions are mapped with tmap when the tagsets differ
+// (an error if tmap is nil).
+//
+func HeaderIntermediateResolveTilepos(hdri *HeaderIntermediate, tmap *TagsetMap, path, ver, step int) (int, int, int, error) {
+  return _resolve_tilepos(_header_path_tagset(hdri), tmap, path, ver, step)
+}
+
+// As HeaderIntermediateResolveTilepos for the step range [beg,end).  An
+// end of -1 (open) is left as is.
+//
+func HeaderIntermediateResolveRange(hdri *HeaderIntermediate, tmap *TagsetMap, path, ver, beg, end int) (int, int, int, int, error) {
+  return _resolve_range(_header_path_tagset(hdri), tmap, path, ver, beg, end)
+}
+
+func _header_path_tagset(hdri *HeaderIntermediate) func(int) (int, error) {
+  return func(path int) (int, error) { return HeaderIntermediatePathTagset(hdri, path), nil }
+}
+
+// path_tagset gives the tagset version a path was encoded against, -1 if
+// unknown, so positions can be resolved against a CGF or a tile index.
+//
+func _resolve_tilepos(path_tagset func(int) (int, error), tmap *TagsetMap, path, ver, step int) (int, int, int, error) {
+  file_ver,e := path_tagset(path)
+  if e!=nil { return -1, -1, -1, e }
+  if file_ver<0 || file_ver==ver { return path, ver, step, nil }
+
+  if tmap==nil {
+    return -1, -1, -1, fmt.Errorf("tile position %04x.%02x.%04x is in tagset %02x, but path %04x was encoded against tagset %02x (give a tagset mapping to convert)",
+      path, ver, step, ver, path, file_ver)
+  }
+
+  to_path,to_step,e := tmap.Map(path, ver, step, file_ver)
+  if e!=nil { return -1, -1, -1, e }
+
+  v,e := path_tagset(to_path)
+  if e!=nil { return -1, -1, -1, e }
+  if v>=0 && v!=file_ver {
+    return -1, -1, -1, fmt.Errorf("%04x.%02x.%04x maps to path %04x, which was encoded against tagset %02x", path, ver, step, to_path, v)
+  }
+
+  return to_path, file_ver, to_step, nil
+}
+
+func _resolve_range(path_tagset func(int) (int, error), tmap *TagsetMap, path, ver, beg, end int) (int, int, int, int, error) {
+  rpath,rver,rbeg,e := _resolve_tilepos(path_tagset, tmap, path, ver, beg)
+  if e!=nil || end<0 || rver==ver { return rpath, rver, rbeg, end, e }
+  if end<=beg { return rpath, rver, rbeg, rbeg, nil }
+
+  epath,_,rlast,e := _resolve_tilepos(path_tagset, tmap, path, ver, end-1)
+  if e!=nil { return -1, -1, -1, -1, e }
+  if epath!=rpath || rlast<rbeg {
+    return -1, -1, -1, -1, fmt.Errorf("%04x.%02x.%04x-%04x doesn't map to a single step range in tagset %02x", path, ver, beg, end, rver)
+  }
+
+  return rpath, rver, rbeg, rlast+1, nil
+}
@@ -14,6 +14,10 @@ type HeaderIntermediate struct {
   libver string
   pathcount int
 
+  // top byte of the PathCount word (CGF_HEADER_FLAG_*)
+  //
+  flags uint64
+
   TileMap []TileMapEntry
   TileMapBytes []byte
 
@@ -24,6 +28,11 @@ type HeaderIntermediate struct {
   //pathis []pathintermediate
   pathis []PathIntermediate
 
+  // optional extension records (see cgf_ext.go)
+  //
+  ext []HeaderExtRecord
+  ext_err error
+
 }
 
 type PathIntermediate struct {
@@ -34,6 +43,29 @@ type PathIntermediate struct {
   ofsi OverflowIntermediate
   fofsi FinalOverflowIntermediate
   loqi LoqIntermediate
+
+  // alleles returned by the knot lookups (see cgf_ploidy.go), 0 for
+  // diploid
+  //
+  ploidy int
+
+  // stored varid swaps of the path's canon table (see cgf_canon.go)
+  //
+  canon map[int]int
+
+  // phased flag and phase blocks (see cgf_phase.go)
+  //
+  phase PathPhase
+
+  // tagset version of the tile ids (see cgf_tagset.go), 0 if it wasn't
+  // recorded
+  //
+  tagset int
+
+  // random access tables (see cgf_rank.go)
+  //
+  ovf_rank []int32
+  fof_offset []int
 }
 
 type CGFIntermediate struct {
@@ -177,6 +209,14 @@ func _add_knot(knot *CGFIntermediate, allele, step_idx int, ti TileInfo, sglf *c
     }
 
   } else {
+
+    // The first tile is found by its suffix tag, which a tile spanning
+    // from step 0 shares with the last single step tile it covers.
+    //
+    if ti.Span>1 {
+      return -1, fmt.Errorf("first tile of the path spans %d steps: a spanning tile at step 0 can't be encoded (it's looked up by its suffix tag)", ti.Span)
+    }
+
     sglf_info,ok = sglf.SfxTagLookup[ti.SfxTag]
   }
 
@@ -348,6 +388,8 @@ func HeaderIntermediateFromBytes(b []byte) (HeaderIntermediate,int) {
 
   n:=0
 
+  if len(b)<8 { return hdri,-1 }
+
   for i:=0; i<8; i++ { hdri.magic[i] = b[n+i] }
   n+=8
 
@@ -355,7 +397,7 @@ func HeaderIntermediateFromBytes(b []byte) (HeaderIntermediate,int) {
   n+=dn
 
   ns := int(dummy)
-
+  if (n+ns)>len(b) { return hdri,-1 }
 
   hdri.ver = string(b[n:n+ns])
   n+=ns
@@ -364,6 +406,7 @@ func HeaderIntermediateFromBytes(b []byte) (HeaderIntermediate,int) {
   n+=dn
 
   ns = int(dummy)
+  if (n+ns+16)>len(b) { return hdri,-1 }
 
   hdri.libver = string(b[n:n+ns])
   n+=ns
@@ -371,12 +414,14 @@ func HeaderIntermediateFromBytes(b []byte) (HeaderIntermediate,int) {
   dummy = byte2uint64(b[n:n+8])
   n+=8
 
-  hdri.pathcount = int(dummy)
+  hdri.flags = dummy & CGF_HEADER_FLAG_MASK
+  hdri.pathcount = int(dummy &^ CGF_HEADER_FLAG_MASK)
 
   dummy = byte2uint64(b[n:n+8])
   n+=8
 
   tilemaplen := int(dummy)
+  if (n+tilemaplen+(16*hdri.pathcount)+8)>len(b) { return hdri,-1 }
 
   hdri.TileMapBytes = b[n:n+tilemaplen]
   hdri.TileMap = UnpackTileMap(b[n:n+tilemaplen])
@@ -396,13 +441,24 @@ func HeaderIntermediateFromBytes(b []byte) (HeaderIntermediate,int) {
 
   hdri.PathBytes = make([][]byte, hdri.pathcount)
 
+  // A short buffer (a truncated file or only the header) gives
+  // short or empty path blocks, which HeaderIntermediateVerifyPath
+  // reports.
+  //
   PathBytes := b[n:]
   for i:=1; i<=hdri.pathcount; i++  {
     dn := int(hdri.path_offset[i] - hdri.path_offset[i-1])
     if dn==0 { continue }
 
-    z:=hdri.path_offset[i-1] ; _ = z
-    hdri.PathBytes[i-1] = PathBytes[hdri.path_offset[i-1]:hdri.path_offset[i-1]+dn]
+    s := hdri.path_offset[i-1]
+    e := s+dn
+    if s>len(PathBytes) { s = len(PathBytes) }
+    if e>len(PathBytes) { e = len(PathBytes) }
+    hdri.PathBytes[i-1] = PathBytes[s:e]
+  }
+
+  if hdri.path_offset[hdri.pathcount] < len(PathBytes) {
+    hdri.ext,hdri.ext_err = HeaderExtFromBytes(PathBytes[hdri.path_offset[hdri.pathcount]:])
   }
 
   return hdri,n
@@ -426,7 +482,7 @@ func BytesFromHeaderIntermediate(hdri HeaderIntermediate) []byte {
   s = []byte(hdri.libver)
   b = append(b, s...)
 
-  tobyte64(buf, uint64(hdri.pathcount))
+  tobyte64(buf, uint64(hdri.pathcount) | hdri.flags)
   b = append(b, buf[0:8]...)
 
   tobyte64(buf, uint64(len(hdri.TileMapBytes)))
@@ -938,7 +994,7 @@ func LoqIntermediateCmp(loqi0, loqi1 LoqIntermediate) error {
 
   for i:=0; i<len(loqi0.homflag); i++ {
     if loqi0.homflag[i] != loqi1.homflag[i] {
-      return fmt.Errorf( fmt.Sprintf("homflag mismatch at %d: %d != %d", i, loqi0.homflag[i], loqi1.homflag[i]) )
+      return fmt.Errorf( fmt.Sprintf("homflag mismatch at %d: %v != %v", i, loqi0.homflag[i], loqi1.homflag[i]) )
     }
   }
 
@@ -1481,6 +1537,8 @@ func PathIntermediateFromBytes(b []byte) (PathIntermediate,int) {
   pathi.loqi,dn = LoqIntermediateFromBytes(b[n:])
   n+=dn
 
+  _build_path_rank(&pathi)
+
   return pathi,n
 }
 
@@ -1589,10 +1647,15 @@ func (ctx *CGFContext) EmitPathBytes(path_idx int, allele_path [][]TileInfo) ([]
   debug_output:=false
   //debug_output:=true
 
+  allele_path,e := _diploid_allele_path(allele_path)
+  if e!=nil { return nil, e }
+
   max_tile := 0
 
   cgf := ctx.CGF ; _ = cgf
   sglf := ctx.SGLF
+  canon := ctx.CanonTable[path_idx]
+  unphased := ctx.Phase[path_idx].Phase==CGF_PHASE_UNPHASED
 
   span_sum := 0
   step_idx0,step_idx1 := 0,0
@@ -1632,17 +1695,13 @@ func (ctx *CGFContext) EmitPathBytes(path_idx int, allele_path [][]TileInfo) ([]
       //fmt.Printf(">> step_idx0 %d (%d), step_idx1 %d (%d)\n", step_idx0, len(allele_path[0]), step_idx1, len(allele_path[1]))
 
       span_count,e := _add_knot(&knot, 0, step_idx0, allele_path[0][step_idx0], sglf)
-      if e!=nil {
-        panic(e)
-      }
+      if e!=nil { return nil, e }
 
       step_idx0++
       span_sum -= span_count
     } else {
       span_count,e := _add_knot(&knot, 1, step_idx1, allele_path[1][step_idx1], sglf)
-      if e!=nil {
-        panic(e)
-      }
+      if e!=nil { return nil, e }
 
       step_idx1++
       span_sum += span_count
@@ -1661,6 +1720,8 @@ func (ctx *CGFContext) EmitPathBytes(path_idx int, allele_path [][]TileInfo) ([]
     if span_sum==0 {
 
       _knot_tot_span(&knot)
+      _knot_canon_encode(canon, &knot)
+      if unphased { ctx._knot_unphased_order(&knot) }
       knot.TileMapKey = create_tilemap_string_lookup2(knot.varid[0], knot.span[0], knot.varid[1], knot.span[1])
       tileKnot = append(tileKnot, knot)
 
@@ -0,0 +1,315 @@
+// Synthetic tile libraries, FastJ samples and CGF files for testing.
+//
+// A library has, for every step of every path, a canonical tile
+// (variant 0), a few other single step variants and sometimes variants
+// spanning more than one step.  Adjacent tiles share TAG_LEN base tags
+// as in a real library.  Samples are diploid: each allele walks the path
+// picking variants independently, so heterozygosity, spanning knots and
+// nocall runs all show up at rates set in Params.
+//
+//   p := synth.DefaultParams()
+//   p.Steps = 1000
+//   res,e := synth.Generate("/tmp/synth", p)
+//
+// writes /tmp/synth/lib.sglf, /tmp/synth/fastj/<sample>/<path>.fj and
+// /tmp/synth/<sample>.cgf.
+//
+package synth
+
+import "fmt"
+import "io"
+import "os"
+import "bufio"
+import "math/rand"
+import "crypto/md5"
+import "path/filepath"
+
+import "github.com/abeconnelly/autoio"
+import "github.com/abeconnelly/cglf"
+import "github.com/abeconnelly/cgf"
+
+type Params struct {
+  Seed int64
+
+  Paths int
+  Steps int
+  Samples int
+
+  // Single step variants per step are 1..MaxVariant.  A step has
+  // spanning variants (2..MaxSpan steps) with probability SpanRate.
+  //
+  MaxVariant int
+  SpanRate float64
+  MaxSpan int
+
+  // Per allele and step: probability of a non-canonical variant, of
+  // taking a spanning variant where there is one, and of a nocall run
+  // (1..MaxNocall bases) in the tile.
+  //
+  Het float64
+  SpanKnotRate float64
+  NocallRate float64
+  MaxNocall int
+}
+
+func DefaultParams() Params {
+  return Params{
+    Seed:1, Paths:1, Steps:300, Samples:3,
+    MaxVariant:4, SpanRate:0.1, MaxSpan:3,
+    Het:0.3, SpanKnotRate:0.3, NocallRate:0.05, MaxNocall:5 }
+}
+
+type Tile struct {
+  Step int
+  VarId int
+  Span int
+  Seq string
+}
+
+// Lib[path][step] holds the variants of the tiles starting at step.
+//
+type Library struct {
+  Lib [][][]Tile
+}
+
+// Haplotype tiles of a sample, Path[path][allele].  Seq has nocalls
+// filled in as 'n'.
+//
+type Sample struct {
+  Name string
+  Path [][][]Tile
+}
+
+type Result struct {
+  LibraryFile string
+  Samples []string
+  FastjFiles [][]string
+  CGFFiles []string
+}
+
+func _rand_seq(rnd *rand.Rand, n int) string {
+  b := make([]byte, n)
+  for i:=0; i<n; i++ { b[i] = "acgt"[rnd.Intn(4)] }
+  return string(b)
+}
+
+func NewLibrary(rnd *rand.Rand, p Params) *Library {
+  lib := Library{ Lib:make([][][]Tile, p.Paths) }
+
+  for path:=0; path<p.Paths; path++ {
+    tags := make([]string, p.Steps+1)
+    for i:=0; i<len(tags); i++ { tags[i] = _rand_seq(rnd, cgf.TAG_LEN) }
+
+    tile_seq := func(step, span int) string {
+      pfx,sfx := "",""
+      if step>0 { pfx = tags[step] }
+      if step+span<p.Steps { sfx = tags[step+span] }
+      return pfx + _rand_seq(rnd, span*(40+rnd.Intn(60))) + sfx
+    }
+
+    lib.Lib[path] = make([][]Tile, p.Steps)
+    for step:=0; step<p.Steps; step++ {
+      nvar := 1
+      if p.MaxVariant>1 { nvar += rnd.Intn(p.MaxVariant) }
+      for v:=0; v<nvar; v++ {
+        lib.Lib[path][step] = append(lib.Lib[path][step], Tile{ Step:step, VarId:v, Span:1, Seq:tile_seq(step, 1) })
+      }
+
+      // The encoder finds the first tile of a path by its suffix tag,
+      // which a spanning tile would share with the tiles of a later
+      // step, so step 0 never gets one.
+      //
+      if p.MaxSpan>1 && step>0 && step+1<p.Steps && rnd.Float64()<p.SpanRate {
+        span := 2+rnd.Intn(p.MaxSpan-1)
+        if step+span>p.Steps { span = p.Steps-step }
+        v := len(lib.Lib[path][step])
+        lib.Lib[path][step] = append(lib.Lib[path][step], Tile{ Step:step, VarId:v, Span:span, Seq:tile_seq(step, span) })
+      }
+    }
+  }
+
+  return &lib
+}
+
+func (lib *Library) WriteSGLF(w io.Writer) error {
+  bw := bufio.NewWriter(w)
+  for path:=0; path<len(lib.Lib); path++ {
+    for step:=0; step<len(lib.Lib[path]); step++ {
+      for _,t := range lib.Lib[path][step] {
+        fmt.Fprintf(bw, "%04x.00.%04x.%03x+%x,%s,%s\n", path, t.Step, t.VarId, t.Span,
+          cgf.Md5sum2str(md5.Sum([]byte(t.Seq))), t.Seq)
+      }
+    }
+  }
+  return bw.Flush()
+}
+
+func (lib *Library) NewSample(rnd *rand.Rand, p Params, name string) *Sample {
+  smp := Sample{ Name:name, Path:make([][][]Tile, len(lib.Lib)) }
+
+  for path:=0; path<len(lib.Lib); path++ {
+    nstep := len(lib.Lib[path])
+    smp.Path[path] = make([][]Tile, 2)
+
+    for allele:=0; allele<2; allele++ {
+      for step:=0; step<nstep; {
+        vars := lib.Lib[path][step]
+
+        t := vars[0]
+        if vars[len(vars)-1].Span>1 && rnd.Float64()<p.SpanKnotRate {
+          t = vars[len(vars)-1]
+        } else if rnd.Float64()<p.Het {
+          alt := []Tile{}
+          for _,v := range vars[1:] {
+            if v.Span==1 { alt = append(alt, v) }
+          }
+          if len(alt)>0 { t = alt[rnd.Intn(len(alt))] }
+        }
+
+        if p.MaxNocall>0 && rnd.Float64()<p.NocallRate {
+          b := []byte(t.Seq)
+          l := 1+rnd.Intn(p.MaxNocall)
+          if len(b) > 2*cgf.TAG_LEN+l {
+            s := cgf.TAG_LEN + rnd.Intn(len(b)-2*cgf.TAG_LEN-l)
+            for k:=s; k<s+l; k++ { b[k] = 'n' }
+          }
+          t.Seq = string(b)
+        }
+
+        smp.Path[path][allele] = append(smp.Path[path][allele], t)
+        step += t.Span
+      }
+    }
+  }
+
+  return &smp
+}
+
+// FastJ for one path of the sample.  The allele goes in the last field
+// of the tile ID.
+//
+func (smp *Sample) WriteFastj(w io.Writer, path int) error {
+  bw := bufio.NewWriter(w)
+
+  nstep := 0
+  for _,t := range smp.Path[path][0] { nstep += t.Span }
+
+  for allele:=0; allele<len(smp.Path[path]); allele++ {
+    for _,t := range smp.Path[path][allele] {
+      start := t.Step==0
+      end := t.Step+t.Span==nstep
+      start_tag,end_tag := "",""
+      if !start { start_tag = t.Seq[:cgf.TAG_LEN] }
+      if !end { end_tag = t.Seq[len(t.Seq)-cgf.TAG_LEN:] }
+
+      nocall := 0
+      for k:=0; k<len(t.Seq); k++ {
+        if t.Seq[k]=='n' { nocall++ }
+      }
+
+      fmt.Fprintf(bw, ">{\"tileID\":\"%04x.00.%04x.%03x\",\"md5sum\":\"%s\",\"seedTileLength\":%d,\"startTile\":%v,\"endTile\":%v,\"startTag\":\"%s\",\"endTag\":\"%s\",\"nocallCount\":%d,\"notes\":[]}\n",
+        path, t.Step, allele, cgf.Md5sum2str(md5.Sum([]byte(t.Seq))), t.Span, start, end, start_tag, end_tag, nocall)
+      for k:=0; k<len(t.Seq); k+=50 {
+        e := k+50
+        if e>len(t.Seq) { e = len(t.Seq) }
+        fmt.Fprintf(bw, "%s\n", t.Seq[k:e])
+      }
+      fmt.Fprintf(bw, "\n")
+    }
+  }
+
+  return bw.Flush()
+}
+
+// Encode FastJ files, one per path (path i from fastj_fns[i]), into a
+// CGF the same way the append action does.
+//
+func EncodeCGF(sglf *cglf.SGLF, fastj_fns []string, sample_id string) ([]byte, error) {
+  cgf_bytes := cgf.CGFDefaultHeaderBytes()
+  hdri,dn := cgf.HeaderIntermediateFromBytes(cgf_bytes)
+  if dn<0 { return nil, fmt.Errorf("could not construct header from bytes") }
+
+  ctx := cgf.CGFContext{}
+  _cgf := cgf.CGF{}
+  _cgf.PathBytes = make([][]byte, 0, 1024)
+  cgf.CGFFillHeader(&_cgf, cgf_bytes)
+  ctx.CGF = &_cgf
+  ctx.SGLF = sglf
+  ctx.ConstructTileMapLookup()
+
+  for path:=0; path<len(fastj_fns); path++ {
+    ain,e := autoio.OpenReadScanner(fastj_fns[path])
+    if e!=nil { return nil, e }
+    allele_path,tagset,e := cgf.LoadSampleFastjTagset(&ain)
+    ain.Close()
+    if e!=nil { return nil, fmt.Errorf("%s: %v", fastj_fns[path], e) }
+
+    path_bytes,e := ctx.EmitPathBytes(path, allele_path)
+    if e!=nil { return nil, fmt.Errorf("%s: %v", fastj_fns[path], e) }
+
+    cgf.HeaderIntermediateAddPath(&hdri, path, path_bytes)
+    e = cgf.HeaderIntermediateSetPathPloidy(&hdri, path, len(allele_path))
+    if e!=nil { return nil, e }
+    e = cgf.HeaderIntermediateSetPathTagset(&hdri, path, tagset)
+    if e!=nil { return nil, e }
+  }
+
+  if len(sample_id)>0 {
+    e := cgf.HeaderIntermediateSetMeta(&hdri, cgf.CGF_META_SAMPLE_ID, sample_id)
+    if e!=nil { return nil, e }
+  }
+
+  return cgf.CGFBytesFromIntermediate(&hdri)
+}
+
+func _write_file(fn string, fill func(w io.Writer) error) error {
+  e := os.MkdirAll(filepath.Dir(fn), 0755)
+  if e!=nil { return e }
+
+  f,e := os.Create(fn)
+  if e!=nil { return e }
+
+  e = fill(f)
+  if e2:=f.Close() ; e==nil { e = e2 }
+  return e
+}
+
+// Library, FastJ and CGF files for p.Samples random samples in dir.
+//
+func Generate(dir string, p Params) (*Result, error) {
+  if p.Paths<1 || p.Steps<1 || p.Samples<0 { return nil, fmt.Errorf("need at least one path and step") }
+
+  rnd := rand.New(rand.NewSource(p.Seed))
+  lib := NewLibrary(rnd, p)
+
+  res := Result{ LibraryFile:filepath.Join(dir, "lib.sglf") }
+  e := _write_file(res.LibraryFile, lib.WriteSGLF)
+  if e!=nil { return nil, e }
+
+  sglf,e := cglf.LoadGenomeLibraryCSV(res.LibraryFile)
+  if e!=nil { return nil, e }
+
+  for i:=0; i<p.Samples; i++ {
+    smp := lib.NewSample(rnd, p, fmt.Sprintf("synth%03d", i))
+
+    fns := make([]string, len(smp.Path))
+    for path:=0; path<len(smp.Path); path++ {
+      fns[path] = filepath.Join(dir, "fastj", smp.Name, fmt.Sprintf("%04x.fj", path))
+      e = _write_file(fns[path], func(w io.Writer) error { return smp.WriteFastj(w, path) })
+      if e!=nil { return nil, e }
+    }
+
+    cgf_bytes,e := EncodeCGF(&sglf, fns, smp.Name)
+    if e!=nil { return nil, fmt.Errorf("%s: %v", smp.Name, e) }
+
+    cgf_fn := filepath.Join(dir, smp.Name + ".cgf")
+    e = _write_file(cgf_fn, func(w io.Writer) error { _,e := w.Write(cgf_bytes) ; return e })
+    if e!=nil { return nil, e }
+
+    res.Samples = append(res.Samples, smp.Name)
+    res.FastjFiles = append(res.FastjFiles, fns)
+    res.CGFFiles = append(res.CGFFiles, cgf_fn)
+  }
+
+  return &res, nil
+}
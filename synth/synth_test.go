@@ -0,0 +1,125 @@
+package synth_test
+
+import "testing"
+import "strings"
+import "math/rand"
+import "io/ioutil"
+
+import "github.com/abeconnelly/cgf"
+import "github.com/abeconnelly/cgf/synth"
+
+// Library and samples as Generate builds them for p.
+//
+func _samples(p synth.Params) []*synth.Sample {
+  rnd := rand.New(rand.NewSource(p.Seed))
+  lib := synth.NewLibrary(rnd, p)
+
+  smp := []*synth.Sample{}
+  for i:=0; i<p.Samples; i++ {
+    smp = append(smp, lib.NewSample(rnd, p, ""))
+  }
+  return smp
+}
+
+// Generated CGFs decode to the tiles the samples were built from.
+//
+func TestGenerate(t *testing.T) {
+  p := synth.DefaultParams()
+  p.Seed = 2
+  p.Paths = 2
+  p.Steps = 200
+  p.Samples = 2
+
+  res,e := synth.Generate(t.TempDir(), p)
+  if e!=nil { t.Fatal(e) }
+  if len(res.CGFFiles)!=p.Samples || len(res.FastjFiles)!=p.Samples { t.Fatalf("%d CGF files, want %d", len(res.CGFFiles), p.Samples) }
+
+  smps := _samples(p)
+  nspan,nloq := 0,0
+
+  for i,fn := range res.CGFFiles {
+    b,e := ioutil.ReadFile(fn)
+    if e!=nil { t.Fatal(e) }
+    hdri,dn := cgf.HeaderIntermediateFromBytes(b)
+    if dn<0 { t.Fatalf("%s: could not construct header from bytes", fn) }
+
+    if id,_ := cgf.HeaderIntermediateGetMeta(&hdri, cgf.CGF_META_SAMPLE_ID) ; id!=res.Samples[i] {
+      t.Errorf("%s: sample id %q, want %q", fn, id, res.Samples[i])
+    }
+
+    for path:=0; path<p.Paths; path++ {
+      if hdri.StepPerPath[path]!=p.Steps { t.Fatalf("%s: path %d has %d steps, want %d", fn, path, hdri.StepPerPath[path], p.Steps) }
+
+      pathi,e := cgf.HeaderIntermediateLoadPath(&hdri, path)
+      if e!=nil { t.Fatal(e) }
+
+      got := make([][]cgf.TileInfo, 2)
+      e = cgf.PathKnotScan(hdri.TileMap, pathi, 0, p.Steps, func(anchor_step int, knot [][]cgf.TileInfo) error {
+        for a:=0; a<len(knot); a++ { got[a] = append(got[a], knot[a]...) }
+        return nil
+      })
+      if e!=nil { t.Fatal(e) }
+
+      for a:=0; a<2; a++ {
+        want := smps[i].Path[path][a]
+        if len(got[a])!=len(want) { t.Fatalf("%s: path %d allele %d has %d tiles, want %d", fn, path, a, len(got[a]), len(want)) }
+        for k:=0; k<len(want); k++ {
+          g,w := got[a][k],want[k]
+          if g.Step!=w.Step || g.VarId!=w.VarId || g.Span!=w.Span {
+            t.Fatalf("%s: path %d allele %d tile %d is %d.%d+%d, want %d.%d+%d", fn, path, a, k, g.Step, g.VarId, g.Span, w.Step, w.VarId, w.Span)
+          }
+          if (len(g.NocallStartLen)>0) != strings.Contains(w.Seq, "n") {
+            t.Fatalf("%s: path %d allele %d step %d nocalls differ", fn, path, a, w.Step)
+          }
+          if w.Span>1 { nspan++ }
+          if len(g.NocallStartLen)>0 { nloq++ }
+        }
+      }
+    }
+  }
+
+  if nspan==0 || nloq==0 { t.Errorf("%d spanning tiles, %d nocall tiles", nspan, nloq) }
+}
+
+// Non-canonical draws never fall back to variant 0.
+//
+func TestSampleHet(t *testing.T) {
+  p := synth.DefaultParams()
+  p.Steps = 500
+  p.Samples = 1
+  p.Het = 1
+  p.SpanKnotRate = 0
+  p.NocallRate = 0
+
+  rnd := rand.New(rand.NewSource(p.Seed))
+  lib := synth.NewLibrary(rnd, p)
+  smp := lib.NewSample(rnd, p, "het")
+
+  for a:=0; a<2; a++ {
+    for _,tile := range smp.Path[0][a] {
+      nalt := 0
+      for _,v := range lib.Lib[0][tile.Step][1:] {
+        if v.Span==1 { nalt++ }
+      }
+      if nalt>0 && tile.VarId==0 { t.Fatalf("allele %d step %d: variant 0 drawn as non-canonical", a, tile.Step) }
+      if tile.Span!=1 { t.Fatalf("allele %d step %d: spanning tile with SpanKnotRate 0", a, tile.Step) }
+    }
+  }
+}
+
+// Every seed gives CGFs that encode, whatever the library's spanning
+// tiles.
+//
+func TestGenerateSeeds(t *testing.T) {
+  p := synth.DefaultParams()
+  p.Paths = 2
+  p.Steps = 20
+  p.Samples = 2
+  p.SpanRate = 0.5
+  p.SpanKnotRate = 1
+
+  for seed:=int64(1); seed<=50; seed++ {
+    p.Seed = seed
+    if _,e := synth.Generate(t.TempDir(), p) ; e!=nil { t.Fatalf("seed %d: %v", seed, e) }
+  }
+}